@@ -2,37 +2,104 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"strings"
-	"time"
+	"path/filepath"
+	"strconv"
+	"sync"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
-	serialport "go.bug.st/serial"
 )
 
 // App struct
 type App struct {
-	ctx         context.Context
-	monitorPort serialport.Port
-	stopMonitor chan bool
-	lineBuffer  string // Буфер для накопления неполных строк
+	ctx   context.Context
+	ports *PortManager // Все открытые порты; флешер и монитор получают их в аренду
+
+	monitorMu     sync.Mutex
+	monitor       *serialMonitor    // Текущий мониторинг, nil если не идет
+	symbols       *ELFSymbolizer    // ELF файл прошивки для расшифровки паник, nil если не выбран
+	logOptions    MonitorLogOptions // Запись мониторинга в файлы
+	rawMode       bool              // Монитор отдает байты как есть, без разбора на строки
+	autoReconnect bool              // Монитор ждет устройство после отключения вместо остановки
+	triggers      triggerSet        // Правила монитора, проверяются в горутине чтения
+
+	flashMu     sync.Mutex
+	flashCancel context.CancelFunc // Отмена текущей прошивки, nil если прошивка не идет
+
+	portWatcher *PortWatcher // Отслеживание подключения и отключения портов
+}
+
+// FlashOptions - параметры прошивки, передаваемые из frontend
+type FlashOptions struct {
+	Diff   bool   `json:"diff"`   // Записывать только изменившиеся регионы по 64 КБ
+	Trace  bool   `json:"trace"`  // Записывать трассу протокола в файл
+	Reset  string `json:"reset"`  // Стратегия сброса (RESET_*) или последовательность "D0|R1|W100|..."
+	Before string `json:"before"` // Действие перед прошивкой (BEFORE_*)
+	After  string `json:"after"`  // Действие после прошивки (AFTER_*)
+
+	// Запустить мониторинг на том же порту сразу после прошивки, чтобы не потерять
+	// первые строки загрузки. MonitorBaud - скорость монитора
+	Monitor     bool `json:"monitor"`
+	MonitorBaud int  `json:"monitorBaud"`
+}
+
+// flasherConfig возвращает параметры флешера для порта portName
+func (o FlashOptions) flasherConfig(portName string) FlasherConfig {
+	config := FlasherConfig{PortName: portName, Reset: o.Reset, Before: o.Before, After: o.After}
+
+	// Аппаратный сброс в приложение выполняется уже после запуска монитора.
+	// Запуск и программный сброс идут командами загрузчика и остаются за флешером
+	if o.Monitor && o.After == AFTER_HARD_RESET {
+		config.After = AFTER_NO_RESET
+	}
+
+	return config
 }
 
 // NewApp creates a new App application struct
 func NewApp() *App {
-	return &App{}
+	return &App{ports: NewPortManager()}
 }
 
-// ListPorts возвращает список COM-портов
-func (a *App) ListPorts() ([]string, error) {
-	return serialport.GetPortsList()
+// ListPorts возвращает список портов с VID/PID, серийным номером и типом моста
+func (a *App) ListPorts() ([]PortInfo, error) {
+	return DetectPorts()
 }
 
 // startup is called when the app starts. The context is saved
 // so we can call the runtime methods
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
+	a.portWatcher = NewPortWatcher(a.emitPortsChanged)
+}
+
+// shutdown вызывается при закрытии приложения
+func (a *App) shutdown(ctx context.Context) {
+	if a.portWatcher != nil {
+		a.portWatcher.Stop()
+	}
+	a.haltMonitor()
+	a.ports.CloseAll()
+}
+
+// emitPortsChanged сообщает frontend о подключении и отключении портов
+func (a *App) emitPortsChanged(change PortsChange) {
+	for _, port := range change.Added {
+		a.emitLog(fmt.Sprintf("🔌 Подключен порт %s", port.Label))
+	}
+	for _, port := range change.Removed {
+		a.emitLog(fmt.Sprintf("⏏️ Отключен порт %s", port.Label))
+	}
+	runtime.EventsEmit(a.ctx, "ports-changed", change)
+}
+
+// SetAutoSelectPort включает автоматический выбор только что подключенной платы ESP
+func (a *App) SetAutoSelectPort(enabled bool) {
+	if a.portWatcher != nil {
+		a.portWatcher.SetAutoSelect(enabled)
+	}
 }
 
 // ChooseFile открывает диалог выбора файла
@@ -41,8 +108,8 @@ func (a *App) ChooseFile() (string, error) {
 		Title: "Выберите файл прошивки",
 		Filters: []runtime.FileFilter{
 			{
-				DisplayName: "Firmware Files",
-				Pattern:     "*.bin",
+				DisplayName: "Firmware Files (*.bin, *.hex, *.uf2)",
+				Pattern:     "*.bin;*.hex;*.uf2",
 			},
 		},
 	})
@@ -63,180 +130,306 @@ func (a *App) emitLog(message string) {
 	runtime.EventsEmit(a.ctx, "flash-log", message)
 }
 
-// Flash прошивает только application.bin на адрес 0x10000 используя встроенную реализацию esptool
-func (a *App) Flash(portName, filePath string) error {
+// Flash прошивает файл прошивки. Файлы .bin записываются на адрес 0x10000,
+// а Intel HEX и UF2 - по адресам, указанным в самом файле
+func (a *App) Flash(portName, filePath string, options FlashOptions) error {
+	config := options.flasherConfig(portName)
+
+	if options.Trace {
+		dir, err := traceDir()
+		if err != nil {
+			return err
+		}
+
+		// Записываются действия, которые выполнит флешер, а не выбранные в интерфейсе:
+		// воспроизведение применяет их как есть, без порта и без монитора
+		trace, err := NewTraceRecorder(dir, map[string]string{
+			"port":     portName,
+			"firmware": filePath,
+			"diff":     strconv.FormatBool(options.Diff),
+			"reset":    effectiveResetStrategy(config.Reset, portName),
+			"before":   config.Before,
+			"after":    config.After,
+		})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			trace.Close()
+			a.emitLog(fmt.Sprintf("🧾 Трасса протокола сохранена: %s", trace.Path()))
+		}()
+
+		config.Trace = trace
+	}
+
+	return a.runFlash(filePath, options, config)
+}
+
+// ReplayTrace воспроизводит записанную трассу, чтобы повторить сбой без устройства.
+// Если firmwarePath пуст, используется файл прошивки из заголовка трассы
+func (a *App) ReplayTrace(tracePath, firmwarePath string) error {
+	replay, err := OpenTraceReplay(tracePath)
+	if err != nil {
+		return err
+	}
+
+	meta := replay.Meta()
+	if firmwarePath == "" {
+		firmwarePath = meta["firmware"]
+	}
+
+	a.emitLog(fmt.Sprintf("▶️ Воспроизведение трассы %s (порт %s, записана %s)", tracePath, meta["port"], meta["started"]))
+
+	options := FlashOptions{Diff: meta["diff"] == "true"}
+	config := FlasherConfig{Transport: replay, Reset: meta["reset"], Before: meta["before"], After: meta["after"]}
+	// После записанной потери порта флешер переподключается к той же трассе
+	config.Reopen = func(string) (Transport, error) { return replay, nil }
+	err = a.runFlash(firmwarePath, options, config)
+
+	if mismatches := replay.Mismatches(); len(mismatches) > 0 {
+		a.emitLog(fmt.Sprintf("⚠️ Расхождений с трассой: %d", len(mismatches)))
+		for i, mismatch := range mismatches {
+			if i == 10 {
+				a.emitLog(fmt.Sprintf("   ... и еще %d", len(mismatches)-i))
+				break
+			}
+			a.emitLog("   • " + mismatch)
+		}
+	} else {
+		a.emitLog("✅ Действия флешера полностью совпали с трассой")
+	}
+
+	return err
+}
+
+// ChooseTraceFile открывает диалог выбора файла трассы
+func (a *App) ChooseTraceFile() (string, error) {
+	dir, _ := traceDir()
+
+	return runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title:            "Выберите файл трассы",
+		DefaultDirectory: dir,
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "Protocol Traces (*.jsonl)",
+				Pattern:     "*.jsonl",
+			},
+		},
+	})
+}
+
+// traceDir возвращает каталог для файлов трассы
+func traceDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "espflasher", "traces"), nil
+}
+
+// runFlash выполняет прошивку через флешер, созданный по config
+func (a *App) runFlash(filePath string, options FlashOptions, config FlasherConfig) error {
 	// Проверить что файл существует
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return fmt.Errorf("file does not exist: %s", filePath)
 	}
 
+	ctx, done, err := a.startOperation()
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	a.emitProgress(0, "Начинаем прошивку...")
 	a.emitLog("🔄 Инициализация...")
 
-	// Считать файл
-	data, err := os.ReadFile(filePath)
+	// Считать файл и разобрать его на сегменты
+	segments, family, err := LoadFirmware(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return fmt.Errorf("failed to load firmware: %w", err)
+	}
+
+	total := 0
+	for _, segment := range segments {
+		total += len(segment.Data)
 	}
 
 	a.emitProgress(10, "Файл загружен")
-	a.emitLog(fmt.Sprintf("📄 Загружен файл: %d байт", len(data)))
+	a.emitLog(fmt.Sprintf("📄 Загружен файл: %d байт, сегментов: %d", total, len(segments)))
+	for _, segment := range segments {
+		a.emitLog(fmt.Sprintf("   • 0x%08x: %d байт", segment.Offset, len(segment.Data)))
+	}
+	if family != "" {
+		a.emitLog(fmt.Sprintf("🏷️ Прошивка UF2 собрана для %s", family))
+	}
 
 	// Создать ESP32 флешер
 	a.emitProgress(20, "Подключение к ESP32...")
 	a.emitLog("🔗 Подключение к ESP32...")
 
-	flasher, err := NewESP32FlasherWithProgress(portName, a)
+	// Порт берется в аренду: идущий на нем мониторинг приостанавливается до конца прошивки
+	if config.Transport == nil {
+		lease, err := a.ports.Acquire(config.PortName, OWNER_FLASHER)
+		if err != nil {
+			return fmt.Errorf("failed to open port: %w", err)
+		}
+		config.Lease = lease
+	}
+
+	flasher, err := NewESP32FlasherWithConfig(ctx, config, a)
 	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			a.emitCancelled()
+			return fmt.Errorf("flash cancelled")
+		}
+		a.explainError(err)
 		return fmt.Errorf("failed to create flasher: %w", err)
 	}
 	defer flasher.Close()
 
+	if options.Diff {
+		flasher.EnableDiffMode(true)
+	}
+	flasher.ExpectChip(family)
+
 	// Прошить данные с прогрессом (начинается с 30%)
-	if err := flasher.FlashData(data, 0x10000, portName); err != nil {
+	if err := flasher.FlashSegments(ctx, segments); err != nil {
+		if errors.Is(err, context.Canceled) {
+			// Интерфейс узнает об отмене сразу, сброс чипа идет следом
+			a.emitCancelled()
+			flasher.Abort()
+			return fmt.Errorf("flash cancelled")
+		}
 		a.emitProgress(0, "Ошибка прошивки")
+		a.explainError(err)
 		return fmt.Errorf("failed to flash: %w", err)
 	}
 
 	a.emitProgress(100, "Прошивка завершена!")
 	a.emitLog("✅ Прошивка успешно завершена!")
 
+	if options.Monitor {
+		return a.monitorAfterFlash(flasher, config.Lease, options)
+	}
+
 	return nil
 }
 
-// MonitorPort создает соединение с портом для мониторинга и возвращает канал с данными
-func (a *App) MonitorPort(portName string, baudRate int) error {
-	// Если уже идет мониторинг, останавливаем его
-	if a.monitorPort != nil {
-		a.StopMonitor()
+// monitorAfterFlash переводит порт флешера в режим монитора: переключает скорость,
+// запускает чтение и только затем сбрасывает чип, поэтому лог загрузки виден целиком
+func (a *App) monitorAfterFlash(flasher *ESP32Flasher, lease *PortLease, options FlashOptions) error {
+	if lease == nil {
+		return fmt.Errorf("monitor requires a serial port")
 	}
 
-	// Открываем порт для мониторинга
-	mode := &serialport.Mode{
-		BaudRate: baudRate,
-		Parity:   serialport.NoParity,
-		DataBits: 8,
-		StopBits: serialport.OneStopBit,
+	baudRate := options.MonitorBaud
+	if baudRate == 0 {
+		baudRate = ROM_BAUD_RATE
 	}
 
-	port, err := serialport.Open(portName, mode)
-	if err != nil {
-		return fmt.Errorf("failed to open port for monitoring: %w", err)
+	if err := lease.Port().SetBaudRate(baudRate); err != nil && isLocalPort(lease.Name()) {
+		return fmt.Errorf("failed to switch port to %d baud: %w", baudRate, err)
 	}
 
-	a.monitorPort = port
-	a.stopMonitor = make(chan bool, 1)
-	a.lineBuffer = "" // Очищаем буфер строк
+	// Канал остается открытым и переходит монитору, закрывать его флешеру уже не нужно
+	flasher.Detach()
+	a.startMonitor(lease.Handover(OWNER_MONITOR), baudRate, nil)
 
-	a.emitLog(fmt.Sprintf("🔍 Начинаем мониторинг порта %s (%d baud)", portName, baudRate))
-	a.emitLog("💡 Для остановки мониторинга нажмите 'Стоп'")
+	switch options.After {
+	case AFTER_HARD_RESET:
+		return flasher.HardReset()
+	case AFTER_RUN, AFTER_SOFT_RESET:
+		// Приложение запущено командой загрузчика еще до запуска монитора
+		a.emitLog("ℹ️ Первые строки загрузки могли быть пропущены: для полного лога выберите аппаратный сброс")
+	}
+	// Без сброса чип остается в загрузчике, как и просили
+	return nil
+}
 
-	// Запускаем горутину для чтения данных
-	go func() {
-		defer func() {
-			// Защищенное закрытие порта в горутине
-			if a.monitorPort != nil {
-				a.monitorPort.Close()
-				a.monitorPort = nil
-			}
-		}()
+// startOperation регистрирует операцию с портом, которую можно отменить через CancelFlash.
+// Одновременно выполняется только одна операция; done нужно вызвать по ее окончании
+func (a *App) startOperation() (context.Context, func(), error) {
+	ctx, cancel := context.WithCancel(a.ctx)
 
-		buffer := make([]byte, 1024)
-
-		for {
-			select {
-			case <-a.stopMonitor:
-				return
-			default:
-				// Проверяем, что порт еще открыт
-				if a.monitorPort == nil {
-					return
-				}
-
-				// Устанавливаем таймаут чтения
-				if err := a.monitorPort.SetReadTimeout(50 * time.Millisecond); err != nil {
-					return
-				}
-
-				n, err := a.monitorPort.Read(buffer)
-				if err != nil {
-					// Проверяем, если это timeout - продолжаем
-					if strings.Contains(err.Error(), "timeout") {
-						continue
-					}
-					// Проверяем на "bad file descriptor" - просто прекращаем без ошибки
-					if strings.Contains(err.Error(), "bad file descriptor") ||
-						strings.Contains(err.Error(), "file already closed") {
-						return
-					}
-					// Если другая ошибка - отправляем в лог и прекращаем
-					runtime.EventsEmit(a.ctx, "monitor-error", err.Error())
-					return
-				}
-
-				if n > 0 {
-					// Добавляем новые данные к буферу
-					a.lineBuffer += string(buffer[:n])
-
-					// Обрабатываем все полные строки
-					for {
-						newlineIdx := strings.Index(a.lineBuffer, "\n")
-						if newlineIdx == -1 {
-							// Нет полных строк, ждем еще данных
-							break
-						}
-
-						// Извлекаем полную строку
-						line := a.lineBuffer[:newlineIdx]
-						a.lineBuffer = a.lineBuffer[newlineIdx+1:]
-
-						// Убираем лишние символы \r и отправляем строку только если она не пустая
-						line = strings.TrimSpace(line)
-						if line != "" {
-							runtime.EventsEmit(a.ctx, "monitor-data", line)
-						}
-					}
-
-					// Если буфер становится слишком большим без \n, отправляем как есть и очищаем
-					if len(a.lineBuffer) > 1000 { // Возвращаем нормальный порог
-						line := strings.TrimSpace(a.lineBuffer)
-						if line != "" {
-							runtime.EventsEmit(a.ctx, "monitor-data", line)
-						}
-						a.lineBuffer = ""
-					}
-				}
-			}
-		}
-	}()
+	a.flashMu.Lock()
+	defer a.flashMu.Unlock()
 
-	return nil
+	if a.flashCancel != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("flash already in progress")
+	}
+	a.flashCancel = cancel
+
+	return ctx, func() {
+		cancel()
+		a.flashMu.Lock()
+		a.flashCancel = nil
+		a.flashMu.Unlock()
+	}, nil
 }
 
-// StopMonitor останавливает мониторинг порта
-func (a *App) StopMonitor() {
-	// Сначала посылаем сигнал остановки
-	if a.stopMonitor != nil {
-		select {
-		case a.stopMonitor <- true:
-		default:
-			// Канал уже закрыт или заполнен
-		}
-		close(a.stopMonitor)
-		a.stopMonitor = nil
+// ChipInfo подключается к загрузчику и опознает чип и микросхему flash.
+// reset - стратегия сброса, как в FlashOptions
+func (a *App) ChipInfo(portName, reset string) (ChipInfo, error) {
+	ctx, done, err := a.startOperation()
+	if err != nil {
+		return ChipInfo{}, err
+	}
+	defer done()
+
+	a.emitLog("🔎 Опознание чипа...")
+
+	lease, err := a.ports.Acquire(portName, OWNER_FLASHER)
+	if err != nil {
+		return ChipInfo{}, fmt.Errorf("failed to open port: %w", err)
+	}
+
+	flasher, err := NewESP32FlasherWithConfig(ctx, FlasherConfig{Lease: lease, Reset: reset}, a)
+	if err != nil {
+		a.explainError(err)
+		return ChipInfo{}, fmt.Errorf("failed to create flasher: %w", err)
 	}
+	defer flasher.Close()
 
-	// Даем время горутине на завершение
-	time.Sleep(200 * time.Millisecond)
+	info, err := flasher.ReadChipInfo(ctx)
+	if err != nil {
+		a.explainError(err)
+		return info, err
+	}
 
-	// Только после этого закрываем порт
-	if a.monitorPort != nil {
-		a.monitorPort.Close()
-		a.monitorPort = nil
+	a.emitLog(fmt.Sprintf("🧩 Чип: %s", info.Chip))
+	if info.Flash != nil {
+		a.emitLog(fmt.Sprintf("💾 Flash: %s", info.Flash))
 	}
 
-	a.lineBuffer = "" // Очищаем буфер строк
+	// Возвращаем плату к работе приложения
+	flasher.HardReset()
+
+	return info, nil
+}
+
+// CancelFlash прерывает текущую прошивку
+func (a *App) CancelFlash() {
+	a.flashMu.Lock()
+	defer a.flashMu.Unlock()
+
+	if a.flashCancel != nil {
+		a.emitLog("⏹️ Отмена прошивки...")
+		a.flashCancel()
+	}
+}
+
+// explainError выводит в лог объяснение ошибки загрузчика и совет, как ее исправить
+func (a *App) explainError(err error) {
+	var romErr *ROMError
+	if errors.As(err, &romErr) {
+		a.emitLog("❌ " + romErr.Explanation())
+		a.emitLog("💡 " + romErr.Hint())
+	}
+}
 
-	runtime.EventsEmit(a.ctx, "monitor-stop", "")
-	a.emitLog("⏹️ Мониторинг порта остановлен")
+// emitCancelled сообщает frontend об отмене прошивки
+func (a *App) emitCancelled() {
+	a.emitProgress(0, "Прошивка отменена")
+	runtime.EventsEmit(a.ctx, "flash-cancelled", "")
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drainOutput читает вывод чипа, пока он не замолчит
+func drainOutput(t *testing.T, transport Transport) string {
+	t.Helper()
+
+	transport.SetReadTimeout(200 * time.Millisecond)
+	var out bytes.Buffer
+	buffer := make([]byte, 1024)
+	for {
+		n, err := transport.Read(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return out.String()
+		}
+		out.Write(buffer[:n])
+	}
+}
+
+func TestFlashWithMonitorBootsOnce(t *testing.T) {
+	emu, transport := NewROMEmulator(EMULATOR_FLASH_SIZE, EmulatorFaults{})
+	defer emu.Close()
+
+	// Аппаратный сброс с монитором откладывается до запуска монитора
+	config := FlashOptions{Monitor: true, After: AFTER_HARD_RESET}.flasherConfig("")
+	if config.After != AFTER_NO_RESET {
+		t.Fatalf("flasher after action = %q, want %q", config.After, AFTER_NO_RESET)
+	}
+	config.Transport = transport
+
+	ctx := context.Background()
+	flasher, err := NewESP32FlasherWithConfig(ctx, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flasher.FlashSegments(ctx, []FirmwareSegment{{Offset: DEFAULT_APP_OFFSET, Data: testImage(4096)}}); err != nil {
+		t.Fatalf("FlashSegments: %v", err)
+	}
+	port := flasher.Detach()
+
+	// До сброса чип остается в загрузчике и ничего не выводит
+	if before := drainOutput(t, port); strings.Contains(before, "rst:") || strings.Contains(before, "app_main") {
+		t.Fatalf("chip booted before the monitor was attached: %q", before)
+	}
+	if !emu.DownloadMode() {
+		t.Fatal("chip left download mode before the reset")
+	}
+
+	if err := flasher.HardReset(); err != nil {
+		t.Fatal(err)
+	}
+	log := drainOutput(t, port)
+	if n := strings.Count(log, "rst:"); n != 1 {
+		t.Errorf("monitor captured %d boots, want 1: %q", n, log)
+	}
+	if !strings.Contains(log, "app_main: emulated application started") {
+		t.Errorf("application output missing: %q", log)
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Формат core dump ESP-IDF: заголовок, ELF core файл и контрольная сумма
+const (
+	COREDUMP_HEADER_SIZE = 20 // tot_len, version, tasks_num, tcb_sz, segs_num
+
+	// Младшие 16 бит версии - формат, старшие - идентификатор чипа
+	COREDUMP_VERSION_ELF = 0x100 // все версии ELF формата начинаются с 0x100
+
+	// Строки, которыми ESP-IDF обрамляет core dump в base64 при выводе в UART
+	COREDUMP_UART_START = "CORE DUMP START"
+	COREDUMP_UART_END   = "CORE DUMP END"
+
+	// Заметки ELF core файла
+	COREDUMP_NOTE_PRSTATUS   = 1   // регистры задачи, pr_pid - адрес TCB
+	COREDUMP_NOTE_EXTRA_INFO = 677 // TCB упавшей задачи и регистры исключения
+	COREDUMP_PRSTATUS_SIZE   = 72  // prstatus до массива регистров
+
+	// Регистры в заметке EXTRA_INFO
+	XTENSA_EXTRA_EXCCAUSE = 232
+	XTENSA_EXTRA_EXCVADDR = 238
+	RISCV_EXTRA_MCAUSE    = 0x342
+	RISCV_EXTRA_MTVAL     = 0x343
+
+	// a0..a63 в регистрах Xtensa идут после pc, ps, lbeg, lend, lcount, sar,
+	// windowstart, windowbase и резерва
+	XTENSA_AR_REGS_INDEX = 64
+	COREDUMP_MAX_FRAMES  = 32
+
+	// pcTaskName в TCB FreeRTOS после pxTopOfStack, двух ListItem_t, uxPriority и pxStack
+	FREERTOS_TCB_NAME_OFF  = 52
+	FREERTOS_TASK_NAME_LEN = 16
+)
+
+var (
+	ErrNoCoreDump          = errors.New("no core dump stored")
+	ErrCoreDumpFormat      = errors.New("binary core dump format is not supported, enable CONFIG_ESP_COREDUMP_DATA_FORMAT_ELF")
+	ErrNoCoreDumpPartition = errors.New("no coredump partition in partition table")
+)
+
+// Причины исключений Xtensa (EXCCAUSE)
+var xtensaExceptionCauses = map[uint32]string{
+	0:  "IllegalInstruction",
+	2:  "InstructionFetchError",
+	3:  "LoadStoreError",
+	6:  "IntegerDivideByZero",
+	9:  "LoadStoreAlignment",
+	20: "InstFetchProhibited",
+	28: "LoadProhibited",
+	29: "StoreProhibited",
+}
+
+// Причины исключений RISC-V (mcause)
+var riscvExceptionCauses = map[uint32]string{
+	0: "Instruction address misaligned",
+	1: "Instruction access fault",
+	2: "Illegal instruction",
+	3: "Breakpoint",
+	4: "Load address misaligned",
+	5: "Load access fault",
+	6: "Store address misaligned",
+	7: "Store access fault",
+}
+
+// CoreDumpTask - задача FreeRTOS из core dump
+type CoreDumpTask struct {
+	Handle  string       `json:"handle"` // адрес TCB
+	Name    string       `json:"name"`
+	Crashed bool         `json:"crashed"`
+	Frames  []SymbolInfo `json:"frames"` // стек вызовов, начиная с текущего PC
+}
+
+// CoreDump - разобранный core dump
+type CoreDump struct {
+	Arch         string         `json:"arch"` // "xtensa" или "riscv"
+	Version      uint32         `json:"version"`
+	ChecksumOK   bool           `json:"checksumOk"`
+	Exception    string         `json:"exception"`    // причина исключения, если известна
+	FaultAddress string         `json:"faultAddress"` // EXCVADDR или MTVAL
+	Tasks        []CoreDumpTask `json:"tasks"`        // упавшая задача первой
+}
+
+// coreSegment - область памяти из PT_LOAD
+type coreSegment struct {
+	addr uint32
+	data []byte
+}
+
+type coreMemory []coreSegment
+
+// read32 читает слово памяти из сохраненных сегментов
+func (m coreMemory) read32(addr uint32) (uint32, bool) {
+	data, ok := m.read(addr, 4)
+	if !ok {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(data), true
+}
+
+// read возвращает size байт памяти по адресу addr
+func (m coreMemory) read(addr, size uint32) ([]byte, bool) {
+	for _, segment := range m {
+		if addr >= segment.addr && uint64(addr)+uint64(size) <= uint64(segment.addr)+uint64(len(segment.data)) {
+			start := addr - segment.addr
+			return segment.data[start : start+size], true
+		}
+	}
+	return nil, false
+}
+
+// DecodeCoreDump разбирает core dump из раздела coredump или из UART. symbols -
+// ELF файл приложения для расшифровки стеков, nil - только адреса
+func DecodeCoreDump(data []byte, symbols *ELFSymbolizer) (CoreDump, error) {
+	if len(data) < COREDUMP_HEADER_SIZE {
+		return CoreDump{}, fmt.Errorf("core dump too short: %d bytes", len(data))
+	}
+
+	totalLen := binary.LittleEndian.Uint32(data[0:4])
+	if totalLen == 0 || totalLen == 0xffffffff {
+		return CoreDump{}, ErrNoCoreDump
+	}
+	if totalLen > uint32(len(data)) {
+		return CoreDump{}, fmt.Errorf("core dump truncated: %d of %d bytes", len(data), totalLen)
+	}
+	data = data[:totalLen]
+
+	dump := CoreDump{Version: binary.LittleEndian.Uint32(data[4:8])}
+	format := dump.Version & 0xffff
+	if format < COREDUMP_VERSION_ELF {
+		return dump, ErrCoreDumpFormat
+	}
+
+	// Нечетные версии ELF формата защищены SHA256, четные - CRC32
+	checksumLen := 4
+	if format&1 == 1 {
+		checksumLen = sha256.Size
+	}
+	if len(data) < COREDUMP_HEADER_SIZE+checksumLen {
+		return dump, fmt.Errorf("core dump too short: %d bytes", len(data))
+	}
+	body, checksum := data[:len(data)-checksumLen], data[len(data)-checksumLen:]
+	if checksumLen == 4 {
+		dump.ChecksumOK = crc32.ChecksumIEEE(body) == binary.LittleEndian.Uint32(checksum)
+	} else {
+		sum := sha256.Sum256(body)
+		dump.ChecksumOK = bytes.Equal(sum[:], checksum)
+	}
+
+	// Размер заголовка зависит от версии ESP-IDF, поэтому ищем начало ELF
+	start := bytes.Index(body[:min(len(body), 64)], []byte(elf.ELFMAG))
+	if start < 0 {
+		return dump, fmt.Errorf("core dump does not contain an ELF file")
+	}
+
+	core, err := elf.NewFile(bytes.NewReader(body[start:]))
+	if err != nil {
+		return dump, fmt.Errorf("invalid core dump ELF: %w", err)
+	}
+	if core.Type != elf.ET_CORE {
+		return dump, fmt.Errorf("core dump ELF has type %v", core.Type)
+	}
+
+	switch core.Machine {
+	case elf.EM_XTENSA:
+		dump.Arch = "xtensa"
+	case elf.EM_RISCV:
+		dump.Arch = "riscv"
+	default:
+		return dump, fmt.Errorf("unsupported core dump architecture %v", core.Machine)
+	}
+
+	var memory coreMemory
+	var notes []coreNote
+	for _, prog := range core.Progs {
+		content, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return dump, fmt.Errorf("failed to read core dump segment: %w", err)
+		}
+		switch prog.Type {
+		case elf.PT_LOAD:
+			memory = append(memory, coreSegment{addr: uint32(prog.Vaddr), data: content})
+		case elf.PT_NOTE:
+			notes = append(notes, parseCoreNotes(content)...)
+		}
+	}
+
+	var crashedTCB uint32
+	for _, note := range notes {
+		if note.kind == COREDUMP_NOTE_EXTRA_INFO && len(note.desc) >= 4 {
+			crashedTCB = binary.LittleEndian.Uint32(note.desc[0:4])
+			dump.decodeExtraInfo(note.desc[4:])
+		}
+	}
+
+	for _, note := range notes {
+		if note.kind != COREDUMP_NOTE_PRSTATUS || len(note.desc) < COREDUMP_PRSTATUS_SIZE+4 {
+			continue
+		}
+
+		handle := binary.LittleEndian.Uint32(note.desc[24:28]) // pr_pid
+		regs := make([]uint32, (len(note.desc)-COREDUMP_PRSTATUS_SIZE)/4)
+		for i := range regs {
+			regs[i] = binary.LittleEndian.Uint32(note.desc[COREDUMP_PRSTATUS_SIZE+4*i:])
+		}
+
+		task := CoreDumpTask{
+			Handle:  fmt.Sprintf("0x%08x", handle),
+			Name:    taskName(memory, handle),
+			Crashed: handle == crashedTCB,
+		}
+		for _, pc := range unwindTask(dump.Arch, regs, memory) {
+			task.Frames = append(task.Frames, describeAddress(symbols, pc))
+		}
+
+		if task.Crashed {
+			dump.Tasks = append([]CoreDumpTask{task}, dump.Tasks...)
+		} else {
+			dump.Tasks = append(dump.Tasks, task)
+		}
+	}
+
+	return dump, nil
+}
+
+// decodeExtraInfo разбирает пары (номер регистра, значение) из заметки EXTRA_INFO
+func (d *CoreDump) decodeExtraInfo(pairs []byte) {
+	causes, causeReg, addrReg := xtensaExceptionCauses, uint32(XTENSA_EXTRA_EXCCAUSE), uint32(XTENSA_EXTRA_EXCVADDR)
+	if d.Arch == "riscv" {
+		causes, causeReg, addrReg = riscvExceptionCauses, RISCV_EXTRA_MCAUSE, RISCV_EXTRA_MTVAL
+	}
+
+	for i := 0; i+8 <= len(pairs); i += 8 {
+		reg := binary.LittleEndian.Uint32(pairs[i:])
+		value := binary.LittleEndian.Uint32(pairs[i+4:])
+		switch reg {
+		case causeReg:
+			if name, ok := causes[value]; ok {
+				d.Exception = fmt.Sprintf("%s (%d)", name, value)
+			} else {
+				d.Exception = fmt.Sprintf("cause %d", value)
+			}
+		case addrReg:
+			d.FaultAddress = fmt.Sprintf("0x%08x", value)
+		}
+	}
+}
+
+// coreNote - заметка PT_NOTE
+type coreNote struct {
+	name string
+	kind uint32
+	desc []byte
+}
+
+// parseCoreNotes разбирает содержимое сегмента PT_NOTE. Имя и данные выровнены на 4 байта
+func parseCoreNotes(data []byte) []coreNote {
+	var notes []coreNote
+	align := func(n uint32) uint32 { return (n + 3) &^ 3 }
+
+	for pos := uint32(0); pos+12 <= uint32(len(data)); {
+		nameSize := binary.LittleEndian.Uint32(data[pos:])
+		descSize := binary.LittleEndian.Uint32(data[pos+4:])
+		kind := binary.LittleEndian.Uint32(data[pos+8:])
+		pos += 12
+
+		if uint64(pos)+uint64(align(nameSize))+uint64(descSize) > uint64(len(data)) {
+			break
+		}
+		name := strings.TrimRight(string(data[pos:pos+nameSize]), "\x00")
+		pos += align(nameSize)
+		desc := data[pos : pos+descSize]
+		pos += align(descSize)
+
+		notes = append(notes, coreNote{name: name, kind: kind, desc: desc})
+	}
+
+	return notes
+}
+
+// unwindTask восстанавливает стек вызовов задачи по регистрам и памяти стека.
+// Для Xtensa используется область сохранения под SP, как в esp_backtrace;
+// для RISC-V без указателя кадра доступны только PC и RA
+func unwindTask(arch string, regs []uint32, memory coreMemory) []uint32 {
+	if len(regs) == 0 {
+		return nil
+	}
+
+	if arch == "riscv" {
+		frames := []uint32{regs[0]}
+		if len(regs) > 1 && regs[1] != 0 {
+			frames = append(frames, regs[1])
+		}
+		return frames
+	}
+
+	if len(regs) < XTENSA_AR_REGS_INDEX+2 {
+		return []uint32{regs[0]}
+	}
+
+	pc, next, sp := regs[0], regs[XTENSA_AR_REGS_INDEX], regs[XTENSA_AR_REGS_INDEX+1]
+	frames := []uint32{pc}
+
+	for next != 0 && len(frames) < COREDUMP_MAX_FRAMES {
+		pc = next
+		frames = append(frames, xtensaStackPC(pc))
+
+		// Под SP лежат a0 и a1 вызывающей функции
+		var ok bool
+		if next, ok = memory.read32(sp - 16); !ok {
+			break
+		}
+		if sp, ok = memory.read32(sp - 12); !ok || sp == 0 {
+			break
+		}
+	}
+
+	return frames
+}
+
+// xtensaStackPC переводит адрес возврата с битами размера окна в адрес инструкции вызова
+func xtensaStackPC(pc uint32) uint32 {
+	if pc&0x80000000 != 0 {
+		pc = (pc & 0x3fffffff) | 0x40000000
+	}
+	return pc - 3
+}
+
+// taskName читает имя задачи из TCB FreeRTOS, если TCB попал в core dump
+func taskName(memory coreMemory, handle uint32) string {
+	data, ok := memory.read(handle+FREERTOS_TCB_NAME_OFF, FREERTOS_TASK_NAME_LEN)
+	if !ok {
+		return ""
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	for _, c := range data {
+		if c < 0x20 || c > 0x7e {
+			return ""
+		}
+	}
+	return string(data)
+}
+
+// describeAddress расшифровывает адрес по ELF приложения, если он подключен
+func describeAddress(symbols *ELFSymbolizer, addr uint32) SymbolInfo {
+	info := SymbolInfo{Address: fmt.Sprintf("0x%08x", addr)}
+	if symbols != nil {
+		info.Function, info.File, info.Line, _ = symbols.Lookup(uint64(addr))
+	}
+	return info
+}
+
+// coreDumpCapture собирает core dump в base64 из строк монитора
+type coreDumpCapture struct {
+	active bool
+	lines  []string
+}
+
+// feed принимает строку монитора. consumed - строка относится к core dump и не
+// показывается; data - полностью принятый core dump
+func (c *coreDumpCapture) feed(line string) (consumed bool, data []byte, err error) {
+	switch {
+	case strings.Contains(line, COREDUMP_UART_START):
+		c.active = true
+		c.lines = nil
+		return true, nil, nil
+
+	case !c.active:
+		return false, nil, nil
+
+	case strings.Contains(line, COREDUMP_UART_END):
+		c.active = false
+		data, err = base64.StdEncoding.DecodeString(strings.Join(c.lines, ""))
+		c.lines = nil
+		if err != nil {
+			return true, nil, fmt.Errorf("invalid core dump base64: %w", err)
+		}
+		return true, data, nil
+
+	default:
+		c.lines = append(c.lines, strings.TrimSpace(line))
+		return true, nil, nil
+	}
+}
+
+// ReadCoreDump читает core dump из раздела coredump платы и расшифровывает стеки
+// задач по ELF файлу, подключенному к монитору. reset - стратегия сброса
+func (a *App) ReadCoreDump(portName, reset string) (CoreDump, error) {
+	ctx, done, err := a.startOperation()
+	if err != nil {
+		return CoreDump{}, err
+	}
+	defer done()
+
+	a.emitLog("🧩 Чтение core dump...")
+
+	lease, err := a.ports.Acquire(portName, OWNER_FLASHER)
+	if err != nil {
+		return CoreDump{}, fmt.Errorf("failed to open port: %w", err)
+	}
+
+	flasher, err := NewESP32FlasherWithConfig(ctx, FlasherConfig{Lease: lease, Reset: reset}, a)
+	if err != nil {
+		a.explainError(err)
+		return CoreDump{}, fmt.Errorf("failed to create flasher: %w", err)
+	}
+	defer flasher.Close()
+
+	data, err := flasher.readCoreDumpPartition(ctx)
+
+	// Возвращаем плату к работе приложения
+	flasher.HardReset()
+
+	if err != nil {
+		a.explainError(err)
+		return CoreDump{}, err
+	}
+
+	dump, err := DecodeCoreDump(data, a.monitorSymbols())
+	if err != nil {
+		return dump, err
+	}
+	a.logCoreDump(dump)
+
+	return dump, nil
+}
+
+// readCoreDumpPartition находит раздел coredump и читает сохраненный core dump
+func (f *ESP32Flasher) readCoreDumpPartition(ctx context.Context) ([]byte, error) {
+	if err := f.sync(ctx); err != nil {
+		return nil, fmt.Errorf("sync failed: %w", err)
+	}
+	if err := f.spiAttach(ctx); err != nil {
+		return nil, fmt.Errorf("SPI attach failed: %w", err)
+	}
+
+	partitions, err := f.ReadPartitionTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	partition, ok := FindPartition(partitions, PARTITION_TYPE_DATA, PARTITION_SUBTYPE_COREDUMP)
+	if !ok {
+		return nil, ErrNoCoreDumpPartition
+	}
+
+	header, err := f.readFlash(ctx, partition.Offset, COREDUMP_HEADER_SIZE, nil)
+	if err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size == 0 || size == 0xffffffff {
+		return nil, ErrNoCoreDump
+	}
+	if size > partition.Size {
+		return nil, fmt.Errorf("core dump size %d exceeds partition %q size %d", size, partition.Label, partition.Size)
+	}
+
+	if f.callback != nil {
+		f.callback.emitLog(fmt.Sprintf("📥 Раздел %s (0x%x): core dump %d байт", partition.Label, partition.Offset, size))
+	}
+
+	lastPercent := -1
+	return f.readFlash(ctx, partition.Offset, size, func(done uint32) {
+		percent := int(done * 100 / size)
+		if f.callback != nil && percent/10 != lastPercent/10 {
+			f.callback.emitProgress(percent, fmt.Sprintf("Чтение core dump: %d%%", percent))
+		}
+		lastPercent = percent
+	})
+}
+
+// logCoreDump выводит в лог причину сбоя и стеки задач
+func (a *App) logCoreDump(dump CoreDump) {
+	if !dump.ChecksumOK {
+		a.emitLog("⚠️ Контрольная сумма core dump не совпадает, данные могут быть повреждены")
+	}
+	if dump.Exception != "" {
+		message := "💥 Исключение: " + dump.Exception
+		if dump.FaultAddress != "" {
+			message += ", адрес " + dump.FaultAddress
+		}
+		a.emitLog(message)
+	}
+	if a.monitorSymbols() == nil {
+		a.emitLog("💡 Подключите ELF файл прошивки (🧭 ELF), чтобы расшифровать стеки")
+	}
+
+	for _, task := range dump.Tasks {
+		title := task.Name
+		if title == "" {
+			title = "?"
+		}
+		marker := "🧵"
+		if task.Crashed {
+			marker = "💥"
+		}
+		a.emitLog(fmt.Sprintf("%s Задача %s (TCB %s)", marker, title, task.Handle))
+		for i, frame := range task.Frames {
+			a.emitLog(fmt.Sprintf("   #%d %s", i, frame))
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+const (
+	testCrashedTCB = 0x3fc90000
+	testIdleTCB    = 0x3fc90100
+)
+
+// coreNoteBytes собирает заметку ELF с выравниванием имени и данных на 4 байта
+func coreNoteBytes(kind uint32, desc []byte) []byte {
+	name := []byte("CORE\x00\x00\x00\x00")
+	var note []byte
+	note = binary.LittleEndian.AppendUint32(note, 5)
+	note = binary.LittleEndian.AppendUint32(note, uint32(len(desc)))
+	note = binary.LittleEndian.AppendUint32(note, kind)
+	note = append(note, name...)
+	note = append(note, desc...)
+	for len(note)%4 != 0 {
+		note = append(note, 0)
+	}
+	return note
+}
+
+// prstatusNote - регистры задачи RISC-V: pc и ra
+func prstatusNote(tcb, pc, ra uint32) []byte {
+	desc := make([]byte, COREDUMP_PRSTATUS_SIZE)
+	binary.LittleEndian.PutUint32(desc[24:28], tcb)
+	desc = binary.LittleEndian.AppendUint32(desc, pc)
+	desc = binary.LittleEndian.AppendUint32(desc, ra)
+	return coreNoteBytes(COREDUMP_NOTE_PRSTATUS, desc)
+}
+
+// testCoreELF собирает ELF core файл RISC-V: упавшая задача, задача IDLE и TCB
+// упавшей задачи с ее именем в памяти
+func testCoreELF() []byte {
+	var extra []byte
+	for _, v := range []uint32{testCrashedTCB, RISCV_EXTRA_MCAUSE, 5, RISCV_EXTRA_MTVAL, 0x10} {
+		extra = binary.LittleEndian.AppendUint32(extra, v)
+	}
+	notes := concat(
+		prstatusNote(testIdleTCB, 0x42000100, 0),
+		prstatusNote(testCrashedTCB, 0x42000200, 0x42000300),
+		coreNoteBytes(COREDUMP_NOTE_EXTRA_INFO, extra),
+	)
+
+	tcb := make([]byte, FREERTOS_TCB_NAME_OFF+FREERTOS_TASK_NAME_LEN)
+	copy(tcb[FREERTOS_TCB_NAME_OFF:], "main")
+
+	const headerSize, phSize = 52, 32
+	notesOff := uint32(headerSize + 2*phSize)
+	loadOff := notesOff + uint32(len(notes))
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, elf.Header32{
+		Ident:     [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F', byte(elf.ELFCLASS32), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)},
+		Type:      uint16(elf.ET_CORE),
+		Machine:   uint16(elf.EM_RISCV),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     headerSize,
+		Ehsize:    headerSize,
+		Phentsize: phSize,
+		Phnum:     2,
+		Shentsize: 40,
+	})
+	binary.Write(&out, binary.LittleEndian, elf.Prog32{
+		Type: uint32(elf.PT_NOTE), Off: notesOff, Filesz: uint32(len(notes)),
+	})
+	binary.Write(&out, binary.LittleEndian, elf.Prog32{
+		Type: uint32(elf.PT_LOAD), Off: loadOff, Vaddr: testCrashedTCB, Paddr: testCrashedTCB,
+		Filesz: uint32(len(tcb)), Memsz: uint32(len(tcb)),
+	})
+	out.Write(notes)
+	out.Write(tcb)
+	return out.Bytes()
+}
+
+// testCoreDump оборачивает ELF заголовком ESP-IDF и контрольной суммой версии version
+func testCoreDump(version uint32, core []byte) []byte {
+	checksumLen := 4
+	if version&1 == 1 {
+		checksumLen = sha256.Size
+	}
+
+	header := make([]byte, COREDUMP_HEADER_SIZE)
+	binary.LittleEndian.PutUint32(header[0:4], uint32(COREDUMP_HEADER_SIZE+len(core)+checksumLen))
+	binary.LittleEndian.PutUint32(header[4:8], version)
+	body := concat(header, core)
+
+	if checksumLen == 4 {
+		return binary.LittleEndian.AppendUint32(body, crc32.ChecksumIEEE(body))
+	}
+	sum := sha256.Sum256(body)
+	return append(body, sum[:]...)
+}
+
+func TestDecodeCoreDump(t *testing.T) {
+	want := CoreDump{
+		Arch:         "riscv",
+		ChecksumOK:   true,
+		Exception:    "Load access fault (5)",
+		FaultAddress: "0x00000010",
+		Tasks: []CoreDumpTask{
+			{Handle: "0x3fc90000", Name: "main", Crashed: true, Frames: []SymbolInfo{{Address: "0x42000200"}, {Address: "0x42000300"}}},
+			{Handle: "0x3fc90100", Frames: []SymbolInfo{{Address: "0x42000100"}}},
+		},
+	}
+
+	for _, version := range []uint32{0x0005_0102, 0x0005_0103} {
+		dump, err := DecodeCoreDump(testCoreDump(version, testCoreELF()), nil)
+		if err != nil {
+			t.Fatalf("version 0x%x: %v", version, err)
+		}
+		want.Version = version
+		if !reflect.DeepEqual(dump, want) {
+			t.Errorf("version 0x%x:\n got %+v\nwant %+v", version, dump, want)
+		}
+	}
+}
+
+func TestDecodeCoreDumpChecksumMismatch(t *testing.T) {
+	data := testCoreDump(0x0102, testCoreELF())
+	data[len(data)-1] ^= 0xff
+
+	// Поврежденный дамп все равно разбирается, чтобы показать то, что удалось прочитать
+	dump, err := DecodeCoreDump(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dump.ChecksumOK {
+		t.Error("ChecksumOK = true for corrupted dump")
+	}
+	if len(dump.Tasks) != 2 {
+		t.Errorf("got %d tasks, want 2", len(dump.Tasks))
+	}
+}
+
+func TestDecodeCoreDumpErrors(t *testing.T) {
+	valid := testCoreDump(0x0102, testCoreELF())
+
+	notELF := testCoreDump(0x0102, bytes.Repeat([]byte{0x55}, 64))
+	executable := testCoreELF()
+	binary.LittleEndian.PutUint16(executable[16:18], uint16(elf.ET_EXEC))
+	arm := testCoreELF()
+	binary.LittleEndian.PutUint16(arm[18:20], uint16(elf.EM_ARM))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+		errText string
+	}{
+		{name: "erased partition", data: bytes.Repeat([]byte{0xff}, 64), wantErr: ErrNoCoreDump},
+		{name: "zero length", data: make([]byte, 64), wantErr: ErrNoCoreDump},
+		{name: "binary format", data: testCoreDump(0x0003, make([]byte, 64)), wantErr: ErrCoreDumpFormat},
+		{name: "too short", data: valid[:10], errText: "core dump too short: 10 bytes"},
+		{name: "truncated", data: valid[:100], errText: fmt.Sprintf("core dump truncated: 100 of %d bytes", len(valid))},
+		{name: "no ELF", data: notELF, errText: "core dump does not contain an ELF file"},
+		{name: "not a core file", data: testCoreDump(0x0102, executable), errText: "core dump ELF has type ET_EXEC"},
+		{name: "unsupported architecture", data: testCoreDump(0x0102, arm), errText: "unsupported core dump architecture EM_ARM"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeCoreDump(tt.data, nil)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.errText {
+				t.Fatalf("error = %v, want %q", err, tt.errText)
+			}
+		})
+	}
+}
+
+func TestCoreDumpCapture(t *testing.T) {
+	data := testCoreDump(0x0102, testCoreELF())
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	lines := []string{"I (100) app: running", "================= CORE DUMP START =================\r"}
+	for len(encoded) > 0 {
+		n := min(len(encoded), 64)
+		lines = append(lines, encoded[:n]+"\r")
+		encoded = encoded[n:]
+	}
+	lines = append(lines, "================= CORE DUMP END =================", "I (200) app: next")
+
+	var capture coreDumpCapture
+	var got []byte
+	var shown []string
+	for _, line := range lines {
+		consumed, dump, err := capture.feed(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !consumed {
+			shown = append(shown, line)
+		}
+		if dump != nil {
+			got = dump
+		}
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Error("captured core dump does not match")
+	}
+	if !reflect.DeepEqual(shown, []string{"I (100) app: running", "I (200) app: next"}) {
+		t.Errorf("shown lines = %q", shown)
+	}
+
+	capture.feed(COREDUMP_UART_START)
+	capture.feed("not base64!")
+	if _, _, err := capture.feed(COREDUMP_UART_END); err == nil {
+		t.Error("invalid base64 accepted")
+	}
+}
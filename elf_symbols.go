@@ -0,0 +1,206 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Строка обратной трассировки после паники: "Backtrace: 0x400d1234:0x3ffb5f10 0x40088a51:0x3ffb5f30"
+var backtracePattern = regexp.MustCompile(`(0x[0-9a-fA-F]{8}):0x[0-9a-fA-F]{8}`)
+
+// Регистры в дампе паники, адреса которых указывают на код. Xtensa: PC, EXCVADDR;
+// RISC-V: MEPC, RA, MTVAL
+var panicRegisterPattern = regexp.MustCompile(`\b(PC|EXCVADDR|MEPC|RA|MTVAL)\s*:\s*(0x[0-9a-fA-F]{8})`)
+
+// SymbolInfo - расшифровка адреса по ELF файлу прошивки
+type SymbolInfo struct {
+	Address  string `json:"address"`  // адрес как в строке монитора
+	Register string `json:"register"` // регистр из дампа или "" для адреса обратной трассировки
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+func (s SymbolInfo) String() string {
+	location := "??:?"
+	if s.File != "" {
+		location = fmt.Sprintf("%s:%d", s.File, s.Line)
+	}
+	function := s.Function
+	if function == "" {
+		function = "??"
+	}
+	return fmt.Sprintf("%s: %s at %s", s.Address, function, location)
+}
+
+// elfFunction - функция из таблицы символов
+type elfFunction struct {
+	addr uint64
+	size uint64
+	name string
+}
+
+// elfLine - строка таблицы строк DWARF. end - конец последовательности адресов
+type elfLine struct {
+	addr uint64
+	file string
+	line int
+	end  bool
+}
+
+// ELFSymbolizer сопоставляет адреса кода функциям, файлам и строкам исходников
+// по таблице символов и таблицам строк DWARF, как addr2line в idf.py monitor
+type ELFSymbolizer struct {
+	Path      string
+	functions []elfFunction // по возрастанию адреса
+	lines     []elfLine     // по возрастанию адреса
+}
+
+// LoadELFSymbolizer читает ELF файл прошивки. Без отладочной информации DWARF
+// доступны только имена функций
+func LoadELFSymbolizer(path string) (*ELFSymbolizer, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ELF file: %w", err)
+	}
+	defer file.Close()
+
+	s := &ELFSymbolizer{Path: path}
+
+	symbols, err := file.Symbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, fmt.Errorf("failed to read ELF symbols: %w", err)
+	}
+	for _, symbol := range symbols {
+		if elf.ST_TYPE(symbol.Info) == elf.STT_FUNC && symbol.Value != 0 {
+			s.functions = append(s.functions, elfFunction{symbol.Value, symbol.Size, symbol.Name})
+		}
+	}
+	sort.Slice(s.functions, func(i, j int) bool { return s.functions[i].addr < s.functions[j].addr })
+
+	if data, err := file.DWARF(); err == nil {
+		if err := s.loadLines(data); err != nil {
+			return nil, fmt.Errorf("failed to read DWARF line tables: %w", err)
+		}
+	}
+
+	if len(s.functions) == 0 && len(s.lines) == 0 {
+		return nil, fmt.Errorf("ELF file %s has no symbols", path)
+	}
+
+	return s, nil
+}
+
+// loadLines собирает таблицы строк всех единиц компиляции
+func (s *ELFSymbolizer) loadLines(data *dwarf.Data) error {
+	reader := data.Reader()
+	for {
+		unit, err := reader.Next()
+		if err != nil {
+			return err
+		}
+		if unit == nil {
+			break
+		}
+		if unit.Tag != dwarf.TagCompileUnit {
+			reader.SkipChildren()
+			continue
+		}
+
+		lineReader, err := data.LineReader(unit)
+		if err != nil {
+			return err
+		}
+		if lineReader != nil {
+			var entry dwarf.LineEntry
+			for {
+				if err := lineReader.Next(&entry); err != nil {
+					if err == io.EOF {
+						break
+					}
+					return err
+				}
+				line := elfLine{addr: entry.Address, line: entry.Line, end: entry.EndSequence}
+				if entry.File != nil {
+					line.file = entry.File.Name
+				}
+				s.lines = append(s.lines, line)
+			}
+		}
+		reader.SkipChildren()
+	}
+
+	// Конец последовательности идет раньше строки с тем же адресом, чтобы
+	// следующая последовательность начиналась с действительной строки
+	sort.SliceStable(s.lines, func(i, j int) bool {
+		if s.lines[i].addr != s.lines[j].addr {
+			return s.lines[i].addr < s.lines[j].addr
+		}
+		return s.lines[i].end && !s.lines[j].end
+	})
+	return nil
+}
+
+// Lookup расшифровывает адрес. ok=false, если адрес не принадлежит коду прошивки
+func (s *ELFSymbolizer) Lookup(addr uint64) (function, file string, line int, ok bool) {
+	i := sort.Search(len(s.functions), func(i int) bool { return s.functions[i].addr > addr }) - 1
+	if i >= 0 {
+		fn := s.functions[i]
+		if addr < fn.addr+fn.size {
+			function = fn.name
+			ok = true
+		}
+	}
+
+	j := sort.Search(len(s.lines), func(j int) bool { return s.lines[j].addr > addr }) - 1
+	if j >= 0 && !s.lines[j].end {
+		file = s.lines[j].file
+		line = s.lines[j].line
+		ok = true
+	}
+
+	return function, file, line, ok
+}
+
+// Symbolize находит в строке монитора адреса обратной трассировки и регистров
+// дампа паники и расшифровывает те, что относятся к коду прошивки
+func (s *ELFSymbolizer) Symbolize(text string) []SymbolInfo {
+	var symbols []SymbolInfo
+
+	add := func(address, register string) {
+		addr, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(address), "0x"), 16, 32)
+		if err != nil || addr == 0 {
+			return
+		}
+		function, file, line, ok := s.Lookup(addr)
+		if !ok {
+			return
+		}
+		symbols = append(symbols, SymbolInfo{
+			Address:  address,
+			Register: register,
+			Function: function,
+			File:     file,
+			Line:     line,
+		})
+	}
+
+	if strings.Contains(text, "Backtrace:") {
+		for _, match := range backtracePattern.FindAllStringSubmatch(text, -1) {
+			add(match[1], "")
+		}
+		return symbols
+	}
+
+	for _, match := range panicRegisterPattern.FindAllStringSubmatch(text, -1) {
+		add(match[2], match[1])
+	}
+	return symbols
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testSymbolizer - таблица символов без ELF файла: две соседние функции,
+// промежуток без кода и обработчик прерывания
+func testSymbolizer() *ELFSymbolizer {
+	return &ELFSymbolizer{
+		functions: []elfFunction{
+			{0x400d1000, 0x40, "app_main"},
+			{0x400d1040, 0x20, "helper"},
+			{0x400d1100, 0x10, "isr_handler"},
+		},
+		lines: []elfLine{
+			{addr: 0x400d1000, file: "main.c", line: 10},
+			{addr: 0x400d1010, file: "main.c", line: 12},
+			{addr: 0x400d1040, file: "util.c", line: 5},
+			{addr: 0x400d1060, end: true},
+			{addr: 0x400d1100, file: "isr.c", line: 3},
+			{addr: 0x400d1110, end: true},
+		},
+	}
+}
+
+func TestELFSymbolizerLookup(t *testing.T) {
+	tests := []struct {
+		name         string
+		addr         uint64
+		wantFunction string
+		wantFile     string
+		wantLine     int
+		wantOK       bool
+	}{
+		{"hit", 0x400d1014, "app_main", "main.c", 12, true},
+		{"last byte of function", 0x400d103f, "app_main", "main.c", 12, true},
+		{"next function start", 0x400d1040, "helper", "util.c", 5, true},
+		{"end of code", 0x400d1060, "", "", 0, false},
+		{"before first function", 0x400d0fff, "", "", 0, false},
+		{"stack address", 0x3ffb5f10, "", "", 0, false},
+	}
+
+	s := testSymbolizer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			function, file, line, ok := s.Lookup(tt.addr)
+			if function != tt.wantFunction || file != tt.wantFile || line != tt.wantLine || ok != tt.wantOK {
+				t.Errorf("Lookup(0x%x) = %q, %q, %d, %v; want %q, %q, %d, %v", tt.addr,
+					function, file, line, ok, tt.wantFunction, tt.wantFile, tt.wantLine, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestELFSymbolizerSymbolize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []SymbolInfo
+	}{
+		{
+			name: "backtrace skips addresses outside code",
+			text: "Backtrace: 0x400d1014:0x3ffb5f10 0x400d1060:0x3ffb5f30 0x400D1044:0x3ffb5f50",
+			want: []SymbolInfo{
+				{Address: "0x400d1014", Function: "app_main", File: "main.c", Line: 12},
+				{Address: "0x400D1044", Function: "helper", File: "util.c", Line: 5},
+			},
+		},
+		{
+			name: "panic registers",
+			text: "PC      : 0x400d1104  PS      : 0x00060130  A0      : 0x800d1044  EXCVADDR: 0x3ffb0000",
+			want: []SymbolInfo{
+				{Address: "0x400d1104", Register: "PC", Function: "isr_handler", File: "isr.c", Line: 3},
+			},
+		},
+		{
+			name: "ordinary line",
+			text: "I (310) app: value 0x400d1014",
+		},
+	}
+
+	s := testSymbolizer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.Symbolize(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Symbolize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
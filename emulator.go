@@ -0,0 +1,413 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Параметры эмулируемого чипа
+const (
+	EMULATOR_FLASH_SIZE = 4 * 1024 * 1024 // 4 МБ, как у большинства модулей WROOM
+
+	// Имя порта, по которому OpenTransport подключается к демонстрационному эмулятору
+	EMULATOR_PORT_NAME = "emulator://esp32"
+)
+
+// Содержимое flash демонстрационного эмулятора сохраняется между подключениями,
+// чтобы можно было показать дифференциальную запись и продолжение после обрыва
+var (
+	demoFlashMu sync.Mutex
+	demoFlash   []byte
+)
+
+// EmulatorFaults - неисправности канала, которые эмулятор вносит в ответы
+type EmulatorFaults struct {
+	DropByteRate    float64       // вероятность потерять один байт ответа
+	BadChecksumRate float64       // вероятность ответить "Invalid CRC" на FLASH_DATA
+	ResponseDelay   time.Duration // задержка перед каждым ответом
+	Seed            int64         // seed генератора, чтобы сбои были воспроизводимыми
+}
+
+// ROMEmulator - программная модель ROM загрузчика ESP32. Отвечает на команды
+// по протоколу SLIP поверх PipeTransport и хранит содержимое flash в памяти
+type ROMEmulator struct {
+	device *PipeTransport
+
+	mu       sync.Mutex
+	flash    []byte
+	regs     map[uint32]uint32
+	download bool // чип в режиме загрузчика (GPIO0 был прижат при сбросе)
+	inReset  bool
+	gpio0Low bool // уровень DTR: GPIO0 прижат к земле
+	faults   EmulatorFaults
+	random   *rand.Rand
+
+	// Текущая сессия записи
+	writeOffset uint32
+	packetSize  uint32
+	packets     uint32
+	nextSeq     uint32
+	writing     bool
+
+	done chan struct{}
+}
+
+// NewROMEmulator запускает эмулятор с чистой flash и возвращает канал, который нужно передать флешеру
+func NewROMEmulator(flashSize int, faults EmulatorFaults) (*ROMEmulator, Transport) {
+	flash := make([]byte, flashSize)
+	for i := range flash {
+		flash[i] = 0xFF
+	}
+
+	return newROMEmulator(flash, faults)
+}
+
+// openDemoEmulator подключается к эмулятору с общей для всех подключений flash
+func openDemoEmulator() Transport {
+	demoFlashMu.Lock()
+	defer demoFlashMu.Unlock()
+
+	if demoFlash == nil {
+		demoFlash = make([]byte, EMULATOR_FLASH_SIZE)
+		for i := range demoFlash {
+			demoFlash[i] = 0xFF
+		}
+	}
+
+	_, host := newROMEmulator(demoFlash, EmulatorFaults{})
+	return host
+}
+
+func newROMEmulator(flash []byte, faults EmulatorFaults) (*ROMEmulator, Transport) {
+	host, device := NewPipeTransport()
+
+	e := &ROMEmulator{
+		device: device,
+		flash:  flash,
+		regs:   map[uint32]uint32{CHIP_DETECT_MAGIC_REG: ESP32_CHIP_MAGIC},
+		faults: faults,
+		random: rand.New(rand.NewSource(faults.Seed)),
+		done:   make(chan struct{}),
+	}
+
+	device.OnModemLines(e.onModemLines)
+	go e.run()
+
+	return e, host
+}
+
+// SetFaults меняет набор неисправностей во время работы
+func (e *ROMEmulator) SetFaults(faults EmulatorFaults) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.faults = faults
+	e.random = rand.New(rand.NewSource(faults.Seed))
+}
+
+// Flash возвращает копию участка эмулируемой flash
+func (e *ROMEmulator) Flash(offset, size int) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]byte(nil), e.flash[offset:offset+size]...)
+}
+
+// EnterDownloadMode переводит чип в режим загрузчика без сброса (как ручное нажатие BOOT+RESET)
+func (e *ROMEmulator) EnterDownloadMode() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.download = true
+}
+
+// DownloadMode сообщает, находится ли чип в режиме загрузчика
+func (e *ROMEmulator) DownloadMode() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.download
+}
+
+// Close останавливает эмулятор и закрывает канал
+func (e *ROMEmulator) Close() error {
+	err := e.device.Close()
+	<-e.done
+	return err
+}
+
+// onModemLines моделирует классическую схему автосброса:
+// RTS=1 держит EN в нуле, DTR=1 прижимает GPIO0 к земле
+func (e *ROMEmulator) onModemLines(dtr, rts bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.gpio0Low = dtr
+	if rts {
+		e.inReset = true
+		return
+	}
+	if !e.inReset {
+		return
+	}
+
+	// EN отпущен - чип стартует, режим определяется уровнем GPIO0
+	e.inReset = false
+	e.boot()
+}
+
+// boot моделирует запуск чипа: вывод ROM и режим по уровню GPIO0
+func (e *ROMEmulator) boot() {
+	e.writing = false
+	e.download = e.gpio0Low
+	if e.download {
+		e.device.Write([]byte("ets Jun  8 2016 00:22:57\r\n\r\nrst:0x1 (POWERON_RESET),boot:0x3 (DOWNLOAD_BOOT(UART0/UART1/SDIO_REI_REO_V2))\r\nwaiting for download\r\n"))
+	} else {
+		e.device.Write([]byte("ets Jun  8 2016 00:22:57\r\n\r\nrst:0x1 (POWERON_RESET),boot:0x13 (SPI_FAST_FLASH_BOOT)\r\n"))
+		e.runApp()
+	}
+}
+
+// runApp моделирует запуск приложения из flash
+func (e *ROMEmulator) runApp() {
+	e.download = false
+	e.device.Write([]byte("I (31) boot: ESP-IDF emulator 2nd stage bootloader\r\nI (250) cpu_start: Starting scheduler on PRO CPU.\r\nI (300) app_main: emulated application started\r\n"))
+}
+
+// run читает запросы из канала и отвечает на них
+func (e *ROMEmulator) run() {
+	defer close(e.done)
+
+	var stream slipStream
+	buffer := make([]byte, 4096)
+
+	for {
+		n, err := e.device.Read(buffer)
+		if err != nil {
+			return
+		}
+		if n == 0 {
+			continue
+		}
+
+		stream.feed(buffer[:n])
+		for frame := stream.pop(); frame != nil; frame = stream.pop() {
+			e.handle(frame)
+		}
+	}
+}
+
+// handle обрабатывает один запрос
+func (e *ROMEmulator) handle(request []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Вне режима загрузчика чип не отвечает на команды
+	if !e.download || len(request) < 8 || request[0] != 0x00 {
+		return
+	}
+
+	cmd := request[1]
+	size := int(binary.LittleEndian.Uint16(request[2:4]))
+	checksum := binary.LittleEndian.Uint32(request[4:8])
+	data := request[8:]
+	if len(data) != size {
+		e.respond(cmd, 0, nil, ROM_ERR_INVALID_MESSAGE)
+		return
+	}
+
+	switch cmd {
+	case ESP_SYNC:
+		e.respond(cmd, 0, nil, 0)
+
+	case ESP_READ_REG:
+		if len(data) != 4 {
+			e.respond(cmd, 0, nil, ROM_ERR_INVALID_MESSAGE)
+			return
+		}
+		e.respond(cmd, e.regs[binary.LittleEndian.Uint32(data)], nil, 0)
+
+	case ESP_WRITE_REG:
+		if len(data) != 16 {
+			e.respond(cmd, 0, nil, ROM_ERR_INVALID_MESSAGE)
+			return
+		}
+		addr, value := binary.LittleEndian.Uint32(data[0:4]), binary.LittleEndian.Uint32(data[4:8])
+		e.regs[addr] = value
+		if addr == SPI_CMD_REG && value&SPI_CMD_USR != 0 {
+			e.spiCommand()
+		}
+		e.respond(cmd, 0, nil, 0)
+
+	case ESP_SPI_ATTACH, ESP_SPI_PARAMS:
+		e.respond(cmd, 0, nil, 0)
+
+	case ESP_FLASH_BEGIN:
+		e.flashBegin(data)
+
+	case ESP_FLASH_DATA:
+		e.flashData(data, checksum)
+
+	case ESP_FLASH_END:
+		if len(data) != 4 || !e.writing {
+			e.respond(cmd, 0, nil, ROM_ERR_FAILED_TO_ACT)
+			return
+		}
+		e.writing = false
+		e.respond(cmd, 0, nil, 0)
+		// Как в ROM: 1 - запустить пользовательский код, 0 - перезагрузить чип
+		if binary.LittleEndian.Uint32(data) == 1 {
+			e.runApp()
+		} else {
+			e.boot()
+		}
+
+	case ESP_SPI_MD5:
+		if len(data) != 16 {
+			e.respond(cmd, 0, nil, ROM_ERR_INVALID_MESSAGE)
+			return
+		}
+		offset := binary.LittleEndian.Uint32(data[0:4])
+		length := binary.LittleEndian.Uint32(data[4:8])
+		if !e.inFlash(offset, length) {
+			e.respond(cmd, 0, nil, ROM_ERR_FLASH_READ)
+			return
+		}
+		sum := md5.Sum(e.flash[offset : offset+length])
+		e.respond(cmd, 0, []byte(hex.EncodeToString(sum[:])), 0)
+
+	case ESP_READ_FLASH:
+		if len(data) != 8 {
+			e.respond(cmd, 0, nil, ROM_ERR_INVALID_MESSAGE)
+			return
+		}
+		offset := binary.LittleEndian.Uint32(data[0:4])
+		length := binary.LittleEndian.Uint32(data[4:8])
+		if length > 64 || !e.inFlash(offset, length) {
+			e.respond(cmd, 0, nil, ROM_ERR_FLASH_READ)
+			return
+		}
+		e.respond(cmd, 0, e.flash[offset:offset+length], 0)
+
+	default:
+		e.respond(cmd, 0, nil, ROM_ERR_INVALID_MESSAGE)
+	}
+}
+
+// spiCommand выполняет пользовательскую транзакцию контроллера SPI1.
+// Поддерживается только RDID: эмулятор отвечает как Winbond W25Q нужного объема
+func (e *ROMEmulator) spiCommand() {
+	if byte(e.regs[SPI_USR2_REG]) == SPI_FLASH_RDID {
+		capacity := uint32(0)
+		for size := len(e.flash); size > 1; size >>= 1 {
+			capacity++
+		}
+		e.regs[SPI_W0_REG] = 0xef | 0x40<<8 | capacity<<16
+	}
+	e.regs[SPI_CMD_REG] &^= SPI_CMD_USR
+}
+
+// flashBegin стирает область и начинает сессию записи
+func (e *ROMEmulator) flashBegin(data []byte) {
+	if len(data) != 16 {
+		e.respond(ESP_FLASH_BEGIN, 0, nil, ROM_ERR_INVALID_MESSAGE)
+		return
+	}
+
+	eraseSize := binary.LittleEndian.Uint32(data[0:4])
+	packets := binary.LittleEndian.Uint32(data[4:8])
+	packetSize := binary.LittleEndian.Uint32(data[8:12])
+	offset := binary.LittleEndian.Uint32(data[12:16])
+
+	if offset%ESP_FLASH_SECTOR != 0 || !e.inFlash(offset, eraseSize) || !e.inFlash(offset, packets*packetSize) {
+		e.respond(ESP_FLASH_BEGIN, 0, nil, ROM_ERR_FAILED_TO_ACT)
+		return
+	}
+
+	for i := offset; i < offset+eraseSize; i++ {
+		e.flash[i] = 0xFF
+	}
+
+	e.writeOffset = offset
+	e.packets = packets
+	e.packetSize = packetSize
+	e.nextSeq = 0
+	e.writing = true
+	e.respond(ESP_FLASH_BEGIN, 0, nil, 0)
+}
+
+// flashData записывает пакет, моделируя NOR flash: запись только сбрасывает биты
+func (e *ROMEmulator) flashData(data []byte, checksum uint32) {
+	if !e.writing || len(data) < 16 {
+		e.respond(ESP_FLASH_DATA, 0, nil, ROM_ERR_FAILED_TO_ACT)
+		return
+	}
+
+	size := binary.LittleEndian.Uint32(data[0:4])
+	seq := binary.LittleEndian.Uint32(data[4:8])
+	payload := data[16:]
+
+	if uint32(len(payload)) != size || size != e.packetSize {
+		e.respond(ESP_FLASH_DATA, 0, nil, ROM_ERR_INVALID_MESSAGE)
+		return
+	}
+	if calculateChecksum(payload) != checksum || e.random.Float64() < e.faults.BadChecksumRate {
+		e.respond(ESP_FLASH_DATA, 0, nil, ROM_ERR_INVALID_CRC)
+		return
+	}
+
+	// Повтор уже записанного пакета подтверждаем без записи
+	if seq+1 == e.nextSeq {
+		e.respond(ESP_FLASH_DATA, 0, nil, 0)
+		return
+	}
+	if seq != e.nextSeq || seq >= e.packets {
+		e.respond(ESP_FLASH_DATA, 0, nil, ROM_ERR_FAILED_TO_ACT)
+		return
+	}
+
+	start := e.writeOffset + seq*e.packetSize
+	for i, b := range payload {
+		e.flash[start+uint32(i)] &= b
+	}
+	e.nextSeq++
+	e.respond(ESP_FLASH_DATA, 0, nil, 0)
+}
+
+// inFlash проверяет, что участок помещается во flash
+func (e *ROMEmulator) inFlash(offset, size uint32) bool {
+	return uint64(offset)+uint64(size) <= uint64(len(e.flash))
+}
+
+// respond отправляет ответ в формате ROM загрузчика ESP32: данные и 4 байта статуса
+func (e *ROMEmulator) respond(cmd byte, value uint32, data []byte, errorCode byte) {
+	body := append([]byte(nil), data...)
+	if errorCode != 0 {
+		body = append(body, 0x01, errorCode, 0x00, 0x00)
+	} else {
+		body = append(body, 0x00, 0x00, 0x00, 0x00)
+	}
+
+	packet := make([]byte, 8+len(body))
+	packet[0] = 0x01
+	packet[1] = cmd
+	binary.LittleEndian.PutUint16(packet[2:4], uint16(len(body)))
+	binary.LittleEndian.PutUint32(packet[4:8], value)
+	copy(packet[8:], body)
+
+	encoded := slipEncode(packet)
+
+	if e.faults.DropByteRate > 0 && e.random.Float64() < e.faults.DropByteRate {
+		i := e.random.Intn(len(encoded))
+		encoded = append(encoded[:i:i], encoded[i+1:]...)
+	}
+
+	if e.faults.ResponseDelay > 0 {
+		// Задержка без удержания блокировки, чтобы не мешать сбросу по DTR/RTS
+		e.mu.Unlock()
+		time.Sleep(e.faults.ResponseDelay)
+		e.mu.Lock()
+	}
+
+	e.device.Write(encoded)
+}
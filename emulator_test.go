@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// testImage возвращает воспроизводимый образ прошивки заданного размера
+func testImage(size int) []byte {
+	image := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(image)
+	return image
+}
+
+// flashEmulator прошивает образ в эмулятор через NewESP32FlasherWithTransport и
+// возвращает содержимое flash по адресу записи
+func flashEmulator(t *testing.T, faults EmulatorFaults, image []byte) []byte {
+	t.Helper()
+
+	emu, transport := NewROMEmulator(EMULATOR_FLASH_SIZE, faults)
+	defer emu.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	flasher, err := NewESP32FlasherWithTransport(ctx, transport, nil)
+	if err != nil {
+		t.Fatalf("NewESP32FlasherWithTransport: %v", err)
+	}
+	if err := flasher.FlashSegments(ctx, []FirmwareSegment{{Offset: DEFAULT_APP_OFFSET, Data: image}}); err != nil {
+		t.Fatalf("FlashSegments: %v", err)
+	}
+
+	return emu.Flash(DEFAULT_APP_OFFSET, len(image))
+}
+
+func TestEmulatorFlash(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults EmulatorFaults
+	}{
+		{"clean", EmulatorFaults{}},
+		{"bad checksum", EmulatorFaults{BadChecksumRate: 0.3, Seed: 7}},
+		{"dropped bytes", EmulatorFaults{DropByteRate: 0.001, Seed: 3}},
+		{"response delay", EmulatorFaults{ResponseDelay: 20 * time.Millisecond}},
+	}
+
+	// Не кратно блоку 4 КБ, чтобы последний пакет дополнялся
+	image := testImage(3*4096 + 100)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := flashEmulator(t, tt.faults, image); !bytes.Equal(got, image) {
+				t.Fatal("flash content does not match the image")
+			}
+		})
+	}
+}
+
+func TestEmulatorFlashHexSegmentsInOneSector(t *testing.T) {
+	// Два участка HEX в одном секторе раньше стирали друг друга
+	segments, err := mergeChunks(map[uint32][]byte{
+		0x10000: {0x01, 0x02, 0x03},
+		0x10100: {0x04, 0x05},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emu, transport := NewROMEmulator(EMULATOR_FLASH_SIZE, EmulatorFaults{})
+	defer emu.Close()
+
+	ctx := context.Background()
+	flasher, err := NewESP32FlasherWithTransport(ctx, transport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flasher.FlashSegments(ctx, segments); err != nil {
+		t.Fatalf("FlashSegments: %v", err)
+	}
+
+	if got := emu.Flash(0x10000, 3); !bytes.Equal(got, []byte{0x01, 0x02, 0x03}) {
+		t.Errorf("0x10000: got % x", got)
+	}
+	if got := emu.Flash(0x10100, 2); !bytes.Equal(got, []byte{0x04, 0x05}) {
+		t.Errorf("0x10100: got % x", got)
+	}
+}
+
+func TestEmulatorAfterFlash(t *testing.T) {
+	tests := []struct {
+		after        string
+		wantDownload bool
+	}{
+		{AFTER_NO_RESET, true},
+		{AFTER_RUN, false},
+		{AFTER_SOFT_RESET, false},
+		{AFTER_HARD_RESET, false},
+	}
+
+	image := testImage(4096)
+
+	for _, tt := range tests {
+		name := tt.after
+		if name == "" {
+			name = "hard-reset"
+		}
+		t.Run(name, func(t *testing.T) {
+			emu, transport := NewROMEmulator(EMULATOR_FLASH_SIZE, EmulatorFaults{})
+			defer emu.Close()
+
+			ctx := context.Background()
+			flasher, err := NewESP32FlasherWithConfig(ctx, FlasherConfig{Transport: transport, After: tt.after}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := flasher.FlashSegments(ctx, []FirmwareSegment{{Offset: DEFAULT_APP_OFFSET, Data: image}}); err != nil {
+				t.Fatalf("FlashSegments: %v", err)
+			}
+
+			if got := emu.DownloadMode(); got != tt.wantDownload {
+				t.Errorf("download mode = %v, want %v", got, tt.wantDownload)
+			}
+			if got := emu.Flash(DEFAULT_APP_OFFSET, len(image)); !bytes.Equal(got, image) {
+				t.Error("flash content does not match the image")
+			}
+		})
+	}
+}
+
+// dataCounter считает кадры FLASH_DATA, которые флешер отправил в порт
+type dataCounter struct {
+	Transport
+	packets int
+}
+
+func (c *dataCounter) Write(p []byte) (int, error) {
+	// Каждая команда уходит одним кадром SLIP: 0xC0, направление, код команды
+	if len(p) > 2 && p[0] == SLIP_END && p[1] == 0x00 && p[2] == ESP_FLASH_DATA {
+		c.packets++
+	}
+	return c.Transport.Write(p)
+}
+
+func TestEmulatorFlashDiff(t *testing.T) {
+	emu, transport := NewROMEmulator(EMULATOR_FLASH_SIZE, EmulatorFaults{})
+	defer emu.Close()
+
+	counter := &dataCounter{Transport: transport}
+	ctx := context.Background()
+	flasher, err := NewESP32FlasherWithConfig(ctx, FlasherConfig{Transport: counter, After: AFTER_NO_RESET}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flasher.EnableDiffMode(true)
+
+	// Четыре региона по 64 КБ, во второй прошивке меняется только третий
+	image := testImage(4 * ESP_FLASH_BLOCK)
+	changed := append([]byte(nil), image...)
+	for i := 2 * ESP_FLASH_BLOCK; i < 3*ESP_FLASH_BLOCK; i += 1000 {
+		changed[i] ^= 0xff
+	}
+
+	tests := []struct {
+		name        string
+		image       []byte
+		wantPackets int // пакеты FLASH_DATA по 4 КБ
+	}{
+		{"first flash", image, 4 * ESP_FLASH_BLOCK / 4096},
+		{"same image", image, 0},
+		{"one region changed", changed, ESP_FLASH_BLOCK / 4096},
+	}
+
+	for _, tt := range tests {
+		counter.packets = 0
+		if err := flasher.FlashSegments(ctx, []FirmwareSegment{{Offset: DEFAULT_APP_OFFSET, Data: tt.image}}); err != nil {
+			t.Fatalf("%s: FlashSegments: %v", tt.name, err)
+		}
+		if counter.packets != tt.wantPackets {
+			t.Errorf("%s: %d FLASH_DATA packets, want %d", tt.name, counter.packets, tt.wantPackets)
+		}
+		if got := emu.Flash(DEFAULT_APP_OFFSET, len(tt.image)); !bytes.Equal(got, tt.image) {
+			t.Errorf("%s: flash content does not match the image", tt.name)
+		}
+	}
+}
+
+// flakyEmulator - эмулятор, связь с которым обрывается на заданной записи флешера,
+// как при отключении кабеля. reopen подключается к новому эмулятору с той же flash
+type flakyEmulator struct {
+	flash      []byte
+	emu        *ROMEmulator
+	writes     int
+	dropAt     int  // номер записи, на которой обрывается связь
+	inBootMode bool // новый эмулятор уже в режиме загрузчика (кнопки BOOT+RESET)
+
+	lineChanges int // изменения DTR/RTS после переподключения
+	reconnects  int
+}
+
+func newFlakyEmulator(dropAt int) (*flakyEmulator, Transport) {
+	f := &flakyEmulator{flash: bytes.Repeat([]byte{0xff}, EMULATOR_FLASH_SIZE), dropAt: dropAt}
+	emu, host := newROMEmulator(f.flash, EmulatorFaults{})
+	f.emu = emu
+	return f, &flakyTransport{Transport: host, owner: f}
+}
+
+func (f *flakyEmulator) reopen(string) (Transport, error) {
+	emu, host := newROMEmulator(f.flash, EmulatorFaults{})
+	if f.inBootMode {
+		emu.EnterDownloadMode()
+	}
+	f.emu = emu
+	f.reconnects++
+	return &flakyTransport{Transport: host, owner: f}, nil
+}
+
+type flakyTransport struct {
+	Transport
+	owner *flakyEmulator
+}
+
+// Write на записи dropAt теряет данные и закрывает канал: флешер узнает об обрыве
+// при чтении ответа
+func (t *flakyTransport) Write(p []byte) (int, error) {
+	t.owner.writes++
+	if t.owner.writes == t.owner.dropAt {
+		t.owner.emu.Close()
+		return len(p), nil
+	}
+	return t.Transport.Write(p)
+}
+
+func (t *flakyTransport) SetDTR(dtr bool) error {
+	if t.owner.reconnects > 0 {
+		t.owner.lineChanges++
+	}
+	return t.Transport.SetDTR(dtr)
+}
+
+func (t *flakyTransport) SetRTS(rts bool) error {
+	if t.owner.reconnects > 0 {
+		t.owner.lineChanges++
+	}
+	return t.Transport.SetRTS(rts)
+}
+
+func TestEmulatorFlashResume(t *testing.T) {
+	tests := []struct {
+		name      string
+		before    string
+		wantReset bool // при переподключении чип сбрасывается через DTR/RTS
+	}{
+		{"default reset", BEFORE_DEFAULT_RESET, true},
+		{"no reset", BEFORE_NO_RESET, false},
+		{"already in bootloader", BEFORE_IN_BOOTLOADER, false},
+	}
+
+	image := testImage(16*4096 + 100)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Обрыв на середине записи участка
+			flaky, transport := newFlakyEmulator(30)
+			defer func() { flaky.emu.Close() }()
+			if tt.before != BEFORE_DEFAULT_RESET {
+				flaky.emu.EnterDownloadMode()
+				flaky.inBootMode = true
+			}
+
+			ctx := context.Background()
+			flasher, err := NewESP32FlasherWithConfig(ctx, FlasherConfig{
+				Transport: transport,
+				Reopen:    flaky.reopen,
+				Before:    tt.before,
+				After:     AFTER_NO_RESET,
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := flasher.FlashSegments(ctx, []FirmwareSegment{{Offset: DEFAULT_APP_OFFSET, Data: image}}); err != nil {
+				t.Fatalf("FlashSegments: %v", err)
+			}
+
+			if flaky.reconnects != 1 {
+				t.Fatalf("reconnects = %d, want 1", flaky.reconnects)
+			}
+			if reset := flaky.lineChanges > 0; reset != tt.wantReset {
+				t.Errorf("reset on reconnect = %v, want %v", reset, tt.wantReset)
+			}
+
+			if got := flaky.emu.Flash(DEFAULT_APP_OFFSET, len(image)); !bytes.Equal(got, image) {
+				t.Error("flash content does not match the image")
+			}
+			remote, err := flasher.flashMD5(ctx, DEFAULT_APP_OFFSET, uint32(len(image)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if local := md5.Sum(image); !bytes.Equal(remote, local[:]) {
+				t.Errorf("flash MD5 %x, want %x", remote, local)
+			}
+		})
+	}
+}
@@ -2,12 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
-
-	"go.bug.st/serial"
 )
 
 // ESP32 протокол команд
@@ -21,7 +21,10 @@ const (
 	ESP_SYNC        = 0x08
 	ESP_WRITE_REG   = 0x09
 	ESP_READ_REG    = 0x0a
+	ESP_SPI_PARAMS  = 0x0b
 	ESP_SPI_ATTACH  = 0x0d
+	ESP_READ_FLASH  = 0x0e // READ_FLASH_SLOW в ROM загрузчике
+	ESP_SPI_MD5     = 0x13
 
 	// SLIP протокол
 	SLIP_END     = 0xc0
@@ -37,6 +40,13 @@ const (
 	// https://github.com/espressif/esp-serial-flasher
 	SERIAL_FLASHER_RESET_HOLD_TIME_MS = 100 // время удержания RESET в миллисекундах
 	SERIAL_FLASHER_BOOT_HOLD_TIME_MS  = 50  // время удержания BOOT (GPIO0) в миллисекундах
+
+	// Интервал опроса порта, с которым проверяется отмена операции
+	READ_POLL_INTERVAL = 50 * time.Millisecond
+
+	// Сколько отмена ждет сброса: USB-JTAG порт после сброса может пропасть
+	// на RECONNECT_TIMEOUT, а пользователь ждет реакции на кнопку сразу
+	ABORT_RESET_TIMEOUT = 3 * time.Second
 )
 
 // ProgressCallback интерфейс для коллбеков прогресса
@@ -47,21 +57,30 @@ type ProgressCallback interface {
 
 // ESP32Flasher - структура для работы с ESP32
 type ESP32Flasher struct {
-	port     serial.Port
-	portName string
+	port     Transport
+	portName string // Пустое имя без reopen означает, что канал нельзя открыть заново
 	callback ProgressCallback
+	diffMode bool // Записывать только регионы, MD5 которых отличается от образа
+
+	resetStrategy string // Стратегия сброса (RESET_*) или последовательность вида "D0|R1|W100"
+	beforeAction  string // Действие перед прошивкой (BEFORE_*), повторяется при переподключении
+	afterAction   string // Действие после прошивки (AFTER_*)
+	invertedReset bool   // Bootloader удалось включить только инвертированной логикой DTR/RTS
+
+	flashInfo    *FlashChipInfo // Опознанная flash, nil до spiAttach или если flash не отвечает
+	expectedChip string         // Семейство чипа, для которого собрана прошивка; пусто - не проверять
+
+	lease    *PortLease                           // Аренда порта у менеджера портов, nil - порт открыт напрямую
+	reopen   func(name string) (Transport, error) // Открытие канала заново при обрыве, nil - по имени порта
+	detached bool                                 // Канал передан другому владельцу через Detach
+
+	slip  slipStream     // Потоковый декодер ответов загрузчика
+	trace *TraceRecorder // Запись трассы, продолжается и после переподключения
 }
 
 // NewESP32Flasher создает новый экземпляр флешера
 func NewESP32Flasher(portName string) (*ESP32Flasher, error) {
-	mode := &serial.Mode{
-		BaudRate: 115200,
-		Parity:   serial.NoParity,
-		DataBits: 8,
-		StopBits: serial.OneStopBit,
-	}
-
-	port, err := serial.Open(portName, mode)
+	port, err := OpenTransport(portName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open port: %w", err)
 	}
@@ -72,29 +91,91 @@ func NewESP32Flasher(portName string) (*ESP32Flasher, error) {
 	}, nil
 }
 
-// NewESP32FlasherWithProgress создает новый экземпляр флешера с коллбеками прогресса
-func NewESP32FlasherWithProgress(portName string, callback ProgressCallback) (*ESP32Flasher, error) {
-	// Начинаем с низкой скорости для надежной синхронизации
-	mode := &serial.Mode{
-		BaudRate: 115200,
-		Parity:   serial.NoParity,
-		DataBits: 8,
-		StopBits: serial.OneStopBit,
+// FlasherConfig - параметры подключения флешера
+type FlasherConfig struct {
+	PortName  string         // имя порта для OpenTransport и переподключения
+	Transport Transport      // готовый канал; если задан, PortName не открывается
+	Lease     *PortLease     // аренда порта; флешер открывает порт заново через нее и освобождает в Close
+	Trace     *TraceRecorder // запись трассы протокола, nil - не записывать
+	Reset     string         // стратегия сброса, RESET_AUTO - перебор эталонных вариантов
+	Before    string         // действие перед прошивкой (BEFORE_*)
+	After     string         // действие после прошивки (AFTER_*)
+
+	// Открытие канала заново после обрыва связи вместо OpenTransport
+	// (воспроизведение трассы, эмулятор). Используется, когда нет аренды Lease
+	Reopen func(name string) (Transport, error)
+}
+
+// NewESP32FlasherWithProgress открывает порт по имени и создает флешер с коллбеками прогресса
+func NewESP32FlasherWithProgress(ctx context.Context, portName string, callback ProgressCallback) (*ESP32Flasher, error) {
+	return NewESP32FlasherWithConfig(ctx, FlasherConfig{PortName: portName}, callback)
+}
+
+// NewESP32FlasherWithTransport создает флешер поверх произвольного канала связи
+// и переводит ESP32 в режим загрузки. При ошибке канал остается открытым
+func NewESP32FlasherWithTransport(ctx context.Context, transport Transport, callback ProgressCallback) (*ESP32Flasher, error) {
+	return NewESP32FlasherWithConfig(ctx, FlasherConfig{Transport: transport}, callback)
+}
+
+// NewESP32FlasherWithConfig создает флешер по конфигурации и переводит ESP32 в режим загрузки.
+// Канал, открытый по PortName, закрывается при ошибке; переданный в Transport - нет.
+// Аренда Lease при любой ошибке освобождается
+func NewESP32FlasherWithConfig(ctx context.Context, config FlasherConfig, callback ProgressCallback) (*ESP32Flasher, error) {
+	if err := validateFlasherConfig(config); err != nil {
+		if config.Lease != nil {
+			config.Lease.Release()
+		}
+		return nil, err
 	}
 
-	port, err := serial.Open(portName, mode)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open port: %w", err)
+	port := config.Transport
+	portName := ""
+	if config.Lease != nil {
+		port = config.Lease.Port()
+		portName = config.Lease.Name()
+	} else if port == nil {
+		var err error
+		port, err = OpenTransport(config.PortName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open port: %w", err)
+		}
+		// Имя порта нужно для переподключения при обрыве связи
+		portName = config.PortName
+	}
+
+	if config.Trace != nil {
+		port = config.Trace.Wrap(port)
 	}
 
 	flasher := &ESP32Flasher{
 		port:     port,
 		portName: portName,
 		callback: callback,
+		trace:    config.Trace,
+		lease:    config.Lease,
+		reopen:   config.Reopen,
+
+		resetStrategy: config.Reset,
+		beforeAction:  config.Before,
+		afterAction:   config.After,
+	}
+
+	if strategy := effectiveResetStrategy(config.Reset, portName); strategy != config.Reset {
+		if callback != nil {
+			callback.emitLog("🔌 Обнаружен USB-Serial/JTAG Espressif (VID 303A), используется сброс usb-jtag")
+		}
+		flasher.resetStrategy = strategy
 	}
 
 	// Пытаемся перевести ESP32 в режим загрузки
-	if err := flasher.enterBootloader(); err != nil {
+	if err := flasher.prepareBootloader(ctx, flasher.beforeAction); err != nil {
+		// Порт мог быть открыт заново при переподключении USB во время сброса
+		if config.Transport == nil || config.Lease != nil {
+			flasher.Close()
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
 		if callback != nil {
 			callback.emitLog("⚠️ Не удалось автоматически перевести ESP32 в режим загрузки")
 			callback.emitLog("Убедитесь, что ESP32 находится в режиме загрузки (boot mode)")
@@ -105,22 +186,42 @@ func NewESP32FlasherWithProgress(portName string, callback ProgressCallback) (*E
 	return flasher, nil
 }
 
+// effectiveResetStrategy возвращает стратегию сброса, которую флешер применит к порту:
+// встроенный USB-Serial/JTAG не сбрасывается классической последовательностью
+func effectiveResetStrategy(strategy, portName string) string {
+	if strategy == RESET_AUTO && isLocalPort(portName) && IsUSBJTAGSerial(portName) {
+		return RESET_USB_JTAG
+	}
+	return strategy
+}
+
+// validateFlasherConfig проверяет стратегию сброса и действия до и после прошивки
+func validateFlasherConfig(config FlasherConfig) error {
+	if err := ValidateResetStrategy(config.Reset); err != nil {
+		return err
+	}
+	return ValidateFlashActions(config.Before, config.After)
+}
+
 // enterBootloader переводит ESP32 в режим загрузки, используя эталонную реализацию Espressif
-func (f *ESP32Flasher) enterBootloader() error {
+func (f *ESP32Flasher) enterBootloader(ctx context.Context) error {
+	if f.resetStrategy != RESET_AUTO {
+		return f.enterBootloaderWithStrategy(ctx, f.resetStrategy)
+	}
+
 	if f.callback != nil {
 		f.callback.emitLog("🔄 Перевод ESP32 в режим загрузки...")
 		f.callback.emitLog("📘 Используется эталонная реализация esp-serial-flasher v0.3.0")
 	}
 
 	// Очищаем буферы перед началом
-	f.port.ResetInputBuffer()
-	f.port.ResetOutputBuffer()
+	f.resetBuffers()
 	time.Sleep(50 * time.Millisecond)
 
 	// Выполняем эталонную последовательность USB-UART конвертера
 	if err := f.usbSerialConverterEnterBootloader(); err == nil {
 		// Проверяем режим bootloader
-		if f.testBootloaderMode() {
+		if f.testBootloaderMode(ctx) {
 			if f.callback != nil {
 				f.callback.emitLog("✅ ESP32 успешно переведен в режим bootloader")
 			}
@@ -128,20 +229,29 @@ func (f *ESP32Flasher) enterBootloader() error {
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Если не удалось, возможно нужна инвертированная логика
 	if f.callback != nil {
 		f.callback.emitLog("⚠️ Стандартная логика не сработала, пробуем инвертированную...")
 	}
 
 	if err := f.usbSerialConverterEnterBootloaderInverted(); err == nil {
-		if f.testBootloaderMode() {
+		if f.testBootloaderMode(ctx) {
 			if f.callback != nil {
 				f.callback.emitLog("✅ ESP32 успешно переведен в режим bootloader (инвертированная логика)")
 			}
+			f.invertedReset = true
 			return nil
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Если оба варианта не сработали
 	if f.callback != nil {
 		f.callback.emitLog("❌ Автоматический перевод в bootloader не удался")
@@ -160,11 +270,54 @@ func (f *ESP32Flasher) enterBootloader() error {
 	return fmt.Errorf("failed to enter bootloader mode")
 }
 
-// Close закрывает соединение
+// Close закрывает соединение и освобождает аренду порта, если канал не был передан через Detach
 func (f *ESP32Flasher) Close() error {
+	if f.detached {
+		return nil
+	}
+	if f.lease != nil {
+		return f.lease.Release()
+	}
 	return f.port.Close()
 }
 
+// Detach передает открытый канал вызывающему (например, монитору порта) и возвращает его
+// без записи трассы. После этого Close флешера канал не закрывает
+func (f *ESP32Flasher) Detach() Transport {
+	f.detached = true
+	if traced, ok := f.port.(*tracedTransport); ok {
+		return traced.inner
+	}
+	return f.port
+}
+
+// resetBuffers очищает буферы порта и незавершенные кадры SLIP декодера
+func (f *ESP32Flasher) resetBuffers() {
+	f.port.ResetInputBuffer()
+	f.port.ResetOutputBuffer()
+	f.slip.reset()
+}
+
+// Abort возвращает ESP32 в известное состояние после отмены операции:
+// чип перезагружается в режим загрузчика, незавершенная запись сбрасывается
+func (f *ESP32Flasher) Abort() {
+	if f.callback != nil {
+		f.callback.emitLog("🛑 Операция отменена, перезапуск ESP32 в режим загрузчика...")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ABORT_RESET_TIMEOUT)
+	defer cancel()
+
+	switch {
+	case f.resetStrategy != RESET_AUTO:
+		f.resetWithStrategy(ctx, f.resetStrategy)
+	case f.invertedReset:
+		f.espressifReferenceResetInverted()
+	default:
+		f.espressifReferenceReset()
+	}
+}
+
 // slipEncode кодирует данные в SLIP протокол
 func slipEncode(data []byte) []byte {
 	var buf bytes.Buffer
@@ -187,37 +340,6 @@ func slipEncode(data []byte) []byte {
 	return buf.Bytes()
 }
 
-// slipDecode декодирует SLIP пакет
-func slipDecode(data []byte) ([]byte, error) {
-	if len(data) < 2 || data[0] != SLIP_END || data[len(data)-1] != SLIP_END {
-		return nil, fmt.Errorf("invalid SLIP packet")
-	}
-
-	var buf bytes.Buffer
-	escaped := false
-
-	for i := 1; i < len(data)-1; i++ {
-		b := data[i]
-		if escaped {
-			switch b {
-			case SLIP_ESC_END:
-				buf.WriteByte(SLIP_END)
-			case SLIP_ESC_ESC:
-				buf.WriteByte(SLIP_ESC)
-			default:
-				return nil, fmt.Errorf("invalid escape sequence")
-			}
-			escaped = false
-		} else if b == SLIP_ESC {
-			escaped = true
-		} else {
-			buf.WriteByte(b)
-		}
-	}
-
-	return buf.Bytes(), nil
-}
-
 // sendCommand отправляет команду в ESP32
 func (f *ESP32Flasher) sendCommand(cmd byte, data []byte, checksum uint32) error {
 	// Создаем пакет команды
@@ -230,89 +352,66 @@ func (f *ESP32Flasher) sendCommand(cmd byte, data []byte, checksum uint32) error
 
 	// Кодируем в SLIP и отправляем
 	encoded := slipEncode(packet)
-	_, err := f.port.Write(encoded)
-	return err
+	if _, err := f.port.Write(encoded); err != nil {
+		return fmt.Errorf("%w: %v", errPortIO, err)
+	}
+	return nil
 }
 
-// readResponse читает ответ от ESP32 с улучшенной обработкой
-func (f *ESP32Flasher) readResponse(timeout time.Duration) ([]byte, error) {
-	f.port.SetReadTimeout(timeout)
+// readResponse ждет ответ на команду cmd. Данные из порта подаются в потоковый
+// SLIP декодер, и функция возвращается сразу, как только придет нужный кадр
+func (f *ESP32Flasher) readResponse(ctx context.Context, cmd byte, timeout time.Duration) ([]byte, error) {
+	// Читаем короткими интервалами, чтобы вовремя заметить отмену
+	f.port.SetReadTimeout(READ_POLL_INTERVAL)
 
-	var allData bytes.Buffer
+	var rawData bytes.Buffer
 	buffer := make([]byte, 1024)
+	deadline := time.Now().Add(timeout)
 
-	start := time.Now()
-
-	// Сначала читаем все доступные данные
-	for time.Since(start) < timeout {
-		n, err := f.port.Read(buffer)
-		if err != nil && n == 0 {
-			if allData.Len() > 0 {
-				break // Если уже что-то прочитали, прекращаем ждать
+	for {
+		// Кадр мог прийти вместе с предыдущим ответом
+		if frame, stale := f.slip.next(cmd); frame != nil {
+			if f.callback != nil {
+				if rawData.Len() > 0 {
+					f.callback.emitLog(fmt.Sprintf("🔍 Сырые данные (%d байт): %x", rawData.Len(), rawData.Bytes()))
+				}
+				for _, old := range stale {
+					f.callback.emitLog(fmt.Sprintf("⚠️ Пропущен устаревший кадр (%d байт): %x", len(old), old))
+				}
+				f.callback.emitLog(fmt.Sprintf("✅ Декодированный пакет (%d байт): %x", len(frame), frame))
 			}
-			time.Sleep(1 * time.Millisecond)
-			continue
+			return frame, nil
 		}
 
-		if n > 0 {
-			allData.Write(buffer[:n])
-			// Даем немного времени на получение остальных данных
-			time.Sleep(10 * time.Millisecond)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			break
 		}
-	}
-
-	if allData.Len() == 0 {
-		return nil, fmt.Errorf("timeout reading response after %v", timeout)
-	}
-
-	rawData := allData.Bytes()
-
-	// Логируем сырые данные для отладки
-	if f.callback != nil {
-		f.callback.emitLog(fmt.Sprintf("🔍 Сырые данные (%d байт): %x", len(rawData), rawData))
-	}
-
-	// Ищем SLIP пакет в данных
-	for i := 0; i < len(rawData); i++ {
-		if rawData[i] == SLIP_END {
-			// Нашли начало пакета, ищем конец
-			for j := i + 1; j < len(rawData); j++ {
-				if rawData[j] == SLIP_END {
-					// Нашли конец пакета
-					slipPacket := rawData[i : j+1]
-					if f.callback != nil {
-						f.callback.emitLog(fmt.Sprintf("🔍 Найден SLIP пакет (%d байт): %x", len(slipPacket), slipPacket))
-					}
-
-					decoded, err := slipDecode(slipPacket)
-					if err != nil {
-						if f.callback != nil {
-							f.callback.emitLog(fmt.Sprintf("⚠️ Ошибка декодирования SLIP: %v", err))
-						}
-						continue
-					}
 
-					if f.callback != nil {
-						f.callback.emitLog(fmt.Sprintf("✅ Декодированный пакет (%d байт): %x", len(decoded), decoded))
-					}
+		n, err := f.port.Read(buffer)
+		if err != nil && n == 0 {
+			// Таймаут чтения возвращается без ошибки, значит порт недоступен
+			return nil, fmt.Errorf("%w: %v", errPortIO, err)
+		}
 
-					return decoded, nil
-				}
-			}
+		if n > 0 {
+			rawData.Write(buffer[:n])
+			f.slip.feed(buffer[:n])
 		}
 	}
 
-	// Если SLIP пакет не найден, возвращаем сырые данные
-	if f.callback != nil {
-		f.callback.emitLog("⚠️ SLIP пакет не найден, возвращаем сырые данные")
+	if f.callback != nil && rawData.Len() > 0 {
+		f.callback.emitLog(fmt.Sprintf("🔍 Сырые данные без ответа на 0x%02x (%d байт): %x", cmd, rawData.Len(), rawData.Bytes()))
 	}
 
-	return rawData, nil
+	return nil, fmt.Errorf("timeout waiting for response to command 0x%02x after %v", cmd, timeout)
 }
 
 // sync синхронизируется с ESP32 точно как esptool.py
 // sync синхронизируется с ESP32 (упрощенная версия без повторных попыток reset)
-func (f *ESP32Flasher) sync() error {
+func (f *ESP32Flasher) sync(ctx context.Context) error {
 	if f.callback != nil {
 		f.callback.emitLog("🔄 Синхронизация с ESP32...")
 	}
@@ -328,8 +427,7 @@ func (f *ESP32Flasher) sync() error {
 	}
 
 	// Очищаем буферы
-	f.port.ResetInputBuffer()
-	f.port.ResetOutputBuffer()
+	f.resetBuffers()
 	time.Sleep(100 * time.Millisecond)
 
 	// Отправляем SYNC команду
@@ -342,7 +440,7 @@ func (f *ESP32Flasher) sync() error {
 	}
 
 	// Читаем ответ
-	response, err := f.readResponse(3 * time.Second)
+	response, err := f.readResponse(ctx, ESP_SYNC, 3*time.Second)
 	if err != nil {
 		return fmt.Errorf("timeout reading sync response: %w", err)
 	}
@@ -351,23 +449,9 @@ func (f *ESP32Flasher) sync() error {
 		f.callback.emitLog(fmt.Sprintf("📥 Получен ответ длиной %d байт: %x", len(response), response))
 	}
 
-	// Проверяем правильность ответа на SYNC
-	if len(response) < 8 {
-		return fmt.Errorf("sync response too short: %d bytes", len(response))
-	}
-
-	// Проверяем заголовок: direction=0x01, command=ESP_SYNC
-	if response[0] != 0x01 || response[1] != ESP_SYNC {
-		return fmt.Errorf("invalid sync response header: dir=0x%02x, cmd=0x%02x", response[0], response[1])
-	}
-
-	// ESP32 ROM loader использует 4 байта для статуса (в отличие от stub loader с 2 байтами)
-	if len(response) >= 12 {
-		status := response[len(response)-4] // Статус находится в 4-м байте с конца
-		if status != 0x00 {
-			errorCode := response[len(response)-3] // Ошибка в 3-м байте с конца
-			return fmt.Errorf("sync failed: status=%d, error=%d", status, errorCode)
-		}
+	// Проверяем заголовок (direction=0x01, command=ESP_SYNC) и статус
+	if err := checkResponse(ESP_SYNC, response); err != nil {
+		return err
 	}
 
 	if f.callback != nil {
@@ -383,14 +467,14 @@ func (f *ESP32Flasher) sync() error {
 		f.sendCommand(ESP_SYNC, []byte{}, 0)
 		time.Sleep(10 * time.Millisecond)
 		// Читаем и игнорируем ответы
-		f.readResponse(100 * time.Millisecond)
+		f.readResponse(ctx, ESP_SYNC, 100*time.Millisecond)
 	}
 
 	return nil
 }
 
 // spiAttach подключает SPI flash
-func (f *ESP32Flasher) spiAttach() error {
+func (f *ESP32Flasher) spiAttach(ctx context.Context) error {
 	if f.callback != nil {
 		f.callback.emitLog("🔗 Подключение к SPI Flash...")
 	}
@@ -412,22 +496,13 @@ func (f *ESP32Flasher) spiAttach() error {
 		f.callback.emitLog("⏳ Ожидание ответа на SPI_ATTACH...")
 	}
 
-	response, err := f.readResponse(3 * time.Second)
+	response, err := f.readResponse(ctx, ESP_SPI_ATTACH, 3*time.Second)
 	if err != nil {
 		return fmt.Errorf("timeout waiting for SPI attach response: %w", err)
 	}
 
-	if len(response) < 8 || response[0] != 0x01 || response[1] != ESP_SPI_ATTACH {
-		return fmt.Errorf("invalid SPI attach response (len=%d, dir=0x%02x, cmd=0x%02x)", len(response), response[0], response[1])
-	}
-
-	// Проверяем статус (ESP32 ROM loader использует 4 байта)
-	if len(response) >= 12 {
-		status := response[len(response)-4]
-		if status != 0x00 {
-			errorCode := response[len(response)-3]
-			return fmt.Errorf("SPI attach failed: status=%d, error=%d", status, errorCode)
-		}
+	if err := checkResponse(ESP_SPI_ATTACH, response); err != nil {
+		return err
 	}
 
 	if f.callback != nil {
@@ -447,7 +522,7 @@ func calculateChecksum(data []byte) uint32 {
 }
 
 // flashBegin начинает процесс прошивки
-func (f *ESP32Flasher) flashBegin(size, offset uint32) error {
+func (f *ESP32Flasher) flashBegin(ctx context.Context, size, offset uint32) error {
 	if f.callback != nil {
 		f.callback.emitLog(fmt.Sprintf("📋 Начало прошивки: размер %d байт, адрес 0x%x", size, offset))
 	}
@@ -482,22 +557,13 @@ func (f *ESP32Flasher) flashBegin(size, offset uint32) error {
 		f.callback.emitLog("⏳ Ожидание ответа на FLASH_BEGIN (может занять до 15 секунд для стирания)...")
 	}
 
-	response, err := f.readResponse(15 * time.Second) // Увеличиваем таймаут для стирания
+	response, err := f.readResponse(ctx, ESP_FLASH_BEGIN, 15*time.Second) // Увеличиваем таймаут для стирания
 	if err != nil {
 		return fmt.Errorf("flash begin timeout: %w", err)
 	}
 
-	if len(response) < 8 || response[0] != 0x01 || response[1] != ESP_FLASH_BEGIN {
-		return fmt.Errorf("flash begin failed: invalid response (len=%d, dir=0x%02x, cmd=0x%02x)", len(response), response[0], response[1])
-	}
-
-	// Проверяем статус (ESP32 ROM loader использует 4 байта)
-	if len(response) >= 12 {
-		status := response[len(response)-4]
-		if status != 0x00 {
-			errorCode := response[len(response)-3]
-			return fmt.Errorf("flash begin failed: status=%d, error=%d", status, errorCode)
-		}
+	if err := checkResponse(ESP_FLASH_BEGIN, response); err != nil {
+		return err
 	}
 
 	if f.callback != nil {
@@ -508,7 +574,7 @@ func (f *ESP32Flasher) flashBegin(size, offset uint32) error {
 }
 
 // flashData отправляет блок данных для прошивки
-func (f *ESP32Flasher) flashData(data []byte, seq uint32) error {
+func (f *ESP32Flasher) flashData(ctx context.Context, data []byte, seq uint32) error {
 	// Заголовок данных
 	header := make([]byte, 16)
 	binary.LittleEndian.PutUint32(header[0:4], uint32(len(data))) // Data size
@@ -530,8 +596,11 @@ func (f *ESP32Flasher) flashData(data []byte, seq uint32) error {
 			continue
 		}
 
-		response, err := f.readResponse(5 * time.Second)
+		response, err := f.readResponse(ctx, ESP_FLASH_DATA, 5*time.Second)
 		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
 			if attempt == 2 {
 				return fmt.Errorf("timeout reading flash data response at seq %d: %w", seq, err)
 			}
@@ -539,52 +608,40 @@ func (f *ESP32Flasher) flashData(data []byte, seq uint32) error {
 			continue
 		}
 
-		if len(response) < 8 || response[0] != 0x01 || response[1] != ESP_FLASH_DATA {
+		if err := checkResponse(ESP_FLASH_DATA, response); err != nil {
+			// Поврежденный при передаче блок можно отправить еще раз,
+			// остальные ошибки загрузчика повтором не исправить
+			var romErr *ROMError
+			if errors.As(err, &romErr) && !romErr.Retryable() {
+				return fmt.Errorf("flash data failed at seq %d: %w", seq, err)
+			}
 			if attempt == 2 {
-				return fmt.Errorf("invalid flash data response at sequence %d", seq)
+				return fmt.Errorf("flash data failed at seq %d after 3 attempts: %w", seq, err)
+			}
+			if f.callback != nil {
+				f.callback.emitLog(fmt.Sprintf("⚠️ Блок %d: %v, повтор...", seq, err))
 			}
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 
-		// Проверяем статус (ESP32 ROM loader использует 4 байта)
-		if len(response) >= 12 {
-			status := response[len(response)-4]
-			if status != 0x00 {
-				errorCode := response[len(response)-3]
-				return fmt.Errorf("flash data failed at seq %d: status=%d, error=%d", seq, status, errorCode)
-			}
-		}
-
 		return nil // Успех
 	}
 
 	return fmt.Errorf("flash data failed at sequence %d after 3 attempts", seq)
 }
 
-// flashEnd завершает процесс прошивки
-func (f *ESP32Flasher) flashEnd() error {
-	data := make([]byte, 4)
-	binary.LittleEndian.PutUint32(data, 0) // Reboot
-
-	if err := f.sendCommand(ESP_FLASH_END, data, 0); err != nil {
-		return err
-	}
-
-	response, err := f.readResponse(3 * time.Second)
-	if err != nil {
-		return err
-	}
+// FlashData прошивает данные в ESP32
+func (f *ESP32Flasher) FlashData(ctx context.Context, data []byte, offset uint32, portName string) error {
+	return f.FlashSegments(ctx, []FirmwareSegment{{Offset: offset, Data: data}})
+}
 
-	if len(response) < 8 || response[0] != 0x01 || response[1] != ESP_FLASH_END {
-		return fmt.Errorf("flash end failed")
+// FlashSegments прошивает набор сегментов в ESP32 за одну сессию загрузчика
+func (f *ESP32Flasher) FlashSegments(ctx context.Context, segments []FirmwareSegment) error {
+	if len(segments) == 0 {
+		return fmt.Errorf("no firmware segments to flash")
 	}
 
-	return nil
-}
-
-// FlashData прошивает данные в ESP32
-func (f *ESP32Flasher) FlashData(data []byte, offset uint32, portName string) error {
 	// 0. Пробуждение ESP32
 	f.wakeupESP32()
 
@@ -601,39 +658,122 @@ func (f *ESP32Flasher) FlashData(data []byte, offset uint32, portName string) er
 		f.callback.emitProgress(30, "Синхронизация...")
 	}
 
-	if err := f.sync(); err != nil {
+	if err := f.sync(ctx); err != nil {
 		return fmt.Errorf("sync failed: %w", err)
 	}
 
+	// 1.5. Прошивка UF2 должна совпадать с семейством чипа
+	if err := f.checkChip(ctx); err != nil {
+		return err
+	}
+
 	// 2. Подключение SPI
 	if f.callback != nil {
 		f.callback.emitLog("🔗 Подключение к SPI Flash...")
 		f.callback.emitProgress(40, "Подключение SPI...")
 	}
-	if err := f.spiAttach(); err != nil {
+	if err := f.spiAttach(ctx); err != nil {
 		return fmt.Errorf("SPI attach failed: %w", err)
 	}
 
-	// 3. Начало прошивки
-	if f.callback != nil {
-		f.callback.emitLog("🗑️ Стирание секторов Flash...")
-		f.callback.emitProgress(50, "Стирание Flash...")
+	// 2.5. Опознание flash и проверка размера образа
+	if err := f.setupFlash(ctx, segments); err != nil {
+		return err
 	}
-	if err := f.flashBegin(uint32(len(data)), offset); err != nil {
-		return fmt.Errorf("flash begin failed: %w", err)
+
+	// 3. Определяем, какие участки нужно записать
+	jobs := segments
+	if f.diffMode {
+		if f.callback != nil {
+			f.callback.emitLog("🔍 Дифференциальный режим: сравнение регионов по MD5...")
+			f.callback.emitProgress(45, "Сравнение с содержимым flash...")
+		}
+
+		var err error
+		var stats diffStats
+		jobs, stats, err = f.planDiffWrites(ctx, segments)
+		if err != nil {
+			return fmt.Errorf("differential compare failed: %w", err)
+		}
+
+		if f.callback != nil {
+			f.callback.emitLog(fmt.Sprintf("📊 Изменено регионов: %d/%d, пропущено %d КБ из %d КБ",
+				stats.changedRegions, stats.totalRegions, stats.skippedBytes/1024, stats.totalBytes/1024))
+			f.callback.emitProgress(50, fmt.Sprintf("Пропущено %d/%d регионов (%d КБ)",
+				stats.totalRegions-stats.changedRegions, stats.totalRegions, stats.skippedBytes/1024))
+		}
+
+		if len(jobs) == 0 {
+			if f.callback != nil {
+				f.callback.emitLog("✅ Содержимое flash совпадает с образом, запись не требуется")
+				f.callback.emitProgress(90, "Все регионы совпадают, запись пропущена")
+			}
+
+			// FLASH_END для запуска принимается только после FLASH_BEGIN, поэтому открываем пустую сессию
+			if f.afterAction == AFTER_RUN {
+				if err := f.flashBegin(ctx, 0, segments[0].Offset); err != nil {
+					return fmt.Errorf("flash begin failed: %w", err)
+				}
+			}
+		}
 	}
 
-	// 4. Отправка данных блоками
+	// 4. Стирание и запись каждого участка
 	blockSize := 4096
+	totalBlocks := 0
+	for _, job := range jobs {
+		totalBlocks += (len(job.Data) + blockSize - 1) / blockSize
+	}
+
+	written := 0
+	for i, job := range jobs {
+		if f.callback != nil {
+			if len(jobs) > 1 {
+				f.callback.emitLog(fmt.Sprintf("📍 Участок %d/%d: %d байт по адресу 0x%x", i+1, len(jobs), len(job.Data), job.Offset))
+			}
+			f.callback.emitLog("🗑️ Стирание секторов Flash...")
+			if i == 0 {
+				f.callback.emitProgress(50, "Стирание Flash...")
+			}
+		}
+
+		if err := f.writeJob(ctx, job, blockSize, written, totalBlocks); err != nil {
+			return err
+		}
+		written += (len(job.Data) + blockSize - 1) / blockSize
+	}
+
+	// 5. Завершение прошивки
+	if f.callback != nil {
+		f.callback.emitLog("🔄 Завершение прошивки...")
+		f.callback.emitProgress(95, "Завершение...")
+	}
+	if err := f.finishFlash(ctx); err != nil {
+		return fmt.Errorf("flash end failed: %w", err)
+	}
+
+	return nil
+}
+
+// writeBlocks отправляет данные сегмента блоками после flashBegin и возвращает
+// количество подтвержденных блоков. done и totalBlocks используются для расчета
+// общего прогресса по всем сегментам
+func (f *ESP32Flasher) writeBlocks(ctx context.Context, data []byte, blockSize, done, totalBlocks int) (int, error) {
 	seq := uint32(0)
-	totalBlocks := (len(data) + blockSize - 1) / blockSize
+	segmentBlocks := (len(data) + blockSize - 1) / blockSize
 
 	if f.callback != nil {
-		f.callback.emitLog(fmt.Sprintf("📤 Начинаем передачу данных (%d блоков по %d байт)...", totalBlocks, blockSize))
-		f.callback.emitProgress(60, "Передача данных...")
+		f.callback.emitLog(fmt.Sprintf("📤 Начинаем передачу данных (%d блоков по %d байт)...", segmentBlocks, blockSize))
+		if done == 0 {
+			f.callback.emitProgress(60, "Передача данных...")
+		}
 	}
 
 	for i := 0; i < len(data); i += blockSize {
+		if err := ctx.Err(); err != nil {
+			return int(seq), err
+		}
+
 		end := i + blockSize
 		if end > len(data) {
 			end = len(data)
@@ -646,35 +786,27 @@ func (f *ESP32Flasher) FlashData(data []byte, offset uint32, portName string) er
 			block[j] = 0xFF
 		}
 
-		if err := f.flashData(block, seq); err != nil {
-			return fmt.Errorf("flash data failed at block %d/%d: %w", seq+1, totalBlocks, err)
+		if err := f.flashData(ctx, block, seq); err != nil {
+			return int(seq), fmt.Errorf("flash data failed at block %d/%d: %w", done+int(seq)+1, totalBlocks, err)
 		}
 
 		// Обновляем прогресс
 		if f.callback != nil {
-			progress := 60 + int(float64(seq+1)/float64(totalBlocks)*30) // 60-90%
-			percent := float64(seq+1) / float64(totalBlocks) * 100
-			f.callback.emitProgress(progress, fmt.Sprintf("Запись %.1f%% (%d/%d блоков)", percent, seq+1, totalBlocks))
+			current := done + int(seq) + 1
+			progress := 60 + int(float64(current)/float64(totalBlocks)*30) // 60-90%
+			percent := float64(current) / float64(totalBlocks) * 100
+			f.callback.emitProgress(progress, fmt.Sprintf("Запись %.1f%% (%d/%d блоков)", percent, current, totalBlocks))
 
 			// Более частое логирование для лучшей обратной связи
-			if seq%5 == 0 || seq == uint32(totalBlocks-1) { // Логируем каждый 5-й блок или последний
-				f.callback.emitLog(fmt.Sprintf("📦 Записан блок %d/%d (%.1f%%, %d байт)", seq+1, totalBlocks, percent, end-i))
+			if seq%5 == 0 || current == totalBlocks { // Логируем каждый 5-й блок или последний
+				f.callback.emitLog(fmt.Sprintf("📦 Записан блок %d/%d (%.1f%%, %d байт)", current, totalBlocks, percent, end-i))
 			}
 		}
 
 		seq++
 	}
 
-	// 5. Завершение прошивки
-	if f.callback != nil {
-		f.callback.emitLog("🔄 Завершение прошивки...")
-		f.callback.emitProgress(95, "Завершение...")
-	}
-	if err := f.flashEnd(); err != nil {
-		return fmt.Errorf("flash end failed: %w", err)
-	}
-
-	return nil
+	return int(seq), nil
 }
 
 // wakeupESP32 отправляет пробные данные для "пробуждения" ESP32
@@ -689,8 +821,7 @@ func (f *ESP32Flasher) wakeupESP32() {
 	time.Sleep(10 * time.Millisecond)
 
 	// Очищаем буферы
-	f.port.ResetInputBuffer()
-	f.port.ResetOutputBuffer()
+	f.resetBuffers()
 	time.Sleep(50 * time.Millisecond)
 }
 
@@ -716,8 +847,7 @@ func (f *ESP32Flasher) testConnection() error {
 	}
 
 	// Попытка 2: отправляем AT команду
-	f.port.ResetInputBuffer()
-	f.port.ResetOutputBuffer()
+	f.resetBuffers()
 	atCmd := []byte("AT\r\n")
 	f.port.Write(atCmd)
 	time.Sleep(100 * time.Millisecond)
@@ -747,14 +877,13 @@ func (f *ESP32Flasher) testConnection() error {
 }
 
 // testBootloaderMode проверяет, находится ли ESP32 в режиме bootloader
-func (f *ESP32Flasher) testBootloaderMode() bool {
+func (f *ESP32Flasher) testBootloaderMode(ctx context.Context) bool {
 	if f.callback != nil {
 		f.callback.emitLog("🔍 Проверка режима bootloader...")
 	}
 
 	// Очищаем буферы
-	f.port.ResetInputBuffer()
-	f.port.ResetOutputBuffer()
+	f.resetBuffers()
 	time.Sleep(100 * time.Millisecond)
 
 	// Ждем завершения вывода bootloader'а
@@ -767,6 +896,10 @@ func (f *ESP32Flasher) testBootloaderMode() bool {
 
 	// Читаем данные несколько раз, пока ESP32 выводит диагностику
 	for attempt := 0; attempt < 10; attempt++ {
+		if ctx.Err() != nil {
+			return false
+		}
+
 		f.port.SetReadTimeout(500 * time.Millisecond)
 		n, err := f.port.Read(buffer)
 
@@ -823,8 +956,7 @@ func (f *ESP32Flasher) testBootloaderMode() bool {
 	}
 
 	// Окончательно очищаем буферы перед SYNC
-	f.port.ResetInputBuffer()
-	f.port.ResetOutputBuffer()
+	f.resetBuffers()
 	time.Sleep(100 * time.Millisecond)
 
 	// Отправляем SYNC команду
@@ -842,7 +974,7 @@ func (f *ESP32Flasher) testBootloaderMode() bool {
 	}
 
 	// Читаем ответ с увеличенным таймаутом
-	response, err := f.readResponse(2 * time.Second)
+	response, err := f.readResponse(ctx, ESP_SYNC, 2*time.Second)
 	if err != nil {
 		if f.callback != nil {
 			f.callback.emitLog("⚠️ Нет ответа на SYNC команду")
@@ -894,8 +1026,7 @@ func (f *ESP32Flasher) espressifReferenceReset() {
 	if f.callback != nil {
 		f.callback.emitLog("  📋 Очистка буферов...")
 	}
-	f.port.ResetInputBuffer()
-	f.port.ResetOutputBuffer()
+	f.resetBuffers()
 
 	if f.callback != nil {
 		f.callback.emitLog("  📋 Шаг 1: set_control_line_state(true, false) -> GPIO0=LOW, EN=HIGH")
@@ -933,8 +1064,7 @@ func (f *ESP32Flasher) espressifReferenceReset() {
 	f.port.SetRTS(false) // EN = HIGH (остается не в сбросе)
 
 	// Ещё раз очищаем буферы после сброса
-	f.port.ResetInputBuffer()
-	f.port.ResetOutputBuffer()
+	f.resetBuffers()
 
 	// Дополнительная задержка для стабилизации ESP32 после reset sequence
 	time.Sleep(200 * time.Millisecond)
@@ -957,8 +1087,7 @@ func (f *ESP32Flasher) espressifReferenceResetInverted() {
 	if f.callback != nil {
 		f.callback.emitLog("  📋 Очистка буферов...")
 	}
-	f.port.ResetInputBuffer()
-	f.port.ResetOutputBuffer()
+	f.resetBuffers()
 
 	if f.callback != nil {
 		f.callback.emitLog("  📋 Шаг 1: set_control_line_state(false, true) -> GPIO0=LOW, EN=HIGH (инвертированная логика)")
@@ -996,8 +1125,7 @@ func (f *ESP32Flasher) espressifReferenceResetInverted() {
 	f.port.SetRTS(true) // EN = HIGH (остается не в сбросе) - инвертированная логика
 
 	// Ещё раз очищаем буферы после сброса
-	f.port.ResetInputBuffer()
-	f.port.ResetOutputBuffer()
+	f.resetBuffers()
 
 	// Дополнительная задержка для стабилизации ESP32 после reset sequence
 	time.Sleep(200 * time.Millisecond)
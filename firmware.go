@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Адрес по умолчанию для .bin файлов (application partition)
+const DEFAULT_APP_OFFSET = 0x10000
+
+// Типы записей Intel HEX
+const (
+	IHEX_DATA                  = 0x00
+	IHEX_EOF                   = 0x01
+	IHEX_EXT_SEGMENT_ADDRESS   = 0x02
+	IHEX_START_SEGMENT_ADDRESS = 0x03
+	IHEX_EXT_LINEAR_ADDRESS    = 0x04
+	IHEX_START_LINEAR_ADDRESS  = 0x05
+)
+
+// Константы формата UF2
+// https://github.com/microsoft/uf2
+const (
+	UF2_BLOCK_SIZE   = 512
+	UF2_MAGIC_START0 = 0x0A324655
+	UF2_MAGIC_START1 = 0x9E5D5157
+	UF2_MAGIC_END    = 0x0AB16F30
+
+	UF2_FLAG_NOT_MAIN_FLASH = 0x00000001
+	UF2_FLAG_FILE_CONTAINER = 0x00001000
+	UF2_FLAG_FAMILY_ID      = 0x00002000
+)
+
+// uf2FamilyIDs - идентификаторы семейств Espressif из uf2families.json
+var uf2FamilyIDs = map[uint32]string{
+	0x1c5f21b0: "ESP32",
+	0xbfdd4eee: "ESP32-S2",
+	0xd42ba06c: "ESP32-C3",
+	0xc47e5767: "ESP32-S3",
+	0x540ddf62: "ESP32-C6",
+	0x332726f6: "ESP32-H2",
+	0x2b88d29c: "ESP32-C2",
+	0x3d308e94: "ESP32-P4",
+	0x7eab61ed: "ESP8266",
+}
+
+// FirmwareSegment - непрерывный участок прошивки и адрес его записи во flash
+type FirmwareSegment struct {
+	Offset uint32
+	Data   []byte
+}
+
+// LoadFirmware загружает файл прошивки и разбивает его на сегменты в зависимости от формата.
+// family - семейство чипа из UF2 (например "ESP32-S3"), пусто для остальных форматов
+func LoadFirmware(filePath string) (segments []FirmwareSegment, family string, err error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".hex", ".ihex":
+		segments, err = parseIntelHex(data)
+		return segments, "", err
+	case ".uf2":
+		return parseUF2(data)
+	default:
+		if len(data) == 0 {
+			return nil, "", fmt.Errorf("firmware file is empty")
+		}
+		return []FirmwareSegment{{Offset: DEFAULT_APP_OFFSET, Data: data}}, "", nil
+	}
+}
+
+// parseIntelHex разбирает Intel HEX и возвращает отсортированные непрерывные сегменты
+func parseIntelHex(data []byte) ([]FirmwareSegment, error) {
+	chunks := make(map[uint32][]byte)
+	var base uint32
+	sawEOF := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if sawEOF {
+			return nil, fmt.Errorf("hex line %d: data after EOF record", lineNum)
+		}
+		if line[0] != ':' {
+			return nil, fmt.Errorf("hex line %d: missing start code", lineNum)
+		}
+
+		record, err := hex.DecodeString(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("hex line %d: %w", lineNum, err)
+		}
+		if len(record) < 5 || len(record) != int(record[0])+5 {
+			return nil, fmt.Errorf("hex line %d: invalid record length", lineNum)
+		}
+
+		// Сумма всех байт записи вместе с контрольной должна быть равна 0
+		var sum byte
+		for _, b := range record {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("hex line %d: checksum mismatch", lineNum)
+		}
+
+		length := int(record[0])
+		address := uint32(binary.BigEndian.Uint16(record[1:3]))
+		payload := record[4 : 4+length]
+
+		switch record[3] {
+		case IHEX_DATA:
+			if length == 0 {
+				break
+			}
+			if _, exists := chunks[base+address]; exists {
+				return nil, fmt.Errorf("hex line %d: duplicate address 0x%x", lineNum, base+address)
+			}
+			chunks[base+address] = append([]byte(nil), payload...)
+		case IHEX_EOF:
+			sawEOF = true
+		case IHEX_EXT_SEGMENT_ADDRESS:
+			if length != 2 {
+				return nil, fmt.Errorf("hex line %d: invalid extended segment address record", lineNum)
+			}
+			base = uint32(binary.BigEndian.Uint16(payload)) << 4
+		case IHEX_EXT_LINEAR_ADDRESS:
+			if length != 2 {
+				return nil, fmt.Errorf("hex line %d: invalid extended linear address record", lineNum)
+			}
+			base = uint32(binary.BigEndian.Uint16(payload)) << 16
+		case IHEX_START_SEGMENT_ADDRESS, IHEX_START_LINEAR_ADDRESS:
+			// Точка входа не нужна для записи во flash
+		default:
+			return nil, fmt.Errorf("hex line %d: unknown record type 0x%02x", lineNum, record[3])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read hex file: %w", err)
+	}
+	if !sawEOF {
+		return nil, fmt.Errorf("hex file has no EOF record")
+	}
+
+	return mergeChunks(chunks)
+}
+
+// parseUF2 разбирает UF2 и возвращает сегменты и имя семейства чипа (если указано)
+func parseUF2(data []byte) ([]FirmwareSegment, string, error) {
+	if len(data) == 0 || len(data)%UF2_BLOCK_SIZE != 0 {
+		return nil, "", fmt.Errorf("invalid UF2 file size: %d bytes", len(data))
+	}
+
+	chunks := make(map[uint32][]byte)
+	family := ""
+
+	for i := 0; i < len(data); i += UF2_BLOCK_SIZE {
+		block := data[i : i+UF2_BLOCK_SIZE]
+		blockNum := i / UF2_BLOCK_SIZE
+
+		if binary.LittleEndian.Uint32(block[0:4]) != UF2_MAGIC_START0 ||
+			binary.LittleEndian.Uint32(block[4:8]) != UF2_MAGIC_START1 ||
+			binary.LittleEndian.Uint32(block[508:512]) != UF2_MAGIC_END {
+			return nil, "", fmt.Errorf("UF2 block %d: bad magic", blockNum)
+		}
+
+		flags := binary.LittleEndian.Uint32(block[8:12])
+		targetAddr := binary.LittleEndian.Uint32(block[12:16])
+		payloadSize := binary.LittleEndian.Uint32(block[16:20])
+		familyID := binary.LittleEndian.Uint32(block[28:32])
+
+		// Блоки не для основной flash и контейнеры файлов пропускаем
+		if flags&(UF2_FLAG_NOT_MAIN_FLASH|UF2_FLAG_FILE_CONTAINER) != 0 {
+			continue
+		}
+
+		if flags&UF2_FLAG_FAMILY_ID != 0 {
+			name, ok := uf2FamilyIDs[familyID]
+			if !ok {
+				return nil, "", fmt.Errorf("UF2 block %d: unsupported family ID 0x%08x", blockNum, familyID)
+			}
+			if family != "" && family != name {
+				return nil, "", fmt.Errorf("UF2 file mixes %s and %s blocks", family, name)
+			}
+			family = name
+		}
+
+		if payloadSize > 476 {
+			return nil, "", fmt.Errorf("UF2 block %d: invalid payload size %d", blockNum, payloadSize)
+		}
+
+		if _, exists := chunks[targetAddr]; exists {
+			return nil, "", fmt.Errorf("UF2 block %d: duplicate address 0x%x", blockNum, targetAddr)
+		}
+		chunks[targetAddr] = append([]byte(nil), block[32:32+payloadSize]...)
+	}
+
+	segments, err := mergeChunks(chunks)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return segments, family, nil
+}
+
+// mergeChunks склеивает участки, идущие подряд, в сегменты, проверяет отсутствие
+// перекрытий и выравнивает сегменты по секторам flash
+func mergeChunks(chunks map[uint32][]byte) ([]FirmwareSegment, error) {
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("firmware contains no data")
+	}
+
+	addresses := make([]uint32, 0, len(chunks))
+	for addr := range chunks {
+		addresses = append(addresses, addr)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+	var segments []FirmwareSegment
+	for _, addr := range addresses {
+		chunk := chunks[addr]
+		if n := len(segments); n > 0 {
+			last := &segments[n-1]
+			end := last.Offset + uint32(len(last.Data))
+			if addr < end {
+				return nil, fmt.Errorf("overlapping data at address 0x%x", addr)
+			}
+			if addr == end {
+				last.Data = append(last.Data, chunk...)
+				continue
+			}
+		}
+		segments = append(segments, FirmwareSegment{Offset: addr, Data: append([]byte(nil), chunk...)})
+	}
+
+	return alignSegments(segments), nil
+}
+
+// alignSegments выравнивает начала сегментов вниз по границе сектора flash и
+// склеивает сегменты, попадающие в один сектор. FLASH_BEGIN стирает целые
+// секторы, поэтому два сегмента в одном секторе стерли бы данные друг друга.
+// Промежутки заполняются 0xFF - так выглядит стертая flash
+func alignSegments(segments []FirmwareSegment) []FirmwareSegment {
+	var aligned []FirmwareSegment
+	for _, segment := range segments {
+		start := segment.Offset &^ (ESP_FLASH_SECTOR - 1)
+
+		if n := len(aligned); n > 0 {
+			last := &aligned[n-1]
+			end := last.Offset + uint32(len(last.Data))
+			lastSectorEnd := (end + ESP_FLASH_SECTOR - 1) &^ (ESP_FLASH_SECTOR - 1)
+			if start < lastSectorEnd {
+				last.Data = append(last.Data, bytes.Repeat([]byte{0xff}, int(segment.Offset-end))...)
+				last.Data = append(last.Data, segment.Data...)
+				continue
+			}
+		}
+
+		data := bytes.Repeat([]byte{0xff}, int(segment.Offset-start))
+		aligned = append(aligned, FirmwareSegment{Offset: start, Data: append(data, segment.Data...)})
+	}
+	return aligned
+}
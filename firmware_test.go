@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// hexRecord собирает строку Intel HEX с правильной контрольной суммой
+func hexRecord(recordType byte, address uint16, payload ...byte) string {
+	record := []byte{byte(len(payload)), byte(address >> 8), byte(address), recordType}
+	record = append(record, payload...)
+
+	var sum byte
+	for _, b := range record {
+		sum += b
+	}
+	return fmt.Sprintf(":%X%02X", record, -sum)
+}
+
+// uf2Block собирает блок UF2 с данными payload по адресу target
+func uf2Block(flags, target, familyID uint32, payload []byte) []byte {
+	block := make([]byte, UF2_BLOCK_SIZE)
+	binary.LittleEndian.PutUint32(block[0:4], UF2_MAGIC_START0)
+	binary.LittleEndian.PutUint32(block[4:8], UF2_MAGIC_START1)
+	binary.LittleEndian.PutUint32(block[8:12], flags)
+	binary.LittleEndian.PutUint32(block[12:16], target)
+	binary.LittleEndian.PutUint32(block[16:20], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(block[28:32], familyID)
+	copy(block[32:], payload)
+	binary.LittleEndian.PutUint32(block[508:512], UF2_MAGIC_END)
+	return block
+}
+
+// sectorImage возвращает сектор flash, начинающийся с 0xFF-заполнения до offset
+func sectorImage(offset int, data ...byte) []byte {
+	return append(bytes.Repeat([]byte{0xff}, offset), data...)
+}
+
+func TestParseIntelHex(t *testing.T) {
+	eof := hexRecord(IHEX_EOF, 0)
+
+	tests := []struct {
+		name    string
+		lines   []string
+		want    []FirmwareSegment
+		wantErr string
+	}{
+		{
+			name: "extended linear address",
+			lines: []string{
+				hexRecord(IHEX_EXT_LINEAR_ADDRESS, 0, 0x00, 0x01),
+				hexRecord(IHEX_DATA, 0x0000, 0x01, 0x02),
+				hexRecord(IHEX_DATA, 0x0002, 0x03),
+				hexRecord(IHEX_START_LINEAR_ADDRESS, 0, 0x40, 0x08, 0x00, 0x00),
+				eof,
+			},
+			want: []FirmwareSegment{{Offset: 0x10000, Data: []byte{0x01, 0x02, 0x03}}},
+		},
+		{
+			name: "extended segment address",
+			lines: []string{
+				hexRecord(IHEX_EXT_SEGMENT_ADDRESS, 0, 0x10, 0x00),
+				hexRecord(IHEX_DATA, 0x0010, 0xaa),
+				eof,
+			},
+			want: []FirmwareSegment{{Offset: 0x10000, Data: sectorImage(0x10, 0xaa)}},
+		},
+		{
+			name: "segments in one sector are merged",
+			lines: []string{
+				hexRecord(IHEX_EXT_LINEAR_ADDRESS, 0, 0x00, 0x01),
+				hexRecord(IHEX_DATA, 0x0000, 0x01),
+				hexRecord(IHEX_DATA, 0x0004, 0x02),
+				hexRecord(IHEX_DATA, 0x2000, 0x03),
+				eof,
+			},
+			want: []FirmwareSegment{
+				{Offset: 0x10000, Data: []byte{0x01, 0xff, 0xff, 0xff, 0x02}},
+				{Offset: 0x12000, Data: []byte{0x03}},
+			},
+		},
+		{
+			name:    "checksum mismatch",
+			lines:   []string{":0100000001FF", eof},
+			wantErr: "hex line 1: checksum mismatch",
+		},
+		{
+			name:    "missing start code",
+			lines:   []string{"0100000001FE", eof},
+			wantErr: "hex line 1: missing start code",
+		},
+		{
+			name:    "invalid record length",
+			lines:   []string{":0200000001FD", eof},
+			wantErr: "hex line 1: invalid record length",
+		},
+		{
+			name:    "no EOF record",
+			lines:   []string{hexRecord(IHEX_DATA, 0, 0x01)},
+			wantErr: "hex file has no EOF record",
+		},
+		{
+			name:    "data after EOF",
+			lines:   []string{eof, hexRecord(IHEX_DATA, 0, 0x01)},
+			wantErr: "hex line 2: data after EOF record",
+		},
+		{
+			name:    "duplicate address",
+			lines:   []string{hexRecord(IHEX_DATA, 0, 0x01), hexRecord(IHEX_DATA, 0, 0x02), eof},
+			wantErr: "hex line 2: duplicate address 0x0",
+		},
+		{
+			name:    "overlapping data",
+			lines:   []string{hexRecord(IHEX_DATA, 0, 0x01, 0x02), hexRecord(IHEX_DATA, 1, 0x03), eof},
+			wantErr: "overlapping data at address 0x1",
+		},
+		{
+			name:    "unknown record type",
+			lines:   []string{hexRecord(0x07, 0), eof},
+			wantErr: "hex line 1: unknown record type 0x07",
+		},
+		{
+			name:    "no data",
+			lines:   []string{eof},
+			wantErr: "firmware contains no data",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments, err := parseIntelHex([]byte(strings.Join(tt.lines, "\r\n")))
+			checkSegments(t, segments, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestParseUF2(t *testing.T) {
+	esp32s3 := uint32(0xc47e5767)
+	esp32 := uint32(0x1c5f21b0)
+	payload := bytes.Repeat([]byte{0x5a}, 256)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		want       []FirmwareSegment
+		wantFamily string
+		wantErr    string
+	}{
+		{
+			name: "family and consecutive blocks",
+			data: concat(
+				uf2Block(UF2_FLAG_FAMILY_ID, 0x10000, esp32s3, payload),
+				uf2Block(UF2_FLAG_FAMILY_ID, 0x10100, esp32s3, payload),
+			),
+			want:       []FirmwareSegment{{Offset: 0x10000, Data: bytes.Repeat([]byte{0x5a}, 512)}},
+			wantFamily: "ESP32-S3",
+		},
+		{
+			name: "without family",
+			data: uf2Block(0, 0x20000, 0, []byte{0x01}),
+			want: []FirmwareSegment{{Offset: 0x20000, Data: []byte{0x01}}},
+		},
+		{
+			name: "not main flash blocks are skipped",
+			data: concat(
+				uf2Block(0, 0x10000, 0, []byte{0x01}),
+				uf2Block(UF2_FLAG_NOT_MAIN_FLASH, 0x0, 0, []byte{0x02}),
+			),
+			want: []FirmwareSegment{{Offset: 0x10000, Data: []byte{0x01}}},
+		},
+		{
+			name:    "mixed families",
+			data:    concat(uf2Block(UF2_FLAG_FAMILY_ID, 0x10000, esp32s3, payload), uf2Block(UF2_FLAG_FAMILY_ID, 0x10100, esp32, payload)),
+			wantErr: "UF2 file mixes ESP32-S3 and ESP32 blocks",
+		},
+		{
+			name:    "unsupported family",
+			data:    uf2Block(UF2_FLAG_FAMILY_ID, 0x10000, 0xe48bff56, payload),
+			wantErr: "UF2 block 0: unsupported family ID 0xe48bff56",
+		},
+		{
+			name:    "bad magic",
+			data:    make([]byte, UF2_BLOCK_SIZE),
+			wantErr: "UF2 block 0: bad magic",
+		},
+		{
+			name:    "invalid size",
+			data:    make([]byte, UF2_BLOCK_SIZE+1),
+			wantErr: "invalid UF2 file size: 513 bytes",
+		},
+		{
+			name:    "invalid payload size",
+			data:    uf2Block(0, 0x10000, 0, make([]byte, 477)),
+			wantErr: "UF2 block 0: invalid payload size 477",
+		},
+		{
+			name:    "duplicate address",
+			data:    concat(uf2Block(0, 0x10000, 0, payload), uf2Block(0, 0x10000, 0, payload)),
+			wantErr: "UF2 block 1: duplicate address 0x10000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments, family, err := parseUF2(tt.data)
+			checkSegments(t, segments, err, tt.want, tt.wantErr)
+			if family != tt.wantFamily {
+				t.Errorf("family = %q, want %q", family, tt.wantFamily)
+			}
+		})
+	}
+}
+
+func TestAlignSegments(t *testing.T) {
+	tests := []struct {
+		name     string
+		segments []FirmwareSegment
+		want     []FirmwareSegment
+	}{
+		{
+			name:     "aligned segment is unchanged",
+			segments: []FirmwareSegment{{Offset: 0x1000, Data: []byte{0x01}}},
+			want:     []FirmwareSegment{{Offset: 0x1000, Data: []byte{0x01}}},
+		},
+		{
+			name:     "start is aligned down",
+			segments: []FirmwareSegment{{Offset: 0x1003, Data: []byte{0x01}}},
+			want:     []FirmwareSegment{{Offset: 0x1000, Data: []byte{0xff, 0xff, 0xff, 0x01}}},
+		},
+		{
+			name: "segment ending in the next one's sector",
+			segments: []FirmwareSegment{
+				{Offset: 0x0ffe, Data: []byte{0x01, 0x02, 0x03}},
+				{Offset: 0x1002, Data: []byte{0x04}},
+			},
+			want: []FirmwareSegment{{Offset: 0x0000, Data: append(sectorImage(0x0ffe, 0x01, 0x02, 0x03), 0xff, 0x04)}},
+		},
+		{
+			name: "segments in different sectors stay apart",
+			segments: []FirmwareSegment{
+				{Offset: 0x1000, Data: []byte{0x01}},
+				{Offset: 0x2000, Data: []byte{0x02}},
+			},
+			want: []FirmwareSegment{
+				{Offset: 0x1000, Data: []byte{0x01}},
+				{Offset: 0x2000, Data: []byte{0x02}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkSegments(t, alignSegments(tt.segments), nil, tt.want, "")
+		})
+	}
+}
+
+func checkSegments(t *testing.T, got []FirmwareSegment, err error, want []FirmwareSegment, wantErr string) {
+	t.Helper()
+
+	if wantErr != "" {
+		if err == nil || err.Error() != wantErr {
+			t.Fatalf("error = %v, want %q", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Offset != want[i].Offset || !bytes.Equal(got[i].Data, want[i].Data) {
+			t.Errorf("segment %d = 0x%x (%d bytes), want 0x%x (%d bytes)",
+				i, got[i].Offset, len(got[i].Data), want[i].Offset, len(want[i].Data))
+		}
+	}
+}
+
+func concat(blocks ...[]byte) []byte {
+	return bytes.Join(blocks, nil)
+}
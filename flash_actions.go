@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Действия перед прошивкой (как --before в esptool)
+const (
+	BEFORE_DEFAULT_RESET = ""                 // сброс выбранной стратегией (FlasherConfig.Reset)
+	BEFORE_NO_RESET      = "no-reset"         // не трогать DTR/RTS, но проверить, что чип в загрузчике
+	BEFORE_IN_BOOTLOADER = "no-reset-no-sync" // чип уже в загрузчике: без сброса и без проверки
+)
+
+// Действия после прошивки (как --after в esptool)
+const (
+	AFTER_HARD_RESET = ""           // сброс через EN (RTS)
+	AFTER_SOFT_RESET = "soft-reset" // перезапуск командами загрузчика, без линий модема
+	AFTER_NO_RESET   = "no-reset"   // остаться в загрузчике
+	AFTER_RUN        = "run"        // FLASH_END с флагом запуска пользовательского кода
+
+	// Сколько держать EN в нуле при аппаратном сбросе
+	HARD_RESET_HOLD_TIME = 100 * time.Millisecond
+	// Встроенному USB нужно больше времени: он отключается от хоста при сбросе
+	HARD_RESET_USB_HOLD_TIME = 200 * time.Millisecond
+)
+
+// ValidateFlashActions проверяет действия до и после прошивки
+func ValidateFlashActions(before, after string) error {
+	switch before {
+	case BEFORE_DEFAULT_RESET, BEFORE_NO_RESET, BEFORE_IN_BOOTLOADER:
+	default:
+		return fmt.Errorf("unknown before-flash action %q", before)
+	}
+
+	switch after {
+	case AFTER_HARD_RESET, AFTER_SOFT_RESET, AFTER_NO_RESET, AFTER_RUN:
+	default:
+		return fmt.Errorf("unknown after-flash action %q", after)
+	}
+
+	return nil
+}
+
+// prepareBootloader выполняет действие перед прошивкой
+func (f *ESP32Flasher) prepareBootloader(ctx context.Context, before string) error {
+	switch before {
+	case BEFORE_NO_RESET:
+		return f.enterBootloaderWithStrategy(ctx, RESET_NONE)
+
+	case BEFORE_IN_BOOTLOADER:
+		if f.callback != nil {
+			f.callback.emitLog("⏭️ ESP32 уже в режиме загрузки, сброс и проверка пропущены")
+		}
+		f.resetBuffers()
+		return nil
+
+	default:
+		return f.enterBootloader(ctx)
+	}
+}
+
+// finishFlash завершает сессию записи и выполняет действие после прошивки.
+// FLASH_END выводит чип из загрузчика (0 - перезагрузка, 1 - запуск
+// пользовательского кода), поэтому отправляется только для AFTER_RUN. Чтобы
+// остаться в загрузчике, FLASH_END не отправляется вовсе, как в esptool
+func (f *ESP32Flasher) finishFlash(ctx context.Context) error {
+	switch f.afterAction {
+	case AFTER_RUN:
+		return f.flashEnd(ctx, true)
+
+	case AFTER_NO_RESET:
+		if f.callback != nil {
+			f.callback.emitLog("⏸️ ESP32 оставлен в режиме загрузки")
+		}
+		return nil
+
+	case AFTER_SOFT_RESET:
+		return f.SoftReset(ctx)
+
+	default:
+		return f.HardReset()
+	}
+}
+
+// HardReset перезапускает ESP32 в приложение через линию EN (RTS), GPIO0 при этом отпущен
+// Полярность линий известна только для классической, инвертированной и USB-JTAG схем:
+// при ручной стратегии и без сброса линии не подключены к EN, а по пользовательской
+// последовательности нельзя понять, какой уровень RTS держит EN. В этих случаях
+// сброс пропускается
+func (f *ESP32Flasher) HardReset() error {
+	switch f.resetStrategy {
+	case RESET_AUTO, RESET_CLASSIC, RESET_INVERTED, RESET_USB_JTAG:
+	default:
+		if f.callback != nil {
+			f.callback.emitLog(fmt.Sprintf("⏭️ Стратегия сброса %s: аппаратный сброс через RTS пропущен", f.resetStrategy))
+			f.callback.emitLog("💡 Нажмите RESET на плате или выберите запуск командой загрузчика (run/soft-reset)")
+		}
+		return nil
+	}
+
+	if f.callback != nil {
+		f.callback.emitLog("🔁 Аппаратный сброс через RTS, запуск приложения...")
+	}
+
+	// При инвертированной схеме активный уровень линий противоположный
+	inverted := f.invertedReset || f.resetStrategy == RESET_INVERTED
+	hold := HARD_RESET_HOLD_TIME
+	if f.resetStrategy == RESET_USB_JTAG {
+		hold = HARD_RESET_USB_HOLD_TIME
+	}
+
+	steps := []struct {
+		set   func(bool) error
+		level bool
+	}{
+		{f.port.SetDTR, inverted},  // GPIO0 = HIGH
+		{f.port.SetRTS, !inverted}, // EN = LOW
+	}
+	for _, step := range steps {
+		if err := step.set(step.level); err != nil {
+			return fmt.Errorf("hard reset failed: %w", err)
+		}
+	}
+
+	time.Sleep(hold)
+
+	if err := f.port.SetRTS(inverted); err != nil { // EN = HIGH
+		return fmt.Errorf("hard reset failed: %w", err)
+	}
+
+	time.Sleep(hold)
+	return nil
+}
+
+// SoftReset перезапускает ESP32 командами загрузчика. Stub загрузчик не используется,
+// поэтому, как esptool для ROM, открываем пустую сессию записи и завершаем ее с запуском
+func (f *ESP32Flasher) SoftReset(ctx context.Context) error {
+	if f.callback != nil {
+		f.callback.emitLog("🔁 Программный сброс, запуск приложения...")
+	}
+
+	if err := f.flashBegin(ctx, 0, 0); err != nil {
+		return fmt.Errorf("soft reset failed: %w", err)
+	}
+	return f.flashEnd(ctx, true)
+}
+
+// flashEnd завершает сессию записи и выводит чип из загрузчика: run=true -
+// ROM загрузчик сразу запускает пользовательский код, иначе перезагружает чип
+func (f *ESP32Flasher) flashEnd(ctx context.Context, run bool) error {
+	data := make([]byte, 4)
+	if run {
+		binary.LittleEndian.PutUint32(data, 1)
+	}
+
+	if err := f.sendCommand(ESP_FLASH_END, data, 0); err != nil {
+		return err
+	}
+
+	response, err := f.readResponse(ctx, ESP_FLASH_END, 3*time.Second)
+	if err != nil {
+		return err
+	}
+
+	return checkResponse(ESP_FLASH_END, response)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Таймаут расчета MD5 на стороне ROM (esptool использует 8 секунд на мегабайт)
+const MD5_TIMEOUT_PER_MB = 8 * time.Second
+
+// diffStats - статистика сравнения образа с содержимым flash
+type diffStats struct {
+	totalRegions   int
+	changedRegions int
+	totalBytes     int
+	skippedBytes   int
+}
+
+// EnableDiffMode включает дифференциальную запись: регионы по 64 КБ,
+// совпадающие с содержимым flash, пропускаются
+func (f *ESP32Flasher) EnableDiffMode(enabled bool) {
+	f.diffMode = enabled
+}
+
+// flashMD5 запрашивает у загрузчика MD5 участка flash
+func (f *ESP32Flasher) flashMD5(ctx context.Context, offset, size uint32) ([]byte, error) {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint32(data[0:4], offset) // Адрес
+	binary.LittleEndian.PutUint32(data[4:8], size)   // Размер
+	binary.LittleEndian.PutUint32(data[8:12], 0)     // Reserved
+	binary.LittleEndian.PutUint32(data[12:16], 0)    // Reserved
+
+	if err := f.sendCommand(ESP_SPI_MD5, data, 0); err != nil {
+		return nil, fmt.Errorf("failed to send MD5 command: %w", err)
+	}
+
+	timeout := time.Duration(float64(MD5_TIMEOUT_PER_MB) * float64(size) / (1024 * 1024))
+	if timeout < 3*time.Second {
+		timeout = 3 * time.Second
+	}
+
+	response, err := f.readResponse(ctx, ESP_SPI_MD5, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("timeout waiting for MD5 response: %w", err)
+	}
+
+	if err := checkResponse(ESP_SPI_MD5, response); err != nil {
+		return nil, err
+	}
+
+	body := response[8:]
+
+	// ROM loader возвращает 32 hex-символа + 4 байта статуса,
+	// stub loader - 16 байт дайджеста + 2 байта статуса
+	switch {
+	case len(body) >= 36:
+		if body[32] != 0x00 {
+			return nil, &ROMError{Command: ESP_SPI_MD5, Status: body[32], Code: body[33]}
+		}
+		digest, err := hex.DecodeString(string(body[:32]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid MD5 digest: %w", err)
+		}
+		return digest, nil
+	case len(body) >= 18:
+		if body[16] != 0x00 {
+			return nil, &ROMError{Command: ESP_SPI_MD5, Status: body[16], Code: body[17]}
+		}
+		return append([]byte(nil), body[:16]...), nil
+	default:
+		return nil, fmt.Errorf("MD5 response too short: %d bytes", len(body))
+	}
+}
+
+// planDiffWrites сравнивает каждый регион образа с flash и возвращает только
+// изменившиеся участки (соседние изменившиеся регионы объединяются)
+func (f *ESP32Flasher) planDiffWrites(ctx context.Context, segments []FirmwareSegment) ([]FirmwareSegment, diffStats, error) {
+	var stats diffStats
+	var jobs []FirmwareSegment
+
+	for _, segment := range segments {
+		stats.totalRegions += (len(segment.Data) + ESP_FLASH_BLOCK - 1) / ESP_FLASH_BLOCK
+	}
+
+	checked := 0
+	for _, segment := range segments {
+		for i := 0; i < len(segment.Data); i += ESP_FLASH_BLOCK {
+			end := i + ESP_FLASH_BLOCK
+			if end > len(segment.Data) {
+				end = len(segment.Data)
+			}
+			region := segment.Data[i:end]
+			offset := segment.Offset + uint32(i)
+
+			remote, err := f.flashMD5(ctx, offset, uint32(len(region)))
+			if err != nil {
+				return nil, stats, fmt.Errorf("region 0x%x: %w", offset, err)
+			}
+			local := md5.Sum(region)
+
+			checked++
+			stats.totalBytes += len(region)
+
+			if bytes.Equal(remote, local[:]) {
+				stats.skippedBytes += len(region)
+			} else {
+				stats.changedRegions++
+				if n := len(jobs); n > 0 && jobs[n-1].Offset+uint32(len(jobs[n-1].Data)) == offset {
+					jobs[n-1].Data = append(jobs[n-1].Data, region...)
+				} else {
+					jobs = append(jobs, FirmwareSegment{Offset: offset, Data: append([]byte(nil), region...)})
+				}
+			}
+
+			if f.callback != nil {
+				progress := 45 + checked*5/stats.totalRegions // 45-50%
+				f.callback.emitProgress(progress, fmt.Sprintf("Сравнение %d/%d регионов (пропущено %d КБ)",
+					checked, stats.totalRegions, stats.skippedBytes/1024))
+			}
+		}
+	}
+
+	return jobs, stats, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Параметры восстановления прерванной прошивки
+const (
+	RESUME_MAX_ATTEMPTS = 3                // сколько раз переподключаться за один участок
+	RECONNECT_TIMEOUT   = 30 * time.Second // сколько ждать повторного появления порта
+	RECONNECT_POLL      = 500 * time.Millisecond
+)
+
+// errPortIO - ошибка ввода-вывода порта (кабель отключен, устройство пропало)
+var errPortIO = errors.New("serial port I/O error")
+
+// writeJob стирает и записывает участок. Если во время записи порт пропадает,
+// флешер переподключается, проверяет уже записанные блоки и продолжает
+// с первого несовпадающего блока вместо полной перезаписи
+func (f *ESP32Flasher) writeJob(ctx context.Context, job FirmwareSegment, blockSize, done, totalBlocks int) error {
+	jobBlocks := (len(job.Data) + blockSize - 1) / blockSize
+	start := 0 // первый блок участка, который еще нужно записать
+
+	for attempt := 0; ; attempt++ {
+		remaining := job.Data[start*blockSize:]
+		offset := job.Offset + uint32(start*blockSize)
+
+		sent := 0
+		err := f.flashBegin(ctx, uint32(len(remaining)), offset)
+		if err != nil {
+			err = fmt.Errorf("flash begin failed at 0x%x: %w", offset, err)
+		} else {
+			sent, err = f.writeBlocks(ctx, remaining, blockSize, done+start, totalBlocks)
+			if err == nil {
+				return nil
+			}
+		}
+
+		if ctx.Err() != nil || attempt >= RESUME_MAX_ATTEMPTS || !f.isPortLost(err) {
+			return err
+		}
+
+		if f.callback != nil {
+			f.callback.emitLog(fmt.Sprintf("🔌 Потеряна связь с портом на блоке %d/%d: %v", done+start+sent+1, totalBlocks, err))
+			f.callback.emitProgress(60+(done+start+sent)*30/totalBlocks, "Переподключение...")
+		}
+
+		if rerr := f.reconnect(ctx); rerr != nil {
+			return fmt.Errorf("reconnect failed: %v (original error: %w)", rerr, err)
+		}
+
+		// Блок, отправленный в момент обрыва, мог быть записан без подтверждения
+		upto := start + sent + 1
+		if upto > jobBlocks {
+			upto = jobBlocks
+		}
+
+		good, verr := f.verifyWrittenBlocks(ctx, job, blockSize, upto)
+		if verr != nil {
+			return fmt.Errorf("failed to verify written blocks: %w", verr)
+		}
+
+		if f.callback != nil {
+			f.callback.emitLog(fmt.Sprintf("♻️ Проверено %d блоков, продолжаем с блока %d/%d", good, done+good+1, totalBlocks))
+		}
+
+		if good >= jobBlocks {
+			return nil
+		}
+		start = good
+	}
+}
+
+// isPortLost определяет, вызвана ли ошибка потерей соединения с портом
+func (f *ESP32Flasher) isPortLost(err error) bool {
+	if errors.Is(err, errPortIO) {
+		return true
+	}
+
+	// Исчезновение из списка портов имеет смысл проверять только для локального порта
+	if !isLocalPort(f.portName) {
+		return false
+	}
+
+	// Если порт исчез из системы, таймауты тоже означают обрыв
+	ports, listErr := serial.GetPortsList()
+	if listErr != nil {
+		return false
+	}
+	for _, name := range ports {
+		if name == f.portName {
+			return false
+		}
+	}
+	return true
+}
+
+// reconnect ждет повторного появления порта, заново открывает его,
+// переводит ESP32 в режим загрузчика и восстанавливает сессию
+func (f *ESP32Flasher) reconnect(ctx context.Context) error {
+	if f.portName == "" && f.reopen == nil {
+		return fmt.Errorf("transport cannot be reopened")
+	}
+
+	if f.callback != nil {
+		f.callback.emitLog(fmt.Sprintf("🔄 Ожидание порта %s (до %v)...", f.portName, RECONNECT_TIMEOUT))
+	}
+
+	f.port.Close()
+
+	if err := f.reopenPort(ctx, f.portName, RECONNECT_TIMEOUT); err != nil {
+		return err
+	}
+
+	if f.callback != nil {
+		f.callback.emitLog("🔗 Порт снова доступен, повторный вход в bootloader...")
+	}
+
+	// Вход в загрузчик тем же способом, что и при первом подключении: без сброса
+	// линии DTR/RTS не трогаются, ручная стратегия снова ждет кнопок
+	if err := f.prepareBootloader(ctx, f.beforeAction); err != nil {
+		return err
+	}
+	if err := f.sync(ctx); err != nil {
+		return fmt.Errorf("sync failed: %w", err)
+	}
+	if err := f.spiAttach(ctx); err != nil {
+		return fmt.Errorf("SPI attach failed: %w", err)
+	}
+
+	// После сброса ROM снова считает flash 4 МБ; без этого запись выше 4 МБ не пройдет
+	if f.flashInfo != nil && f.flashInfo.Size != 0 {
+		if err := f.spiSetParams(ctx, f.flashInfo.Size); err != nil {
+			return fmt.Errorf("SPI set params failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// reopenPort открывает порт name, повторяя попытки до timeout. Старый канал
+// должен быть уже закрыт; запись трассы продолжается в новом канале
+func (f *ESP32Flasher) reopenPort(ctx context.Context, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		port, err := f.openPort(name)
+		if err == nil {
+			if f.trace != nil {
+				port = f.trace.Wrap(port)
+			}
+			f.port = port
+			f.portName = name
+			f.slip.reset()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("port %s did not come back: %w", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(RECONNECT_POLL):
+		}
+	}
+}
+
+// openPort открывает порт через аренду менеджера портов, если она есть
+func (f *ESP32Flasher) openPort(name string) (Transport, error) {
+	if f.lease != nil {
+		return f.lease.Reopen(name)
+	}
+	if f.reopen != nil {
+		return f.reopen(name)
+	}
+	return OpenTransport(name)
+}
+
+// verifyWrittenBlocks находит количество блоков в начале участка (не более upto),
+// содержимое которых во flash совпадает с образом. Используется бинарный поиск
+// по MD5 префикса, так как совпадение префикса монотонно по длине
+func (f *ESP32Flasher) verifyWrittenBlocks(ctx context.Context, job FirmwareSegment, blockSize, upto int) (int, error) {
+	prefixMatches := func(blocks int) (bool, error) {
+		size := blocks * blockSize
+		if size > len(job.Data) {
+			size = len(job.Data)
+		}
+		remote, err := f.flashMD5(ctx, job.Offset, uint32(size))
+		if err != nil {
+			return false, err
+		}
+		local := md5.Sum(job.Data[:size])
+		return bytes.Equal(remote, local[:]), nil
+	}
+
+	if upto == 0 {
+		return 0, nil
+	}
+
+	// Обычно все отправленные блоки записаны - проверяем это одним запросом
+	ok, err := prefixMatches(upto)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return upto, nil
+	}
+
+	lo, hi := 0, upto // prefix(lo) совпадает, prefix(hi) - нет
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		ok, err := prefixMatches(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo, nil
+}
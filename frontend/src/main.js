@@ -1,28 +1,94 @@
 import {
   ListPorts,
+  SetAutoSelectPort,
   Flash,
+  CancelFlash,
+  ChipInfo,
+  ReadCoreDump,
+  ChooseTraceFile,
+  ReplayTrace,
   ChooseFile,
   MonitorPort,
   StopMonitor,
+  ChooseELFFile,
+  SetMonitorELF,
+  MonitorSend,
+  MonitorSendFile,
+  ChooseSendFile,
+  CancelMonitorSend,
+  SetMonitorLog,
+  ChooseMonitorLogDir,
+  SetMonitorMode,
+  SetMonitorTriggers,
+  ResetTriggerCounts,
+  SetMonitorReconnect,
 } from "../wailsjs/go/main/App.js";
 import { EventsOn } from "../wailsjs/runtime/runtime.js";
 
 const portSelect = document.getElementById("portSelect");
 const baudSelect = document.getElementById("baudSelect");
 const btnRefresh = document.getElementById("btnRefresh");
+const chkAutoSelect = document.getElementById("chkAutoSelect");
+const btnChipInfo = document.getElementById("btnChipInfo");
+const chipInfo = document.getElementById("chipInfo");
+const btnCoreDump = document.getElementById("btnCoreDump");
 const btnChoose = document.getElementById("btnChoose");
 const btnFlash = document.getElementById("btnFlash");
+const btnCancelFlash = document.getElementById("btnCancelFlash");
 const btnMonitor = document.getElementById("btnMonitor");
 const btnStopMonitor = document.getElementById("btnStopMonitor");
+const btnMonitorElf = document.getElementById("btnMonitorElf");
 const btnClearLog = document.getElementById("btnClearLog");
 const btnAutoScroll = document.getElementById("btnAutoScroll");
 const filePath = document.getElementById("filePath");
+const resetSelect = document.getElementById("resetSelect");
+const resetSequence = document.getElementById("resetSequence");
+const beforeSelect = document.getElementById("beforeSelect");
+const afterSelect = document.getElementById("afterSelect");
+const chkDiff = document.getElementById("chkDiff");
+const chkTrace = document.getElementById("chkTrace");
+const chkMonitor = document.getElementById("chkMonitor");
+const btnReplayTrace = document.getElementById("btnReplayTrace");
 const logArea = document.getElementById("log");
+const lineEndingSelect = document.getElementById("lineEndingSelect");
+const chkHexSend = document.getElementById("chkHexSend");
+const sendInput = document.getElementById("sendInput");
+const btnSend = document.getElementById("btnSend");
+const chunkSize = document.getElementById("chunkSize");
+const chunkDelay = document.getElementById("chunkDelay");
+const btnSendFile = document.getElementById("btnSendFile");
+const btnCancelSend = document.getElementById("btnCancelSend");
+const chkMonitorLog = document.getElementById("chkMonitorLog");
+const chkMonitorRaw = document.getElementById("chkMonitorRaw");
+const monitorLogSize = document.getElementById("monitorLogSize");
+const monitorLogFiles = document.getElementById("monitorLogFiles");
+const btnMonitorLogDir = document.getElementById("btnMonitorLogDir");
+const monitorMode = document.getElementById("monitorMode");
+const chkReconnect = document.getElementById("chkReconnect");
+const triggerPattern = document.getElementById("triggerPattern");
+const triggerAction = document.getElementById("triggerAction");
+const btnAddTrigger = document.getElementById("btnAddTrigger");
+const btnResetTriggerCounts = document.getElementById("btnResetTriggerCounts");
+const triggerList = document.getElementById("triggerList");
+const levelFilter = document.getElementById("levelFilter");
+const tagFilter = document.getElementById("tagFilter");
 const progressContainer = document.getElementById("progressContainer");
 const progressBar = document.getElementById("progressBar");
 const progressText = document.getElementById("progressText");
 
+const RESET_STORAGE_KEY = "resetStrategies"; // Стратегии сброса по устройствам в localStorage
+const PREFERRED_SERIAL_KEY = "preferredSerial"; // Серийный номер последнего выбранного устройства
+const AUTO_SELECT_KEY = "autoSelectPort"; // Автовыбор только что подключенной платы
+const SEND_HISTORY_KEY = "sendHistory"; // История команд, отправленных из монитора
+const MAX_SEND_HISTORY = 50;
+const MONITOR_LOG_KEY = "monitorLog"; // Настройки записи мониторинга в файлы
+const MONITOR_MODE_KEY = "monitorMode"; // Текст или hex дамп
+const HEX_DUMP_WIDTH = 16; // Байт в строке hex дампа
+const TRIGGERS_KEY = "monitorTriggers"; // Правила монитора
+const RECONNECT_KEY = "monitorReconnect"; // Переподключение монитора к той же плате
+
 let isMonitoring = false;
+let flashCancelled = false; // Прошивка была отменена пользователем
 let logUpdateTimeout = null; // Для батчинга обновлений лога
 let autoScrollEnabled = true; // Автоскролл включен по умолчанию
 let logLines = []; // Массив строк лога для эффективного управления
@@ -34,9 +100,10 @@ function log(msg) {
   addLogLine(`[${timestamp}] ${msg}`);
 }
 
-// Эффективное добавление строки в лог
-function addLogLine(line) {
-  logLines.push(line);
+// Эффективное добавление строки в лог. entry - строка, разобранная строка
+// монитора { text, level, tag, spans } или байты сырого режима { bytes, time }
+function addLogLine(entry) {
+  logLines.push(typeof entry === "string" ? { text: entry } : entry);
 
   // Ограничиваем количество строк (автоочистка как в терминале)
   if (logLines.length > MAX_LOG_LINES) {
@@ -44,7 +111,94 @@ function addLogLine(line) {
   }
 
   // Сразу обновляем отображение
-  logArea.textContent = logLines.join("\n");
+  renderLog();
+}
+
+// Порядок уровней ESP-IDF: фильтр показывает выбранный уровень и более важные
+const LOG_LEVELS = ["E", "W", "I", "D", "V"];
+
+// Проходит ли строка монитора фильтры по уровню и тегу. Строки прошивки
+// и строки не в формате ESP-IDF фильтр уровня не скрывает
+function matchesLogFilter(entry) {
+  if (entry.bytes) {
+    return true;
+  }
+
+  const level = levelFilter.value;
+  if (level && entry.level) {
+    if (LOG_LEVELS.indexOf(entry.level) > LOG_LEVELS.indexOf(level)) {
+      return false;
+    }
+  }
+
+  const tags = tagFilter.value
+    .split(",")
+    .map((t) => t.trim())
+    .filter((t) => t);
+  if (tags.length > 0 && entry.monitor) {
+    return tags.includes(entry.tag);
+  }
+
+  return true;
+}
+
+// Перерисовать лог с учетом фильтров и цветов
+function renderLog() {
+  const fragment = document.createDocumentFragment();
+
+  logLines.filter(matchesLogFilter).forEach((entry) => {
+    if (entry.bytes) {
+      renderHexDump(fragment, entry);
+      return;
+    }
+
+    const line = document.createElement("div");
+    if (entry.level) {
+      line.className = `log-level-${entry.level}`;
+    }
+    if (entry.marker) {
+      line.classList.add("log-marker");
+    }
+    if (entry.highlight) {
+      line.classList.add("log-highlight");
+    }
+    if (entry.prefix) {
+      line.append(entry.prefix);
+    }
+
+    // Цвета ANSI из прошивки, иначе строка окрашивается по уровню
+    if (entry.spans) {
+      entry.spans.forEach((span) => {
+        const el = document.createElement("span");
+        el.textContent = span.text;
+        if (span.color) {
+          el.classList.add(`ansi-${span.color}`);
+        }
+        if (span.bold) {
+          el.classList.add("ansi-bold");
+        }
+        line.appendChild(el);
+      });
+    } else {
+      line.append(entry.text);
+    }
+
+    fragment.appendChild(line);
+
+    // Расшифровка адресов паники по ELF файлу, как в idf.py monitor
+    (entry.symbols || []).forEach((symbol) => {
+      const el = document.createElement("div");
+      el.className = "log-symbol";
+      const register = symbol.register ? `${symbol.register} ` : "";
+      const location = symbol.file ? `${symbol.file}:${symbol.line}` : "??:?";
+      el.textContent = `    ${register}${symbol.address}: ${
+        symbol.function || "??"
+      } at ${location}`;
+      fragment.appendChild(el);
+    });
+  });
+
+  logArea.replaceChildren(fragment);
 
   // Автоскролл если включен
   if (autoScrollEnabled) {
@@ -52,6 +206,48 @@ function addLogLine(line) {
   }
 }
 
+// Время приема с миллисекундами: байты двоичного протокола идут плотно
+function formatReceiveTime(time) {
+  const date = new Date(time);
+  const ms = String(date.getMilliseconds()).padStart(3, "0");
+  return `${date.toLocaleTimeString()}.${ms}`;
+}
+
+// Hex дамп байтов одного чтения: смещение, байты и ASCII, как в hexdump -C
+function renderHexDump(fragment, entry) {
+  const header = document.createElement("div");
+  header.textContent = `[${formatReceiveTime(entry.time)}] RX ${
+    entry.bytes.length
+  } байт`;
+  fragment.appendChild(header);
+
+  const bytes = entry.bytes;
+  for (let offset = 0; offset < bytes.length; offset += HEX_DUMP_WIDTH) {
+    const row = bytes.subarray(offset, offset + HEX_DUMP_WIDTH);
+    const hex = Array.from(row, (b) => b.toString(16).padStart(2, "0"))
+      .join(" ")
+      .padEnd(HEX_DUMP_WIDTH * 3 - 1);
+    const ascii = Array.from(row, (b) =>
+      b >= 0x20 && b < 0x7f ? String.fromCharCode(b) : ".",
+    ).join("");
+
+    const line = document.createElement("div");
+    line.className = "log-hex";
+    line.append(`  ${offset.toString(16).padStart(4, "0")}  ${hex}  `);
+    const asciiEl = document.createElement("span");
+    asciiEl.className = "log-hex-ascii";
+    asciiEl.textContent = `|${ascii}|`;
+    line.appendChild(asciiEl);
+    fragment.appendChild(line);
+  }
+}
+
+// Очистить лог
+function clearLog() {
+  logLines = [];
+  logArea.replaceChildren();
+}
+
 // Обновить прогресс
 function updateProgress(progress, message) {
   progressBar.style.width = `${progress}%`;
@@ -79,29 +275,74 @@ EventsOn("flash-log", (message) => {
   log(message);
 });
 
+EventsOn("flash-cancelled", () => {
+  flashCancelled = true;
+  log("⏹️ Прошивка отменена, ESP32 оставлен в режиме загрузчика");
+});
+
+// Порты подключены или отключены
+EventsOn("ports-changed", (change) => {
+  // Во время прошивки и мониторинга выбранный порт не меняем
+  const busy = portSelect.disabled;
+  const select = !busy && chkAutoSelect.checked ? change.selected : "";
+  renderPorts(change.ports, select);
+  if (select) {
+    log(`🎯 Выбрана подключенная плата: ${portSelect.selectedOptions[0]?.textContent}`);
+  }
+});
+
 // События мониторинга порта
-EventsOn("monitor-data", (data) => {
-  // Данные уже приходят построчно, сразу отображаем
-  if (data.trim()) {
-    const timestamp = new Date().toLocaleTimeString();
-    const logLine = `[${timestamp}] ${data.trim()}`;
-
-    // Добавляем строку и сразу ограничиваем количество
-    logLines.push(logLine);
-    if (logLines.length > MAX_LOG_LINES) {
-      logLines = logLines.slice(-MAX_LOG_LINES);
-    }
+EventsOn("monitor-data", (line) => {
+  // Строки приходят уже разобранными: уровень, тег, сообщение и цвета ANSI
+  const timestamp = new Date().toLocaleTimeString();
+  addLogLine({
+    monitor: true,
+    prefix: `[${timestamp}] `,
+    text: line.text,
+    level: line.level,
+    tag: line.tag,
+    spans: line.spans,
+    symbols: line.symbols,
+    highlight: line.highlight,
+  });
+});
 
-    // Сразу обновляем отображение без батчинга
-    logArea.textContent = logLines.join("\n");
+// Срабатывание правила монитора. Правила проверяет backend, здесь только
+// счетчики и уведомления
+EventsOn("monitor-trigger", (hit) => {
+  triggerCounts[hit.index] = hit.count;
+  renderTriggers();
 
-    // Автоскролл если включен
-    if (autoScrollEnabled) {
-      logArea.scrollTop = logArea.scrollHeight;
-    }
+  if (hit.action === "notify" && !hit.throttled) {
+    notify(`Совпадение: ${hit.pattern}`, hit.text);
   }
 });
 
+// Сырой режим: байты одного чтения с временем приема
+EventsOn("monitor-raw", (chunk) => {
+  const bytes = Uint8Array.from(atob(chunk.data), (c) => c.charCodeAt(0));
+  addLogLine({ monitor: true, bytes, time: chunk.time });
+});
+
+// Плата отключилась, монитор ждет ее появления; отметки видны в потоке
+// при любых фильтрах
+EventsOn("monitor-disconnected", (port) => {
+  setSendEnabled(false);
+  addLogLine({
+    marker: true,
+    text: `──── 🔌 ${port} отключен, ожидание устройства ────`,
+  });
+});
+
+EventsOn("monitor-reconnected", (info) => {
+  setSendEnabled(true);
+  const downtime = (info.downtime / 1000).toFixed(1);
+  addLogLine({
+    marker: true,
+    text: `──── 🔗 Переподключено: ${info.port} (через ${downtime} с) ────`,
+  });
+});
+
 EventsOn("monitor-error", (error) => {
   log(`❌ Ошибка мониторинга: ${error}`);
   stopMonitoring();
@@ -111,23 +352,181 @@ EventsOn("monitor-stop", () => {
   stopMonitoring();
 });
 
+// На время прошивки монитор приостанавливается, сообщения об этом приходят в flash-log
+EventsOn("monitor-paused", () => {
+  btnStopMonitor.disabled = true;
+  setSendEnabled(false);
+});
+
+EventsOn("monitor-resumed", () => {
+  btnStopMonitor.disabled = false;
+  setSendEnabled(true);
+});
+
 // Получить и показать порты
 async function refreshPorts() {
-  portSelect.innerHTML = "";
   try {
     const ports = await ListPorts();
-    ports.forEach((p) => {
-      const o = document.createElement("option");
-      o.value = p;
-      o.textContent = p;
-      portSelect.appendChild(o);
-    });
+    renderPorts(ports, "");
     log(`Найдено портов: ${ports.length}`);
   } catch (e) {
     log("Ошибка ListPorts: " + e);
   }
 }
 
+// Заполнить список портов. select - порт, который нужно выбрать (например,
+// только что подключенная плата); иначе сохраняется текущий выбор
+function renderPorts(ports, select) {
+  const previous = portSelect.value;
+  portSelect.innerHTML = "";
+  ports.forEach((p) => {
+    const o = document.createElement("option");
+    o.value = p.name;
+    o.textContent = p.label;
+    o.dataset.serial = p.serialNumber || "";
+    o.title = p.vid ? `VID ${p.vid}, PID ${p.pid}` : p.name;
+    portSelect.appendChild(o);
+  });
+
+  // Предпочтительное устройство ищем по серийному номеру: имя порта
+  // может меняться при переподключении
+  const preferred = localStorage.getItem(PREFERRED_SERIAL_KEY);
+  const bySerial = ports.find(
+    (p) => preferred && p.serialNumber === preferred
+  );
+  if (select) {
+    portSelect.value = select;
+  } else if (ports.some((p) => p.name === previous)) {
+    portSelect.value = previous;
+  } else if (bySerial) {
+    portSelect.value = bySerial.name;
+  }
+
+  if (portSelect.value !== previous) {
+    chipInfo.style.display = "none";
+  }
+  restoreResetStrategy();
+}
+
+// Ключ устройства для сохраненных настроек: серийный номер USB, иначе имя порта
+function deviceKey() {
+  const option = portSelect.selectedOptions[0];
+  if (!option) {
+    return "";
+  }
+  return option.dataset.serial || option.value;
+}
+
+// Стратегия сброса для выбранного порта: имя или своя последовательность
+function currentReset() {
+  if (resetSelect.value === "custom") {
+    return resetSequence.value.trim();
+  }
+  return resetSelect.value;
+}
+
+// Загрузить сохраненные стратегии сброса по портам
+function loadResetStrategies() {
+  try {
+    return JSON.parse(localStorage.getItem(RESET_STORAGE_KEY)) || {};
+  } catch (e) {
+    return {};
+  }
+}
+
+// Запомнить стратегию сброса для устройства
+function saveResetStrategy() {
+  const key = deviceKey();
+  if (!key) {
+    return;
+  }
+  const strategies = loadResetStrategies();
+  strategies[key] = currentReset();
+  localStorage.setItem(RESET_STORAGE_KEY, JSON.stringify(strategies));
+}
+
+// Показать стратегию сброса, сохраненную для выбранного устройства
+function restoreResetStrategy() {
+  const reset = loadResetStrategies()[deviceKey()] || "";
+  const named = [...resetSelect.options].some(
+    (o) => o.value === reset && o.value !== "custom"
+  );
+  resetSelect.value = named ? reset : "custom";
+  resetSequence.value = named ? "" : reset;
+  resetSequence.style.display = named ? "none" : "block";
+}
+
+resetSelect.addEventListener("change", () => {
+  resetSequence.style.display =
+    resetSelect.value === "custom" ? "block" : "none";
+  saveResetStrategy();
+});
+
+resetSequence.addEventListener("change", saveResetStrategy);
+
+// Показать сведения о чипе и flash
+function showChipInfo(info) {
+  let text = `🧩 ${info.chip}`;
+  if (info.flash) {
+    const vendor =
+      info.flash.manufacturer ||
+      `0x${info.flash.manufacturerId.toString(16).padStart(2, "0")}`;
+    const id = info.flash.jedecId.toString(16).padStart(6, "0");
+    text += ` · 💾 ${vendor} ${info.flashSize || "объем неизвестен"} (JEDEC ${id})`;
+  } else {
+    text += " · 💾 flash не опознана";
+  }
+  chipInfo.textContent = text;
+  chipInfo.style.display = "block";
+}
+
+// Кнопка опознания чипа
+btnChipInfo.addEventListener("click", async () => {
+  const port = portSelect.value;
+  if (!port) {
+    alert("Выберите COM-порт!");
+    return;
+  }
+
+  if (isMonitoring) {
+    alert("Остановите мониторинг перед опознанием чипа!");
+    return;
+  }
+
+  btnChipInfo.disabled = true;
+  btnFlash.disabled = true;
+  try {
+    showChipInfo(await ChipInfo(port, currentReset()));
+  } catch (e) {
+    log("❌ Ошибка опознания чипа: " + e);
+    chipInfo.style.display = "none";
+  } finally {
+    btnChipInfo.disabled = false;
+    btnFlash.disabled = false;
+  }
+});
+
+// Кнопка чтения core dump из раздела coredump. Стеки задач выводятся в лог
+// и расшифровываются по ELF файлу, подключенному к монитору
+btnCoreDump.addEventListener("click", async () => {
+  const port = portSelect.value;
+  if (!port) {
+    alert("Выберите COM-порт!");
+    return;
+  }
+
+  btnCoreDump.disabled = true;
+  btnFlash.disabled = true;
+  try {
+    await ReadCoreDump(port, currentReset());
+  } catch (e) {
+    log("❌ Ошибка чтения core dump: " + e);
+  } finally {
+    btnCoreDump.disabled = false;
+    btnFlash.disabled = false;
+  }
+});
+
 // Выбор файла
 btnChoose.addEventListener("click", async () => {
   try {
@@ -141,58 +540,138 @@ btnChoose.addEventListener("click", async () => {
   }
 });
 
-// Кнопка «Прошить»
-btnFlash.addEventListener("click", async () => {
-  const port = portSelect.value;
-  const file = filePath.value;
-  if (!port || !file) {
-    alert("Укажите порт и файл!");
-    return;
-  }
+// Заблокировать/разблокировать интерфейс на время прошивки
+function setFlashing(active) {
+  btnFlash.disabled = active;
+  btnReplayTrace.disabled = active;
+  btnChoose.disabled = active;
+  btnRefresh.disabled = active;
+  btnChipInfo.disabled = active;
+  btnCoreDump.disabled = active;
+  btnMonitor.disabled = active;
+  portSelect.disabled = active;
+  baudSelect.disabled = active;
+  resetSelect.disabled = active;
+  resetSequence.disabled = active;
+  beforeSelect.disabled = active;
+  afterSelect.disabled = active;
+  chkDiff.disabled = active;
+  chkTrace.disabled = active;
+  chkMonitor.disabled = active;
 
-  if (isMonitoring) {
-    alert("Остановите мониторинг перед прошивкой!");
-    return;
-  }
+  // Вместо кнопки прошивки показываем кнопку отмены
+  btnFlash.style.display = active ? "none" : "flex";
+  btnCancelFlash.style.display = active ? "flex" : "none";
+  btnCancelFlash.disabled = !active;
+}
 
-  // Блокируем интерфейс
-  btnFlash.disabled = true;
-  btnChoose.disabled = true;
-  btnRefresh.disabled = true;
-  btnMonitor.disabled = true;
-  portSelect.disabled = true;
-  baudSelect.disabled = true;
+// Выполнить прошивку (или воспроизведение трассы) с прогрессом и обработкой ошибок.
+// onSuccess вызывается после успешной прошивки
+async function runFlash(title, action, onSuccess) {
+  setFlashing(true);
 
   // Очищаем лог и показываем прогресс
-  logArea.textContent = "";
+  clearLog();
   showProgress(true);
 
-  log(`🚀 Начинаем прошивку ${file} → ${port}`);
+  log(title);
+  flashCancelled = false;
 
   try {
-    await Flash(port, file);
+    await action();
     log("✅ Прошивка успешно завершена!");
+    if (onSuccess) {
+      onSuccess();
+      return;
+    }
     setTimeout(() => {
       alert("Прошивка завершена успешно!");
     }, 100);
   } catch (e) {
+    if (flashCancelled) {
+      return;
+    }
     log("❌ Ошибка прошивки: " + e);
     updateProgress(0, "Ошибка");
     setTimeout(() => {
       alert("Ошибка прошивки: " + e);
     }, 100);
   } finally {
+    btnCancelFlash.disabled = true;
+
     // Разблокируем интерфейс и скрываем прогресс
     setTimeout(() => {
       showProgress(false);
-      btnFlash.disabled = false;
-      btnChoose.disabled = false;
-      btnRefresh.disabled = false;
-      btnMonitor.disabled = false;
-      portSelect.disabled = false;
-      baudSelect.disabled = false;
+      setFlashing(false);
+      // Монитор, запущенный после прошивки, снова блокирует выбор порта
+      if (isMonitoring) {
+        startMonitoring();
+      }
     }, 1000); // Задержка, чтобы пользователь увидел финальное состояние
   }
+}
+
+// Кнопка «Прошить»
+btnFlash.addEventListener("click", async () => {
+  const port = portSelect.value;
+  const file = filePath.value;
+  if (!port || !file) {
+    alert("Укажите порт и файл!");
+    return;
+  }
+
+  // Монитор работает на том же порту, со скоростью из списка скоростей
+  const monitor = chkMonitor.checked;
+  const monitorBaud = parseInt(baudSelect.value);
+
+  await runFlash(
+    `🚀 Начинаем прошивку ${file} → ${port}`,
+    () =>
+      Flash(port, file, {
+        diff: chkDiff.checked,
+        trace: chkTrace.checked,
+        reset: currentReset(),
+        before: beforeSelect.value,
+        after: afterSelect.value,
+        monitor,
+        monitorBaud,
+      }),
+    monitor
+      ? () => {
+          startMonitoring();
+          log(`🔍 Мониторинг порта ${port} запущен (${monitorBaud} baud)`);
+        }
+      : null
+  );
+});
+
+// Кнопка воспроизведения трассы: повторяет записанный сеанс без устройства
+btnReplayTrace.addEventListener("click", async () => {
+  let trace;
+  try {
+    trace = await ChooseTraceFile();
+  } catch (e) {
+    log("Ошибка выбора трассы: " + e);
+    return;
+  }
+  if (!trace) {
+    return;
+  }
+
+  // Если файл прошивки не выбран, используется файл из заголовка трассы
+  await runFlash(`▶️ Воспроизведение трассы ${trace}`, () =>
+    ReplayTrace(trace, filePath.value)
+  );
+});
+
+// Кнопка отмены прошивки
+btnCancelFlash.addEventListener("click", async () => {
+  btnCancelFlash.disabled = true;
+  try {
+    await CancelFlash();
+  } catch (e) {
+    log("❌ Ошибка отмены прошивки: " + e);
+  }
 });
 
 // Кнопка мониторинга порта
@@ -206,7 +685,7 @@ btnMonitor.addEventListener("click", async () => {
 
   try {
     // Очищаем лог перед началом мониторинга
-    logArea.textContent = "";
+    clearLog();
 
     await MonitorPort(port, baud);
     startMonitoring();
@@ -217,6 +696,30 @@ btnMonitor.addEventListener("click", async () => {
   }
 });
 
+// Кнопка выбора ELF файла прошивки для расшифровки паник. Повторное нажатие
+// при подключенном ELF отключает его
+btnMonitorElf.addEventListener("click", async () => {
+  try {
+    if (btnMonitorElf.classList.contains("active")) {
+      await SetMonitorELF("");
+      btnMonitorElf.classList.remove("active");
+      btnMonitorElf.title = "ELF файл прошивки для расшифровки паник";
+      log("🧭 ELF файл отключен");
+      return;
+    }
+
+    const elf = await ChooseELFFile();
+    if (!elf) {
+      return;
+    }
+    await SetMonitorELF(elf);
+    btnMonitorElf.classList.add("active");
+    btnMonitorElf.title = elf;
+  } catch (e) {
+    log("❌ Ошибка загрузки ELF: " + e);
+  }
+});
+
 // Кнопка остановки мониторинга
 btnStopMonitor.addEventListener("click", async () => {
   try {
@@ -227,21 +730,285 @@ btnStopMonitor.addEventListener("click", async () => {
   }
 });
 
+// История команд консоли: ↑/↓ в поле ввода, как в терминале
+let sendHistory = JSON.parse(localStorage.getItem(SEND_HISTORY_KEY) || "[]");
+let sendHistoryIndex = sendHistory.length;
+
+// Отправить строку из поля ввода в порт монитора
+async function sendLine() {
+  const text = sendInput.value;
+  const hex = chkHexSend.checked;
+  if (!text && hex) {
+    return;
+  }
+
+  try {
+    await MonitorSend(text, { lineEnding: lineEndingSelect.value, hex });
+    log(`➡️ ${hex ? "HEX " : ""}${text}`);
+  } catch (e) {
+    log("❌ Ошибка отправки: " + e);
+    return;
+  }
+
+  if (text && sendHistory[sendHistory.length - 1] !== text) {
+    sendHistory.push(text);
+    sendHistory = sendHistory.slice(-MAX_SEND_HISTORY);
+    localStorage.setItem(SEND_HISTORY_KEY, JSON.stringify(sendHistory));
+  }
+  sendHistoryIndex = sendHistory.length;
+  sendInput.value = "";
+}
+
+btnSend.addEventListener("click", sendLine);
+
+sendInput.addEventListener("keydown", (e) => {
+  if (e.key === "Enter") {
+    e.preventDefault();
+    sendLine();
+  } else if (e.key === "ArrowUp" && sendHistoryIndex > 0) {
+    e.preventDefault();
+    sendHistoryIndex--;
+    sendInput.value = sendHistory[sendHistoryIndex];
+  } else if (e.key === "ArrowDown" && sendHistoryIndex < sendHistory.length) {
+    e.preventDefault();
+    sendHistoryIndex++;
+    sendInput.value = sendHistory[sendHistoryIndex] || "";
+  }
+});
+
+// Отправка файла блоками с паузой
+btnSendFile.addEventListener("click", async () => {
+  let file;
+  try {
+    file = await ChooseSendFile();
+  } catch (e) {
+    log("Ошибка выбора файла: " + e);
+    return;
+  }
+  if (!file) {
+    return;
+  }
+
+  btnSendFile.style.display = "none";
+  btnCancelSend.style.display = "inline-block";
+  try {
+    await MonitorSendFile(file, {
+      chunkSize: parseInt(chunkSize.value) || 0,
+      // Пустое поле - пауза по умолчанию, 0 - без паузы
+      delayMs: chunkDelay.value === "" ? -1 : parseInt(chunkDelay.value),
+    });
+  } catch (e) {
+    log("❌ Ошибка отправки файла: " + e);
+  } finally {
+    btnSendFile.style.display = "inline-block";
+    btnCancelSend.style.display = "none";
+    btnCancelSend.textContent = "⏹️ Прервать";
+  }
+});
+
+// Прогресс отправки файла показывается на кнопке отмены
+EventsOn("monitor-send-progress", (percent) => {
+  btnCancelSend.textContent = `⏹️ Прервать (${percent}%)`;
+});
+
+btnCancelSend.addEventListener("click", () => {
+  CancelMonitorSend();
+});
+
+// Правила монитора: регулярное выражение и действие
+const TRIGGER_ACTIONS = {
+  highlight: "🖍️ Выделить",
+  notify: "🔔 Уведомить",
+  count: "🔢 Считать",
+  stop: "🛑 Остановить",
+  reset: "🔁 Сбросить",
+};
+
+let triggers = JSON.parse(localStorage.getItem(TRIGGERS_KEY) || "[]");
+let triggerCounts = triggers.map(() => 0);
+
+function renderTriggers() {
+  const fragment = document.createDocumentFragment();
+
+  triggers.forEach((trigger, index) => {
+    const item = document.createElement("li");
+    item.append(TRIGGER_ACTIONS[trigger.action] || trigger.action);
+
+    const pattern = document.createElement("span");
+    pattern.className = "trigger-pattern";
+    pattern.textContent = `/${trigger.pattern}/`;
+    item.appendChild(pattern);
+
+    const count = document.createElement("span");
+    count.className = "trigger-count";
+    count.textContent = triggerCounts[index] || 0;
+    item.appendChild(count);
+
+    const remove = document.createElement("button");
+    remove.className = "btn btn-compact";
+    remove.textContent = "✕";
+    remove.addEventListener("click", () => {
+      saveTriggers(triggers.filter((_, i) => i !== index));
+    });
+    item.appendChild(remove);
+
+    fragment.appendChild(item);
+  });
+
+  triggerList.replaceChildren(fragment);
+}
+
+// Передать правила в backend. Счетчики при этом обнуляются
+async function saveTriggers(next) {
+  try {
+    await SetMonitorTriggers(next);
+  } catch (e) {
+    log("❌ Ошибка в правиле монитора: " + e);
+    return false;
+  }
+
+  triggers = next;
+  triggerCounts = triggers.map(() => 0);
+  localStorage.setItem(TRIGGERS_KEY, JSON.stringify(triggers));
+  renderTriggers();
+  return true;
+}
+
+// Уведомление на рабочем столе; в лог пишется всегда
+function notify(title, body) {
+  log(`🔔 ${title}: ${body}`);
+
+  if (!("Notification" in window) || Notification.permission === "denied") {
+    return;
+  }
+  if (Notification.permission === "granted") {
+    new Notification(title, { body });
+    return;
+  }
+  Notification.requestPermission().then((permission) => {
+    if (permission === "granted") {
+      new Notification(title, { body });
+    }
+  });
+}
+
+btnAddTrigger.addEventListener("click", async () => {
+  const pattern = triggerPattern.value.trim();
+  if (!pattern) {
+    return;
+  }
+  const next = [...triggers, { pattern, action: triggerAction.value }];
+  if (await saveTriggers(next)) {
+    triggerPattern.value = "";
+  }
+});
+
+triggerPattern.addEventListener("keydown", (e) => {
+  if (e.key === "Enter") {
+    btnAddTrigger.click();
+  }
+});
+
+btnResetTriggerCounts.addEventListener("click", () => {
+  ResetTriggerCounts();
+  triggerCounts = triggers.map(() => 0);
+  renderTriggers();
+});
+
+saveTriggers(triggers);
+
+// Переподключение монитора после сброса или отключения платы
+chkReconnect.checked = localStorage.getItem(RECONNECT_KEY) === "true";
+SetMonitorReconnect(chkReconnect.checked);
+chkReconnect.addEventListener("change", () => {
+  localStorage.setItem(RECONNECT_KEY, String(chkReconnect.checked));
+  SetMonitorReconnect(chkReconnect.checked);
+});
+
+// Режим монитора переключается сразу, в том числе во время мониторинга
+monitorMode.value = localStorage.getItem(MONITOR_MODE_KEY) || "text";
+SetMonitorMode(monitorMode.value);
+monitorMode.addEventListener("change", () => {
+  localStorage.setItem(MONITOR_MODE_KEY, monitorMode.value);
+  SetMonitorMode(monitorMode.value);
+});
+
+// Запись мониторинга в файлы ведет backend, настройки хранятся здесь
+let monitorLogDir = "";
+
+function applyMonitorLog() {
+  const options = {
+    enabled: chkMonitorLog.checked,
+    raw: chkMonitorRaw.checked,
+    dir: monitorLogDir,
+    maxSizeMB: parseInt(monitorLogSize.value) || 0,
+    maxFiles: parseInt(monitorLogFiles.value) || 0,
+  };
+  localStorage.setItem(MONITOR_LOG_KEY, JSON.stringify(options));
+  btnMonitorLogDir.title = monitorLogDir || "Каталог по умолчанию";
+  SetMonitorLog(options);
+}
+
+const savedMonitorLog = JSON.parse(
+  localStorage.getItem(MONITOR_LOG_KEY) || "{}",
+);
+chkMonitorLog.checked = !!savedMonitorLog.enabled;
+chkMonitorRaw.checked = !!savedMonitorLog.raw;
+monitorLogDir = savedMonitorLog.dir || "";
+if (savedMonitorLog.maxSizeMB) {
+  monitorLogSize.value = savedMonitorLog.maxSizeMB;
+}
+if (savedMonitorLog.maxFiles) {
+  monitorLogFiles.value = savedMonitorLog.maxFiles;
+}
+applyMonitorLog();
+
+for (const input of [
+  chkMonitorLog,
+  chkMonitorRaw,
+  monitorLogSize,
+  monitorLogFiles,
+]) {
+  input.addEventListener("change", applyMonitorLog);
+}
+
+btnMonitorLogDir.addEventListener("click", async () => {
+  try {
+    const dir = await ChooseMonitorLogDir();
+    if (dir) {
+      monitorLogDir = dir;
+      applyMonitorLog();
+      log("📂 Каталог журналов монитора: " + dir);
+    }
+  } catch (e) {
+    log("Ошибка выбора каталога: " + e);
+  }
+});
+
+// Поля отправки доступны только во время мониторинга
+function setSendEnabled(enabled) {
+  sendInput.disabled = !enabled;
+  btnSend.disabled = !enabled;
+  btnSendFile.disabled = !enabled;
+}
+
 // Функции мониторинга
 function startMonitoring() {
   isMonitoring = true;
+  setSendEnabled(true);
   btnMonitor.style.display = "none";
   btnStopMonitor.style.display = "inline-block";
-  btnFlash.disabled = true;
+  btnStopMonitor.disabled = false;
   portSelect.disabled = true;
   baudSelect.disabled = true;
 }
 
 function stopMonitoring() {
   isMonitoring = false;
+  setSendEnabled(false);
   btnMonitor.style.display = "inline-block";
   btnStopMonitor.style.display = "none";
-  btnFlash.disabled = false;
+  btnStopMonitor.disabled = false;
   portSelect.disabled = false;
   baudSelect.disabled = false;
 
@@ -254,8 +1021,7 @@ function stopMonitoring() {
 
 // Кнопка очистки лога
 btnClearLog.addEventListener("click", () => {
-  logLines = []; // Очищаем массив строк
-  logArea.textContent = "";
+  clearLog();
   log("🗑️ Лог очищен");
 });
 
@@ -278,9 +1044,33 @@ btnAutoScroll.addEventListener("click", () => {
   }
 });
 
+// Фильтры уровня и тега применяются к уже полученным строкам
+levelFilter.addEventListener("change", renderLog);
+tagFilter.addEventListener("input", renderLog);
+
 // При старте
 btnRefresh.addEventListener("click", refreshPorts);
 
+// Автовыбор новой платы: техник просто подключает следующее устройство
+chkAutoSelect.checked = localStorage.getItem(AUTO_SELECT_KEY) === "true";
+SetAutoSelectPort(chkAutoSelect.checked);
+chkAutoSelect.addEventListener("change", () => {
+  localStorage.setItem(AUTO_SELECT_KEY, String(chkAutoSelect.checked));
+  SetAutoSelectPort(chkAutoSelect.checked);
+});
+
+// Сведения о чипе относятся к выбранному порту
+portSelect.addEventListener("change", () => {
+  chipInfo.style.display = "none";
+  restoreResetStrategy();
+
+  // Запоминаем выбранное устройство, чтобы выбрать его снова после переподключения
+  const serial = portSelect.selectedOptions[0]?.dataset.serial;
+  if (serial) {
+    localStorage.setItem(PREFERRED_SERIAL_KEY, serial);
+  }
+});
+
 // Инициализируем состояние кнопки автоскролла
 if (autoScrollEnabled) {
   btnAutoScroll.classList.add("active");
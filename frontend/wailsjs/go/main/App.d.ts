@@ -1,12 +1,49 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
+import {main} from '../models';
+
+export function CancelFlash():Promise<void>;
+
+export function CancelMonitorSend():Promise<void>;
+
+export function ChipInfo(arg1:string,arg2:string):Promise<main.ChipInfo>;
+
+export function ChooseELFFile():Promise<string>;
 
 export function ChooseFile():Promise<string>;
 
-export function Flash(arg1:string,arg2:string):Promise<void>;
+export function ChooseMonitorLogDir():Promise<string>;
+
+export function ChooseSendFile():Promise<string>;
 
-export function ListPorts():Promise<Array<string>>;
+export function ChooseTraceFile():Promise<string>;
+
+export function Flash(arg1:string,arg2:string,arg3:main.FlashOptions):Promise<void>;
+
+export function ListPorts():Promise<Array<main.PortInfo>>;
 
 export function MonitorPort(arg1:string,arg2:number):Promise<void>;
 
+export function MonitorSend(arg1:string,arg2:main.MonitorSendOptions):Promise<void>;
+
+export function MonitorSendFile(arg1:string,arg2:main.MonitorFileOptions):Promise<void>;
+
+export function ReadCoreDump(arg1:string,arg2:string):Promise<main.CoreDump>;
+
+export function ReplayTrace(arg1:string,arg2:string):Promise<void>;
+
+export function ResetTriggerCounts():Promise<void>;
+
+export function SetAutoSelectPort(arg1:boolean):Promise<void>;
+
+export function SetMonitorELF(arg1:string):Promise<void>;
+
+export function SetMonitorLog(arg1:main.MonitorLogOptions):Promise<void>;
+
+export function SetMonitorMode(arg1:string):Promise<void>;
+
+export function SetMonitorReconnect(arg1:boolean):Promise<void>;
+
+export function SetMonitorTriggers(arg1:Array<main.MonitorTrigger>):Promise<void>;
+
 export function StopMonitor():Promise<void>;
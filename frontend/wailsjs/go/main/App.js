@@ -2,12 +2,40 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function CancelFlash() {
+  return window['go']['main']['App']['CancelFlash']();
+}
+
+export function CancelMonitorSend() {
+  return window['go']['main']['App']['CancelMonitorSend']();
+}
+
+export function ChipInfo(arg1, arg2) {
+  return window['go']['main']['App']['ChipInfo'](arg1, arg2);
+}
+
+export function ChooseELFFile() {
+  return window['go']['main']['App']['ChooseELFFile']();
+}
+
 export function ChooseFile() {
   return window['go']['main']['App']['ChooseFile']();
 }
 
-export function Flash(arg1, arg2) {
-  return window['go']['main']['App']['Flash'](arg1, arg2);
+export function ChooseMonitorLogDir() {
+  return window['go']['main']['App']['ChooseMonitorLogDir']();
+}
+
+export function ChooseSendFile() {
+  return window['go']['main']['App']['ChooseSendFile']();
+}
+
+export function ChooseTraceFile() {
+  return window['go']['main']['App']['ChooseTraceFile']();
+}
+
+export function Flash(arg1, arg2, arg3) {
+  return window['go']['main']['App']['Flash'](arg1, arg2, arg3);
 }
 
 export function ListPorts() {
@@ -18,6 +46,50 @@ export function MonitorPort(arg1, arg2) {
   return window['go']['main']['App']['MonitorPort'](arg1, arg2);
 }
 
+export function MonitorSend(arg1, arg2) {
+  return window['go']['main']['App']['MonitorSend'](arg1, arg2);
+}
+
+export function MonitorSendFile(arg1, arg2) {
+  return window['go']['main']['App']['MonitorSendFile'](arg1, arg2);
+}
+
+export function ReadCoreDump(arg1, arg2) {
+  return window['go']['main']['App']['ReadCoreDump'](arg1, arg2);
+}
+
+export function ReplayTrace(arg1, arg2) {
+  return window['go']['main']['App']['ReplayTrace'](arg1, arg2);
+}
+
+export function ResetTriggerCounts() {
+  return window['go']['main']['App']['ResetTriggerCounts']();
+}
+
+export function SetAutoSelectPort(arg1) {
+  return window['go']['main']['App']['SetAutoSelectPort'](arg1);
+}
+
+export function SetMonitorELF(arg1) {
+  return window['go']['main']['App']['SetMonitorELF'](arg1);
+}
+
+export function SetMonitorLog(arg1) {
+  return window['go']['main']['App']['SetMonitorLog'](arg1);
+}
+
+export function SetMonitorMode(arg1) {
+  return window['go']['main']['App']['SetMonitorMode'](arg1);
+}
+
+export function SetMonitorReconnect(arg1) {
+  return window['go']['main']['App']['SetMonitorReconnect'](arg1);
+}
+
+export function SetMonitorTriggers(arg1) {
+  return window['go']['main']['App']['SetMonitorTriggers'](arg1);
+}
+
 export function StopMonitor() {
   return window['go']['main']['App']['StopMonitor']();
 }
@@ -0,0 +1,271 @@
+export namespace main {
+	
+	export class ChipInfo {
+	    chip: string;
+	    magic: number;
+	    flash?: FlashChipInfo;
+	    flashSize: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ChipInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.chip = source["chip"];
+	        this.magic = source["magic"];
+	        this.flash = this.convertValues(source["flash"], FlashChipInfo);
+	        this.flashSize = source["flashSize"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class CoreDump {
+	    arch: string;
+	    version: number;
+	    checksumOk: boolean;
+	    exception: string;
+	    faultAddress: string;
+	    tasks: CoreDumpTask[];
+	
+	    static createFrom(source: any = {}) {
+	        return new CoreDump(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.arch = source["arch"];
+	        this.version = source["version"];
+	        this.checksumOk = source["checksumOk"];
+	        this.exception = source["exception"];
+	        this.faultAddress = source["faultAddress"];
+	        this.tasks = this.convertValues(source["tasks"], CoreDumpTask);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class CoreDumpTask {
+	    handle: string;
+	    name: string;
+	    crashed: boolean;
+	    frames: SymbolInfo[];
+	
+	    static createFrom(source: any = {}) {
+	        return new CoreDumpTask(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.handle = source["handle"];
+	        this.name = source["name"];
+	        this.crashed = source["crashed"];
+	        this.frames = this.convertValues(source["frames"], SymbolInfo);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class FlashChipInfo {
+	    jedecId: number;
+	    manufacturerId: number;
+	    deviceId: number;
+	    manufacturer: string;
+	    size: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new FlashChipInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.jedecId = source["jedecId"];
+	        this.manufacturerId = source["manufacturerId"];
+	        this.deviceId = source["deviceId"];
+	        this.manufacturer = source["manufacturer"];
+	        this.size = source["size"];
+	    }
+	}
+	export class FlashOptions {
+	    diff: boolean;
+	    trace: boolean;
+	    reset: string;
+	    before: string;
+	    after: string;
+	    monitor: boolean;
+	    monitorBaud: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new FlashOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.diff = source["diff"];
+	        this.trace = source["trace"];
+	        this.reset = source["reset"];
+	        this.before = source["before"];
+	        this.after = source["after"];
+	        this.monitor = source["monitor"];
+	        this.monitorBaud = source["monitorBaud"];
+	    }
+	}
+	export class MonitorFileOptions {
+	    chunkSize: number;
+	    delayMs: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new MonitorFileOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.chunkSize = source["chunkSize"];
+	        this.delayMs = source["delayMs"];
+	    }
+	}
+	export class MonitorLogOptions {
+	    enabled: boolean;
+	    raw: boolean;
+	    dir: string;
+	    maxSizeMB: number;
+	    maxFiles: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new MonitorLogOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.raw = source["raw"];
+	        this.dir = source["dir"];
+	        this.maxSizeMB = source["maxSizeMB"];
+	        this.maxFiles = source["maxFiles"];
+	    }
+	}
+	export class MonitorSendOptions {
+	    lineEnding: string;
+	    hex: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new MonitorSendOptions(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.lineEnding = source["lineEnding"];
+	        this.hex = source["hex"];
+	    }
+	}
+	export class MonitorTrigger {
+	    pattern: string;
+	    action: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new MonitorTrigger(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.pattern = source["pattern"];
+	        this.action = source["action"];
+	    }
+	}
+	export class PortInfo {
+	    name: string;
+	    isUsb: boolean;
+	    vid: string;
+	    pid: string;
+	    serialNumber: string;
+	    product: string;
+	    bridge: string;
+	    bridgeName: string;
+	    label: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new PortInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.isUsb = source["isUsb"];
+	        this.vid = source["vid"];
+	        this.pid = source["pid"];
+	        this.serialNumber = source["serialNumber"];
+	        this.product = source["product"];
+	        this.bridge = source["bridge"];
+	        this.bridgeName = source["bridgeName"];
+	        this.label = source["label"];
+	    }
+	}
+	export class SymbolInfo {
+	    address: string;
+	    register: string;
+	    function: string;
+	    file: string;
+	    line: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new SymbolInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.address = source["address"];
+	        this.register = source["register"];
+	        this.function = source["function"];
+	        this.file = source["file"];
+	        this.line = source["line"];
+	    }
+	}
+
+}
+
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Таймаут чтения порта монитора: за это время горутина замечает остановку
+const MONITOR_READ_TIMEOUT = 50 * time.Millisecond
+
+// serialMonitor - чтение порта в отдельной горутине. Буфер строк принадлежит
+// горутине, поэтому общего изменяемого состояния с App у нее нет
+type serialMonitor struct {
+	lease        *PortLease
+	baudRate     int
+	serialNumber string // серийный номер USB для поиска устройства после переподключения
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	coreDump coreDumpCapture // Прием core dump из UART, состояние горутины чтения
+
+	captureMu sync.Mutex      // Защищает capture
+	capture   *monitorCapture // Запись сеанса в файлы, nil если выключена
+
+	writeMu    sync.Mutex         // Запись в порт из консоли и при отправке файла
+	sendMu     sync.Mutex         // Защищает sendCancel
+	sendCancel context.CancelFunc // Отмена отправки файла, nil если не идет
+}
+
+// halt останавливает горутину чтения и ждет ее завершения. Порт остается открытым
+func (m *serialMonitor) halt() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	<-m.done
+}
+
+// swapCapture заменяет запись сеанса и возвращает прежнюю
+func (m *serialMonitor) swapCapture(capture *monitorCapture) *monitorCapture {
+	m.captureMu.Lock()
+	defer m.captureMu.Unlock()
+
+	previous := m.capture
+	m.capture = capture
+	return previous
+}
+
+// currentCapture возвращает запись сеанса, nil если она выключена
+func (m *serialMonitor) currentCapture() *monitorCapture {
+	m.captureMu.Lock()
+	defer m.captureMu.Unlock()
+	return m.capture
+}
+
+// captureLine записывает строку монитора в журнал сеанса
+func (m *serialMonitor) captureLine(raw string) {
+	if capture := m.currentCapture(); capture != nil {
+		capture.Line(raw)
+	}
+}
+
+// captureRaw записывает байты из порта в сырой журнал сеанса
+func (m *serialMonitor) captureRaw(data []byte) {
+	if capture := m.currentCapture(); capture != nil {
+		capture.Raw(data)
+	}
+}
+
+// captureMark записывает служебную отметку в журнал сеанса
+func (m *serialMonitor) captureMark(message string) {
+	if capture := m.currentCapture(); capture != nil {
+		capture.Mark(message)
+	}
+}
+
+// MonitorPort создает соединение с портом для мониторинга и возвращает канал с данными
+func (a *App) MonitorPort(portName string, baudRate int) error {
+	// Если уже идет мониторинг, останавливаем его
+	a.haltMonitor()
+
+	if err := a.openMonitor(portName, baudRate, nil); err != nil {
+		return fmt.Errorf("failed to open port for monitoring: %w", err)
+	}
+	return nil
+}
+
+// openMonitor берет порт в аренду у менеджера портов и запускает мониторинг.
+// capture - продолжаемая запись сеанса, nil - начать новую по настройкам
+func (a *App) openMonitor(portName string, baudRate int, capture *monitorCapture) error {
+	lease, err := a.ports.Acquire(portName, OWNER_MONITOR)
+	if err != nil {
+		return err
+	}
+
+	// У сетевого моста скорость задается на его стороне
+	if err := lease.Port().SetBaudRate(baudRate); err != nil && isLocalPort(portName) {
+		lease.Release()
+		return err
+	}
+
+	a.startMonitor(lease, baudRate, capture)
+	return nil
+}
+
+// startMonitor запускает чтение арендованного порта и отправку строк в frontend.
+// На время прошивки того же порта монитор приостанавливается менеджером портов.
+// capture - продолжаемая запись сеанса, nil - начать новую по настройкам
+func (a *App) startMonitor(lease *PortLease, baudRate int, capture *monitorCapture) {
+	if capture == nil {
+		capture = a.openCapture(lease.Name(), baudRate)
+	}
+
+	m := &serialMonitor{
+		lease:        lease,
+		baudRate:     baudRate,
+		serialNumber: monitorSerialNumber(lease.Name()),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+		capture:      capture,
+	}
+
+	lease.SetPreempt(func() func() {
+		m.halt()
+		m.captureMark("Мониторинг приостановлен на время прошивки")
+		a.emitLog("⏸️ Мониторинг приостановлен на время прошивки")
+		runtime.EventsEmit(a.ctx, "monitor-paused", "")
+		return func() { a.resumeMonitor(m) }
+	})
+
+	a.monitorMu.Lock()
+	previous := a.monitor
+	a.monitor = m
+	a.monitorMu.Unlock()
+
+	if previous != nil {
+		previous.halt()
+		previous.lease.Release()
+		// При возобновлении запись сеанса переходит к новому монитору
+		if old := previous.swapCapture(nil); old != capture {
+			a.closeCapture(old)
+		}
+	}
+
+	a.emitLog(fmt.Sprintf("🔍 Начинаем мониторинг порта %s (%d baud)", lease.Name(), baudRate))
+	a.emitLog("💡 Для остановки мониторинга нажмите 'Стоп'")
+
+	go a.readMonitor(m)
+}
+
+// readMonitor читает порт и отправляет в frontend полные строки
+func (a *App) readMonitor(m *serialMonitor) {
+	defer close(m.done)
+
+	port := m.lease.Port()
+	if err := port.SetReadTimeout(MONITOR_READ_TIMEOUT); err != nil {
+		a.dropMonitor(m, err)
+		return
+	}
+
+	buffer := make([]byte, 1024)
+	lineBuffer := "" // Буфер для накопления неполных строк
+	rawText := ""    // Неполная строка сырого режима для правил монитора
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		n, err := port.Read(buffer)
+		if err != nil {
+			// Проверяем, если это timeout - продолжаем
+			if strings.Contains(err.Error(), "timeout") {
+				continue
+			}
+
+			// Плата сбросилась или USB переподключился: ждем то же устройство.
+			// Неполная строка и начатый core dump от прежнего сеанса отбрасываются
+			if a.monitorAutoReconnect() && isLocalPort(m.lease.Name()) {
+				if !a.reconnectMonitor(m, err) {
+					return
+				}
+				port = m.lease.Port()
+				lineBuffer = ""
+				rawText = ""
+				m.coreDump = coreDumpCapture{}
+				continue
+			}
+
+			a.dropMonitor(m, err)
+			return
+		}
+
+		if n == 0 {
+			continue
+		}
+		m.captureRaw(buffer[:n])
+
+		// В сыром режиме байты уходят как есть: двоичные кадры и вывод с одним \r
+		// не разбиваются на строки. Накопленная неполная строка выводится перед ними
+		if a.monitorRawMode() {
+			if lineBuffer != "" {
+				if a.handleMonitorLine(m, lineBuffer) {
+					return
+				}
+				lineBuffer = ""
+			}
+			a.emitMonitorChunk(buffer[:n], time.Now())
+			if a.triggerRawChunk(m, &rawText, buffer[:n]) {
+				return
+			}
+			continue
+		}
+
+		// После переключения из сырого режима неполная строка проверяется правилами
+		// и не смешивается с новыми данными
+		if rawText != "" {
+			raw := rawText
+			rawText = ""
+			if a.triggerRawLine(m, raw) {
+				return
+			}
+		}
+
+		// Добавляем новые данные к буферу
+		lineBuffer += string(buffer[:n])
+
+		// Обрабатываем все полные строки
+		for {
+			newlineIdx := strings.Index(lineBuffer, "\n")
+			if newlineIdx == -1 {
+				// Нет полных строк, ждем еще данных
+				break
+			}
+
+			// Извлекаем полную строку
+			line := lineBuffer[:newlineIdx]
+			lineBuffer = lineBuffer[newlineIdx+1:]
+
+			if a.handleMonitorLine(m, line) {
+				return
+			}
+		}
+
+		// Если буфер становится слишком большим без \n, отправляем как есть и очищаем
+		if len(lineBuffer) > 1000 {
+			if a.handleMonitorLine(m, lineBuffer) {
+				return
+			}
+			lineBuffer = ""
+		}
+	}
+}
+
+// handleMonitorLine отделяет core dump, который ESP-IDF выводит в base64, от обычных строк.
+// Возвращает true, если мониторинг остановлен правилом и чтение нужно завершить
+func (a *App) handleMonitorLine(m *serialMonitor, raw string) bool {
+	m.captureLine(raw)
+
+	consumed, data, err := m.coreDump.feed(raw)
+	if !consumed {
+		return a.emitMonitorLine(m, raw)
+	}
+
+	switch {
+	case err != nil:
+		a.emitLog("❌ Ошибка приема core dump: " + err.Error())
+	case data != nil:
+		a.emitLog(fmt.Sprintf("🧩 Получен core dump из UART: %d байт", len(data)))
+		dump, err := DecodeCoreDump(data, a.monitorSymbols())
+		if err != nil {
+			a.emitLog("❌ Ошибка разбора core dump: " + err.Error())
+			return false
+		}
+		a.logCoreDump(dump)
+	case m.coreDump.active && len(m.coreDump.lines) == 0:
+		a.emitLog("📥 Прием core dump из UART...")
+	}
+	return false
+}
+
+// emitMonitorLine разбирает строку журнала, проверяет ее правилами монитора и
+// отправляет в frontend, если она не пустая. Возвращает true, если правило
+// остановило мониторинг
+func (a *App) emitMonitorLine(m *serialMonitor, raw string) bool {
+	// Убираем лишние символы \r и пробелы по краям
+	line := ParseMonitorLine(strings.TrimSpace(raw))
+	if line.Text == "" {
+		return false
+	}
+
+	if symbols := a.monitorSymbols(); symbols != nil {
+		line.Symbols = symbols.Symbolize(line.Text)
+	}
+	stop := a.applyTriggers(m, &line)
+	runtime.EventsEmit(a.ctx, "monitor-data", line)
+
+	if stop {
+		a.stopByTrigger(m, line.Text)
+	}
+	return stop
+}
+
+// dropMonitor завершает монитор после ошибки чтения, например при отключении платы
+func (a *App) dropMonitor(m *serialMonitor, err error) {
+	m.captureMark("Ошибка порта: " + err.Error())
+	if a.releaseMonitor(m) {
+		runtime.EventsEmit(a.ctx, "monitor-error", err.Error())
+	}
+}
+
+// releaseMonitor освобождает порт и журнал завершившегося монитора. Возвращает
+// true, если монитор был текущим и frontend нужно сообщить об остановке
+func (a *App) releaseMonitor(m *serialMonitor) bool {
+	a.monitorMu.Lock()
+	current := a.monitor == m
+	if current {
+		a.monitor = nil
+	}
+	a.monitorMu.Unlock()
+
+	m.lease.Release()
+	a.closeCapture(m.swapCapture(nil))
+	return current
+}
+
+// resumeMonitor открывает порт заново после прошивки, если монитор не был
+// остановлен или заменен за это время
+func (a *App) resumeMonitor(m *serialMonitor) {
+	a.monitorMu.Lock()
+	current := a.monitor == m
+	a.monitorMu.Unlock()
+	if !current {
+		return
+	}
+
+	if err := a.openMonitor(m.lease.Name(), m.baudRate, m.currentCapture()); err != nil {
+		a.dropMonitor(m, err)
+		return
+	}
+	m.captureMark("Мониторинг возобновлен")
+
+	a.emitLog("▶️ Мониторинг возобновлен")
+	runtime.EventsEmit(a.ctx, "monitor-resumed", "")
+}
+
+// ChooseELFFile открывает диалог выбора ELF файла прошивки для монитора
+func (a *App) ChooseELFFile() (string, error) {
+	return runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Выберите ELF файл прошивки",
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "ELF Files (*.elf)",
+				Pattern:     "*.elf",
+			},
+		},
+	})
+}
+
+// SetMonitorELF подключает к монитору ELF файл прошивки: адреса из паник и обратной
+// трассировки будут расшифрованы до функции, файла и строки. Пустой путь отключает ELF
+func (a *App) SetMonitorELF(path string) error {
+	var symbols *ELFSymbolizer
+	if path != "" {
+		var err error
+		symbols, err = LoadELFSymbolizer(path)
+		if err != nil {
+			return err
+		}
+		a.emitLog(fmt.Sprintf("🧭 Подключен ELF для расшифровки паник: %s", filepath.Base(path)))
+	}
+
+	a.monitorMu.Lock()
+	a.symbols = symbols
+	a.monitorMu.Unlock()
+
+	return nil
+}
+
+// monitorSymbols возвращает ELF файл прошивки, подключенный к монитору
+func (a *App) monitorSymbols() *ELFSymbolizer {
+	a.monitorMu.Lock()
+	defer a.monitorMu.Unlock()
+	return a.symbols
+}
+
+// haltMonitor останавливает мониторинг и освобождает порт, не сообщая frontend
+func (a *App) haltMonitor() {
+	a.monitorMu.Lock()
+	m := a.monitor
+	a.monitor = nil
+	a.monitorMu.Unlock()
+
+	if m == nil {
+		return
+	}
+
+	// Горутина завершается до закрытия порта, поэтому ожидание по таймеру не нужно
+	m.halt()
+	m.lease.Release()
+	a.closeCapture(m.swapCapture(nil))
+}
+
+// StopMonitor останавливает мониторинг порта
+func (a *App) StopMonitor() {
+	a.haltMonitor()
+
+	runtime.EventsEmit(a.ctx, "monitor-stop", "")
+	a.emitLog("⏹️ Мониторинг порта остановлен")
+}
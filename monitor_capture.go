@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Ротация журнала монитора по умолчанию: 10 файлов по 10 МБ на сеанс
+const (
+	MONITOR_LOG_MAX_SIZE_MB = 10
+	MONITOR_LOG_MAX_FILES   = 10
+)
+
+// MonitorLogOptions - запись сеанса мониторинга в файлы на стороне backend.
+// Текстовый журнал - строки с временем ПК, сырой - байты из порта как есть
+type MonitorLogOptions struct {
+	Enabled   bool   `json:"enabled"`
+	Raw       bool   `json:"raw"`       // дополнительно писать сырые байты в .bin
+	Dir       string `json:"dir"`       // "" - каталог по умолчанию
+	MaxSizeMB int    `json:"maxSizeMB"` // размер одного файла, 0 - MONITOR_LOG_MAX_SIZE_MB
+	MaxFiles  int    `json:"maxFiles"`  // файлов сеанса на диске, 0 - MONITOR_LOG_MAX_FILES
+}
+
+// rotatingFile - файл, который при достижении maxSize продолжается в следующем:
+// base.ext, base-001.ext, base-002.ext... Старые части сверх maxFiles удаляются
+type rotatingFile struct {
+	base     string // путь без расширения
+	ext      string
+	maxSize  int64
+	maxFiles int
+
+	file  *os.File
+	size  int64
+	index int      // номер следующей части
+	parts []string // пути частей на диске, от старой к новой
+}
+
+func newRotatingFile(base, ext string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{base: base, ext: ext, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open создает следующую часть и удаляет лишние старые
+func (r *rotatingFile) open() error {
+	path := r.base + r.ext
+	if r.index > 0 {
+		path = fmt.Sprintf("%s-%03d%s", r.base, r.index, r.ext)
+	}
+	r.index++
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create monitor log: %w", err)
+	}
+	r.file = file
+	r.size = 0
+	r.parts = append(r.parts, path)
+
+	for len(r.parts) > r.maxFiles {
+		os.Remove(r.parts[0])
+		r.parts = r.parts[1:]
+	}
+	return nil
+}
+
+// Write дописывает данные, переходя к новой части при превышении размера.
+// Данные одной записи не разрываются между частями
+func (r *rotatingFile) Write(data []byte) (int, error) {
+	if r.size > 0 && r.size+int64(len(data)) > r.maxSize {
+		r.file.Close()
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+	return n, err
+}
+
+// Path возвращает путь к текущей части: первые части могли быть уже удалены ротацией
+func (r *rotatingFile) Path() string {
+	return r.parts[len(r.parts)-1]
+}
+
+func (r *rotatingFile) Close() error {
+	return r.file.Close()
+}
+
+// monitorCapture - запись одного сеанса мониторинга. Сеанс продолжается и после
+// приостановки на время прошивки
+type monitorCapture struct {
+	mu   sync.Mutex
+	text *rotatingFile
+	raw  *rotatingFile // nil, если сырая запись выключена
+	err  error         // первая ошибка записи; после нее запись прекращается
+}
+
+// newMonitorCapture создает файлы сеанса monitor-<время>.log и .bin
+func newMonitorCapture(options MonitorLogOptions, header string) (*monitorCapture, error) {
+	dir := options.Dir
+	if dir == "" {
+		var err error
+		if dir, err = monitorLogDir(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create monitor log directory: %w", err)
+	}
+
+	maxSize := int64(options.MaxSizeMB)
+	if maxSize <= 0 {
+		maxSize = MONITOR_LOG_MAX_SIZE_MB
+	}
+	maxSize *= 1024 * 1024
+	maxFiles := options.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = MONITOR_LOG_MAX_FILES
+	}
+
+	base := sessionBase(dir, "monitor", ".log", time.Now())
+
+	c := &monitorCapture{}
+	var err error
+	if c.text, err = newRotatingFile(base, ".log", maxSize, maxFiles); err != nil {
+		return nil, err
+	}
+	if options.Raw {
+		if c.raw, err = newRotatingFile(base, ".bin", maxSize, maxFiles); err != nil {
+			c.text.Close()
+			return nil, err
+		}
+	}
+
+	c.Mark(header)
+	return c, nil
+}
+
+// sessionBase возвращает путь без расширения для файлов нового сеанса (журнала
+// монитора, трассы). Время с миллисекундами и проверка существующих файлов ext
+// не дают новому сеансу перезаписать файл предыдущего, начатого в ту же секунду
+func sessionBase(dir, prefix, ext string, now time.Time) string {
+	base := filepath.Join(dir, prefix+"-"+now.Format("20060102-150405.000"))
+	candidate := base
+	for i := 2; ; i++ {
+		if _, err := os.Stat(candidate + ext); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// Line записывает строку монитора без кодов ANSI с временем ПК
+func (c *monitorCapture) Line(raw string) {
+	text := ansiPattern.ReplaceAllString(strings.TrimRight(raw, "\r"), "")
+	c.writeText(fmt.Sprintf("%s %s\n", time.Now().Format("2006-01-02 15:04:05.000"), text))
+}
+
+// Mark записывает служебную отметку: начало сеанса, пауза, отправленная команда
+func (c *monitorCapture) Mark(message string) {
+	c.writeText(fmt.Sprintf("%s === %s\n", time.Now().Format("2006-01-02 15:04:05.000"), message))
+}
+
+func (c *monitorCapture) writeText(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		_, c.err = c.text.Write([]byte(line))
+	}
+}
+
+// Raw записывает байты из порта как есть
+func (c *monitorCapture) Raw(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.raw != nil && c.err == nil {
+		_, c.err = c.raw.Write(data)
+	}
+}
+
+// Paths возвращает пути текущих частей текстового и сырого журналов
+func (c *monitorCapture) Paths() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	paths := []string{c.text.Path()}
+	if c.raw != nil {
+		paths = append(paths, c.raw.Path())
+	}
+	return paths
+}
+
+// Close закрывает файлы и возвращает первую ошибку записи
+func (c *monitorCapture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.text.Close()
+	if c.raw != nil {
+		c.raw.Close()
+	}
+	return c.err
+}
+
+// monitorLogDir возвращает каталог журналов монитора по умолчанию
+func monitorLogDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "espflasher", "monitor"), nil
+}
+
+// openCapture начинает запись сеанса по текущим настройкам. nil, если запись выключена
+func (a *App) openCapture(portName string, baudRate int) *monitorCapture {
+	a.monitorMu.Lock()
+	options := a.logOptions
+	a.monitorMu.Unlock()
+
+	if !options.Enabled {
+		return nil
+	}
+
+	header := fmt.Sprintf("Мониторинг %s, %d baud", portName, baudRate)
+	capture, err := newMonitorCapture(options, header)
+	if err != nil {
+		a.emitLog("❌ Не удалось начать запись журнала монитора: " + err.Error())
+		return nil
+	}
+
+	a.emitLog("📝 Журнал монитора: " + strings.Join(capture.Paths(), ", "))
+	return capture
+}
+
+// closeCapture завершает запись сеанса
+func (a *App) closeCapture(capture *monitorCapture) {
+	if capture == nil {
+		return
+	}
+	capture.Mark("Мониторинг остановлен")
+	if err := capture.Close(); err != nil {
+		a.emitLog("⚠️ Ошибка записи журнала монитора: " + err.Error())
+	}
+}
+
+// SetMonitorLog задает запись мониторинга в файлы. Идущий мониторинг
+// сразу начинает новый сеанс с новыми настройками
+func (a *App) SetMonitorLog(options MonitorLogOptions) {
+	a.monitorMu.Lock()
+	changed := a.logOptions != options
+	a.logOptions = options
+	m := a.monitor
+	a.monitorMu.Unlock()
+
+	if m == nil || !changed {
+		return
+	}
+
+	capture := a.openCapture(m.lease.Name(), m.baudRate)
+	a.closeCapture(m.swapCapture(capture))
+}
+
+// ChooseMonitorLogDir открывает диалог выбора каталога журналов монитора
+func (a *App) ChooseMonitorLogDir() (string, error) {
+	dir, _ := monitorLogDir()
+
+	return runtime.OpenDirectoryDialog(a.ctx, runtime.OpenDialogOptions{
+		Title:                "Каталог журналов монитора",
+		DefaultDirectory:     dir,
+		CanCreateDirectories: true,
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxSize   int64
+		maxFiles  int
+		writes    []string
+		wantParts []string // имя файла: содержимое
+	}{
+		{
+			name:      "no rotation",
+			maxSize:   100,
+			maxFiles:  3,
+			writes:    []string{"abc", "def"},
+			wantParts: []string{"s.log:abcdef"},
+		},
+		{
+			name:      "rotation keeps writes whole",
+			maxSize:   5,
+			maxFiles:  3,
+			writes:    []string{"abc", "def", "gh"},
+			wantParts: []string{"s.log:abc", "s-001.log:defgh"},
+		},
+		{
+			name:      "oversized write goes to its own part",
+			maxSize:   4,
+			maxFiles:  3,
+			writes:    []string{"ab", "0123456789", "cd"},
+			wantParts: []string{"s.log:ab", "s-001.log:0123456789", "s-002.log:cd"},
+		},
+		{
+			name:      "old parts are removed",
+			maxSize:   2,
+			maxFiles:  2,
+			writes:    []string{"aa", "bb", "cc", "dd"},
+			wantParts: []string{"s-002.log:cc", "s-003.log:dd"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			r, err := newRotatingFile(filepath.Join(dir, "s"), ".log", tt.maxSize, tt.maxFiles)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, data := range tt.writes {
+				if _, err := r.Write([]byte(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, entry := range entries {
+				content, _ := os.ReadFile(filepath.Join(dir, entry.Name()))
+				got = append(got, entry.Name()+":"+string(content))
+			}
+			// os.ReadDir сортирует по имени: "s-001.log" < "s.log"
+			want := append([]string(nil), tt.wantParts...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("files = %q, want %q", got, want)
+			}
+
+			last := tt.wantParts[len(tt.wantParts)-1]
+			if wantPath := filepath.Join(dir, last[:strings.Index(last, ":")]); r.Path() != wantPath {
+				t.Errorf("Path() = %q, want current part %q", r.Path(), wantPath)
+			}
+		})
+	}
+}
+
+func TestSessionBase(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 6, 7, 8, 9, 123_000_000, time.Local)
+
+	base := sessionBase(dir, "monitor", ".log", now)
+	if want := filepath.Join(dir, "monitor-20240506-070809.123"); base != want {
+		t.Fatalf("sessionBase = %q, want %q", base, want)
+	}
+
+	// Сеанс, начатый в ту же миллисекунду, не перезаписывает журнал предыдущего
+	latest := base
+	for _, want := range []string{base + "-2", base + "-3"} {
+		if err := os.WriteFile(latest+".log", nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got := sessionBase(dir, "monitor", ".log", now)
+		if got != want {
+			t.Fatalf("sessionBase = %q, want %q", got, want)
+		}
+		latest = got
+	}
+}
+
+func TestMonitorCapture(t *testing.T) {
+	dir := t.TempDir()
+	capture, err := newMonitorCapture(MonitorLogOptions{Enabled: true, Raw: true, Dir: dir}, "Мониторинг test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capture.Line("\x1b[0;32mI (10) app: started\x1b[0m\r")
+	capture.Raw([]byte{0x00, 0x01, 0xff})
+	paths := capture.Paths()
+	if err := capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paths) != 2 || !strings.HasSuffix(paths[0], ".log") || !strings.HasSuffix(paths[1], ".bin") {
+		t.Fatalf("Paths() = %q", paths)
+	}
+
+	text, _ := os.ReadFile(paths[0])
+	lines := strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " === Мониторинг test") || !strings.HasSuffix(lines[1], " I (10) app: started") {
+		t.Errorf("text log = %q", text)
+	}
+
+	raw, _ := os.ReadFile(paths[1])
+	if string(raw) != "\x00\x01\xff" {
+		t.Errorf("raw log = % x", raw)
+	}
+}
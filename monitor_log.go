@@ -0,0 +1,175 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Уровни журнала ESP-IDF (ESP_LOGE ... ESP_LOGV)
+const (
+	LOG_LEVEL_NONE    = ""
+	LOG_LEVEL_ERROR   = "E"
+	LOG_LEVEL_WARN    = "W"
+	LOG_LEVEL_INFO    = "I"
+	LOG_LEVEL_DEBUG   = "D"
+	LOG_LEVEL_VERBOSE = "V"
+)
+
+// Строка журнала ESP-IDF: "I (1234) wifi: message" или с системным временем
+// "I (12:34:56.789) wifi: message" при CONFIG_LOG_TIMESTAMP_SOURCE_SYSTEM
+var idfLogPattern = regexp.MustCompile(`^([EWIDV]) \(([0-9:.]+)\) ([^:]*): ?(.*)$`)
+
+// Управляющая последовательность ANSI: CSI ... буква
+var ansiPattern = regexp.MustCompile("\x1b\\[([0-9;]*)([A-Za-z])")
+
+// Цвета ANSI 30-37 и 90-97 по номеру
+var ansiColors = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// MonitorSpan - фрагмент строки с одним оформлением ANSI
+type MonitorSpan struct {
+	Text  string `json:"text"`
+	Color string `json:"color,omitempty"` // имя цвета ANSI, например "red" или "bright-green"
+	Bold  bool   `json:"bold,omitempty"`
+}
+
+// MonitorLine - строка монитора, разобранная по формату журнала ESP-IDF.
+// Строки в другом формате приходят только с Text и Spans
+type MonitorLine struct {
+	Text    string        `json:"text"`  // строка без управляющих последовательностей
+	Level   string        `json:"level"` // LOG_LEVEL_*
+	Tick    int64         `json:"tick"`  // время из скобок в мс от загрузки (или от полуночи для системного времени), -1 если нет
+	Tag     string        `json:"tag"`
+	Message string        `json:"message"`
+	Spans   []MonitorSpan `json:"spans"` // оформление ANSI для отображения
+
+	// Расшифровка адресов паники и обратной трассировки по ELF файлу прошивки
+	Symbols []SymbolInfo `json:"symbols,omitempty"`
+
+	// Строка совпала с правилом монитора TRIGGER_HIGHLIGHT
+	Highlight bool `json:"highlight,omitempty"`
+}
+
+// ParseMonitorLine разбирает строку монитора: выделяет цвета ANSI и поля журнала ESP-IDF
+func ParseMonitorLine(raw string) MonitorLine {
+	spans := parseANSI(raw)
+
+	var text strings.Builder
+	for _, span := range spans {
+		text.WriteString(span.Text)
+	}
+
+	line := MonitorLine{Text: strings.TrimSpace(text.String()), Tick: -1, Spans: spans}
+
+	match := idfLogPattern.FindStringSubmatch(line.Text)
+	if match == nil {
+		return line
+	}
+
+	line.Level = match[1]
+	line.Tick = parseLogTick(match[2])
+	line.Tag = match[3]
+	line.Message = match[4]
+
+	return line
+}
+
+// parseLogTick переводит время из скобок в миллисекунды: "1234" или "12:34:56.789"
+func parseLogTick(value string) int64 {
+	if !strings.Contains(value, ":") {
+		tick, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return -1
+		}
+		return tick
+	}
+
+	clock, millis, _ := strings.Cut(value, ".")
+	parts := strings.Split(clock, ":")
+	if len(parts) != 3 {
+		return -1
+	}
+
+	var total int64
+	for _, part := range parts {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return -1
+		}
+		total = total*60 + n
+	}
+	total *= 1000
+
+	if millis != "" {
+		n, err := strconv.ParseInt(millis, 10, 64)
+		if err != nil {
+			return -1
+		}
+		total += n
+	}
+
+	return total
+}
+
+// parseANSI разбивает строку на фрагменты по кодам SGR. Прочие управляющие
+// последовательности (перемещение курсора, очистка строки) отбрасываются
+func parseANSI(raw string) []MonitorSpan {
+	var spans []MonitorSpan
+	current := MonitorSpan{}
+
+	flush := func(text string) {
+		text = strings.ReplaceAll(text, "\r", "")
+		if text == "" {
+			return
+		}
+		span := current
+		span.Text = text
+		spans = append(spans, span)
+	}
+
+	rest := raw
+	for {
+		loc := ansiPattern.FindStringSubmatchIndex(rest)
+		if loc == nil {
+			flush(rest)
+			break
+		}
+
+		flush(rest[:loc[0]])
+		if rest[loc[4]:loc[5]] == "m" {
+			applySGR(&current, rest[loc[2]:loc[3]])
+		}
+		rest = rest[loc[1]:]
+	}
+
+	return spans
+}
+
+// applySGR меняет оформление по параметрам SGR, например "0;31" или "1;33"
+func applySGR(span *MonitorSpan, params string) {
+	if params == "" {
+		params = "0"
+	}
+
+	for _, param := range strings.Split(params, ";") {
+		code, err := strconv.Atoi(param)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case code == 0:
+			*span = MonitorSpan{}
+		case code == 1:
+			span.Bold = true
+		case code == 22:
+			span.Bold = false
+		case code >= 30 && code <= 37:
+			span.Color = ansiColors[code-30]
+		case code >= 90 && code <= 97:
+			span.Color = "bright-" + ansiColors[code-90]
+		case code == 39:
+			span.Color = ""
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMonitorLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		level   string
+		tick    int64
+		tag     string
+		message string
+		text    string
+	}{
+		{
+			name:    "info with color",
+			raw:     "\x1b[0;32mI (1234) wifi: connected\x1b[0m\r",
+			level:   LOG_LEVEL_INFO,
+			tick:    1234,
+			tag:     "wifi",
+			message: "connected",
+			text:    "I (1234) wifi: connected",
+		},
+		{
+			name:    "error",
+			raw:     "E (5) boot: Failed to verify app image",
+			level:   LOG_LEVEL_ERROR,
+			tick:    5,
+			tag:     "boot",
+			message: "Failed to verify app image",
+			text:    "E (5) boot: Failed to verify app image",
+		},
+		{
+			name:    "system time",
+			raw:     "W (12:34:56.789) main: low memory",
+			level:   LOG_LEVEL_WARN,
+			tick:    ((12*60+34)*60+56)*1000 + 789,
+			tag:     "main",
+			message: "low memory",
+			text:    "W (12:34:56.789) main: low memory",
+		},
+		{
+			name:    "empty message",
+			raw:     "D (10) tag:",
+			level:   LOG_LEVEL_DEBUG,
+			tick:    10,
+			tag:     "tag",
+			message: "",
+			text:    "D (10) tag:",
+		},
+		{
+			name:    "message with colons",
+			raw:     "V (7) http: url: http://host",
+			level:   LOG_LEVEL_VERBOSE,
+			tick:    7,
+			tag:     "http",
+			message: "url: http://host",
+			text:    "V (7) http: url: http://host",
+		},
+		{
+			name: "boot ROM output",
+			raw:  "rst:0x1 (POWERON_RESET),boot:0x13 (SPI_FAST_FLASH_BOOT)",
+			tick: -1,
+			text: "rst:0x1 (POWERON_RESET),boot:0x13 (SPI_FAST_FLASH_BOOT)",
+		},
+		{
+			name: "unknown level",
+			raw:  "X (1) tag: message",
+			tick: -1,
+			text: "X (1) tag: message",
+		},
+		{
+			name:    "malformed system time",
+			raw:     "I (12:34) tag: message",
+			level:   LOG_LEVEL_INFO,
+			tick:    -1,
+			tag:     "tag",
+			message: "message",
+			text:    "I (12:34) tag: message",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := ParseMonitorLine(tt.raw)
+			if line.Text != tt.text {
+				t.Errorf("Text = %q, want %q", line.Text, tt.text)
+			}
+			if line.Level != tt.level || line.Tick != tt.tick || line.Tag != tt.tag || line.Message != tt.message {
+				t.Errorf("got level=%q tick=%d tag=%q message=%q, want level=%q tick=%d tag=%q message=%q",
+					line.Level, line.Tick, line.Tag, line.Message, tt.level, tt.tick, tt.tag, tt.message)
+			}
+		})
+	}
+}
+
+func TestParseANSI(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []MonitorSpan
+	}{
+		{
+			name: "plain text",
+			raw:  "hello",
+			want: []MonitorSpan{{Text: "hello"}},
+		},
+		{
+			name: "color and reset",
+			raw:  "\x1b[0;31mfail\x1b[0m ok",
+			want: []MonitorSpan{{Text: "fail", Color: "red"}, {Text: " ok"}},
+		},
+		{
+			name: "bold bright color",
+			raw:  "\x1b[1;93mwarn",
+			want: []MonitorSpan{{Text: "warn", Color: "bright-yellow", Bold: true}},
+		},
+		{
+			name: "empty params reset",
+			raw:  "\x1b[32mgreen\x1b[mplain",
+			want: []MonitorSpan{{Text: "green", Color: "green"}, {Text: "plain"}},
+		},
+		{
+			name: "bold off and default color",
+			raw:  "\x1b[1;34ma\x1b[22mb\x1b[39mc",
+			want: []MonitorSpan{{Text: "a", Color: "blue", Bold: true}, {Text: "b", Color: "blue"}, {Text: "c"}},
+		},
+		{
+			name: "cursor sequences are dropped",
+			raw:  "\x1b[2Kline\x1b[1A\r",
+			want: []MonitorSpan{{Text: "line"}},
+		},
+		{
+			name: "only escape codes",
+			raw:  "\x1b[0m\r",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseANSI(tt.raw); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseANSI(%q) = %+v, want %+v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
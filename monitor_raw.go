@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Режимы монитора: строки журнала или байты как есть для двоичных протоколов
+const (
+	MONITOR_MODE_TEXT = "text"
+	MONITOR_MODE_RAW  = "raw"
+)
+
+// MonitorChunk - байты, полученные из порта одним чтением, в сыром режиме монитора
+type MonitorChunk struct {
+	Time int64  `json:"time"` // время приема, мс Unix
+	Data string `json:"data"` // байты в base64
+	Size int    `json:"size"`
+}
+
+// SetMonitorMode переключает режим монитора (MONITOR_MODE_*). Действует сразу,
+// в том числе на идущий мониторинг
+func (a *App) SetMonitorMode(mode string) error {
+	if mode != MONITOR_MODE_TEXT && mode != MONITOR_MODE_RAW {
+		return fmt.Errorf("unknown monitor mode %q", mode)
+	}
+
+	a.monitorMu.Lock()
+	a.rawMode = mode == MONITOR_MODE_RAW
+	a.monitorMu.Unlock()
+	return nil
+}
+
+// monitorRawMode сообщает, включен ли сырой режим монитора
+func (a *App) monitorRawMode() bool {
+	a.monitorMu.Lock()
+	defer a.monitorMu.Unlock()
+	return a.rawMode
+}
+
+// emitMonitorChunk отправляет в frontend байты из порта без разбора на строки.
+// В журнал сеанса байты уже записаны сырыми, в текстовый журнал они не попадают
+func (a *App) emitMonitorChunk(data []byte, received time.Time) {
+	runtime.EventsEmit(a.ctx, "monitor-raw", MonitorChunk{
+		Time: received.UnixMilli(),
+		Data: base64.StdEncoding.EncodeToString(data),
+		Size: len(data),
+	})
+}
+
+// triggerRawChunk проверяет правила монитора в сыром режиме: байты накапливаются
+// в pending и делятся на строки по \r и \n. Выделение строк в сыром режиме
+// не показывается, остальные действия выполняются как в текстовом. Возвращает
+// true, если правило остановило мониторинг
+func (a *App) triggerRawChunk(m *serialMonitor, pending *string, data []byte) bool {
+	*pending += string(data)
+
+	for {
+		idx := strings.IndexAny(*pending, "\r\n")
+		if idx == -1 {
+			break
+		}
+		raw := (*pending)[:idx]
+		*pending = (*pending)[idx+1:]
+
+		if a.triggerRawLine(m, raw) {
+			return true
+		}
+	}
+
+	// Двоичные кадры могут долго идти без перевода строки
+	if len(*pending) > 1000 {
+		raw := *pending
+		*pending = ""
+		return a.triggerRawLine(m, raw)
+	}
+	return false
+}
+
+// triggerRawLine проверяет правилами одну строку сырого потока
+func (a *App) triggerRawLine(m *serialMonitor, raw string) bool {
+	line := ParseMonitorLine(strings.TrimSpace(raw))
+	if line.Text == "" {
+		return false
+	}
+
+	if a.applyTriggers(m, &line) {
+		a.stopByTrigger(m, line.Text)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// MonitorReconnect - событие "monitor-reconnected": монитор снова читает устройство
+type MonitorReconnect struct {
+	Port     string `json:"port"`     // имя порта после переподключения, может отличаться от прежнего
+	Downtime int64  `json:"downtime"` // сколько устройство отсутствовало, мс
+}
+
+// SetMonitorReconnect включает автоматическое переподключение монитора после
+// сброса платы или переподключения встроенного USB
+func (a *App) SetMonitorReconnect(enabled bool) {
+	a.monitorMu.Lock()
+	defer a.monitorMu.Unlock()
+	a.autoReconnect = enabled
+}
+
+// monitorAutoReconnect сообщает, включено ли переподключение монитора
+func (a *App) monitorAutoReconnect() bool {
+	a.monitorMu.Lock()
+	defer a.monitorMu.Unlock()
+	return a.autoReconnect
+}
+
+// monitorSerialNumber возвращает серийный номер USB устройства порта монитора,
+// по которому оно находится после переподключения под другим именем
+func monitorSerialNumber(portName string) string {
+	if !isLocalPort(portName) {
+		return ""
+	}
+	if details := findPortDetails(portName); details != nil {
+		return details.SerialNumber
+	}
+	return ""
+}
+
+// reconnectMonitor ждет повторного появления устройства после ошибки чтения и
+// открывает его с прежней скоростью. Устройство ищется по серийному номеру USB,
+// без него - по имени порта. Ожидание длится до остановки монитора; false -
+// монитор остановлен или приостановлен за это время
+func (a *App) reconnectMonitor(m *serialMonitor, cause error) bool {
+	name := m.lease.Name()
+	lost := time.Now()
+
+	m.lease.Port().Close()
+	m.captureMark(fmt.Sprintf("Устройство отключено: %v", cause))
+	a.emitLog(fmt.Sprintf("🔌 Порт %s отключился, ожидание устройства...", name))
+	runtime.EventsEmit(a.ctx, "monitor-disconnected", name)
+
+	for {
+		select {
+		case <-m.stop:
+			return false
+		case <-time.After(RECONNECT_POLL):
+		}
+
+		target := name
+		if m.serialNumber != "" {
+			if target = findPortBySerial(m.serialNumber); target == "" {
+				continue
+			}
+		}
+
+		if err := a.reopenMonitorPort(m, target); err != nil {
+			continue
+		}
+
+		info := MonitorReconnect{Port: target, Downtime: time.Since(lost).Milliseconds()}
+		m.captureMark(fmt.Sprintf("Переподключено: %s", target))
+		if target != name {
+			a.emitLog(fmt.Sprintf("🔗 Устройство появилось как %s", target))
+		}
+		runtime.EventsEmit(a.ctx, "monitor-reconnected", info)
+		return true
+	}
+}
+
+// reopenMonitorPort открывает порт монитора заново. Запись из консоли ждет,
+// пока канал аренды заменяется
+func (a *App) reopenMonitorPort(m *serialMonitor, name string) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	port, err := m.lease.Reopen(name)
+	if err != nil {
+		return err
+	}
+	if err := port.SetBaudRate(m.baudRate); err != nil {
+		port.Close()
+		return err
+	}
+	return port.SetReadTimeout(MONITOR_READ_TIMEOUT)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Окончания строки при отправке из монитора
+const (
+	LINE_ENDING_NONE = ""
+	LINE_ENDING_CR   = "cr"
+	LINE_ENDING_LF   = "lf"
+	LINE_ENDING_CRLF = "crlf"
+)
+
+// Параметры отправки файла по умолчанию: небольшие блоки с паузой, чтобы
+// не переполнить буфер UART прошивки
+const (
+	MONITOR_SEND_CHUNK_SIZE  = 256
+	MONITOR_SEND_CHUNK_DELAY = 10 * time.Millisecond
+	MONITOR_SEND_MAX_CHUNK   = 64 * 1024
+)
+
+var ErrMonitorNotRunning = errors.New("monitor is not running")
+
+// MonitorSendOptions - параметры отправки строки из монитора
+type MonitorSendOptions struct {
+	LineEnding string `json:"lineEnding"` // LINE_ENDING_*
+	Hex        bool   `json:"hex"`        // строка - байты в hex ("01 A0 ff" или "0x01,0xa0"); окончание строки не добавляется
+}
+
+// MonitorFileOptions - параметры отправки файла блоками
+type MonitorFileOptions struct {
+	ChunkSize int `json:"chunkSize"` // байт в блоке, 0 - MONITOR_SEND_CHUNK_SIZE
+	DelayMs   int `json:"delayMs"`   // пауза между блоками, мс; 0 - без паузы, меньше 0 - MONITOR_SEND_CHUNK_DELAY
+}
+
+// lineEnding возвращает байты окончания строки
+func lineEnding(ending string) ([]byte, error) {
+	switch ending {
+	case LINE_ENDING_NONE:
+		return nil, nil
+	case LINE_ENDING_CR:
+		return []byte("\r"), nil
+	case LINE_ENDING_LF:
+		return []byte("\n"), nil
+	case LINE_ENDING_CRLF:
+		return []byte("\r\n"), nil
+	}
+	return nil, fmt.Errorf("unknown line ending %q", ending)
+}
+
+// ParseHexInput разбирает байты в hex. Пробелы, запятые и префиксы 0x допускаются
+func ParseHexInput(input string) ([]byte, error) {
+	var digits strings.Builder
+	for _, token := range strings.FieldsFunc(input, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t' || r == ':' || r == '-'
+	}) {
+		token = strings.TrimPrefix(strings.TrimPrefix(token, "0x"), "0X")
+		// Одиночная цифра в отдельном токене - один байт
+		if len(token) == 1 {
+			token = "0" + token
+		}
+		digits.WriteString(token)
+	}
+
+	data, err := hex.DecodeString(digits.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex input: %w", err)
+	}
+	return data, nil
+}
+
+// write отправляет данные в порт монитора целиком. Блокировка не дает
+// перемешаться строке из консоли и блокам отправляемого файла
+func (m *serialMonitor) write(data []byte) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	select {
+	case <-m.stop:
+		return fmt.Errorf("monitor is paused or stopped")
+	default:
+	}
+
+	port := m.lease.Port()
+	for len(data) > 0 {
+		n, err := port.Write(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// currentMonitor возвращает идущий мониторинг
+func (a *App) currentMonitor() (*serialMonitor, error) {
+	a.monitorMu.Lock()
+	defer a.monitorMu.Unlock()
+
+	if a.monitor == nil {
+		return nil, ErrMonitorNotRunning
+	}
+	return a.monitor, nil
+}
+
+// MonitorSend отправляет строку в порт монитора, например команду консоли прошивки
+func (a *App) MonitorSend(text string, options MonitorSendOptions) error {
+	m, err := a.currentMonitor()
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if options.Hex {
+		if data, err = ParseHexInput(text); err != nil {
+			return err
+		}
+	} else {
+		ending, err := lineEnding(options.LineEnding)
+		if err != nil {
+			return err
+		}
+		data = append([]byte(text), ending...)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+	if err := m.write(data); err != nil {
+		return err
+	}
+
+	if options.Hex {
+		m.captureMark("Отправлено (hex): " + text)
+	} else {
+		m.captureMark("Отправлено: " + text)
+	}
+	return nil
+}
+
+// ChooseSendFile открывает диалог выбора файла для отправки в порт монитора
+func (a *App) ChooseSendFile() (string, error) {
+	return runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Выберите файл для отправки",
+	})
+}
+
+// MonitorSendFile отправляет файл в порт монитора блоками с паузой между ними.
+// Прогресс приходит событием "monitor-send-progress", отмена - CancelMonitorSend
+func (a *App) MonitorSendFile(path string, options MonitorFileOptions) error {
+	m, err := a.currentMonitor()
+	if err != nil {
+		return err
+	}
+
+	chunkSize := options.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = MONITOR_SEND_CHUNK_SIZE
+	}
+	if chunkSize > MONITOR_SEND_MAX_CHUNK {
+		return fmt.Errorf("chunk size %d exceeds %d bytes", chunkSize, MONITOR_SEND_MAX_CHUNK)
+	}
+	delay := MONITOR_SEND_CHUNK_DELAY
+	if options.DelayMs >= 0 {
+		delay = time.Duration(options.DelayMs) * time.Millisecond
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(a.ctx)
+	defer cancel()
+
+	m.sendMu.Lock()
+	if m.sendCancel != nil {
+		m.sendMu.Unlock()
+		return fmt.Errorf("file transfer already in progress")
+	}
+	m.sendCancel = cancel
+	m.sendMu.Unlock()
+
+	defer func() {
+		m.sendMu.Lock()
+		m.sendCancel = nil
+		m.sendMu.Unlock()
+	}()
+
+	a.emitLog(fmt.Sprintf("📤 Отправка файла %s: %d байт блоками по %d", filepath.Base(path), len(data), chunkSize))
+
+	for sent := 0; sent < len(data); {
+		end := min(sent+chunkSize, len(data))
+		if err := m.write(data[sent:end]); err != nil {
+			return fmt.Errorf("failed to send file: %w", err)
+		}
+		sent = end
+		runtime.EventsEmit(a.ctx, "monitor-send-progress", sent*100/len(data))
+
+		if sent < len(data) {
+			select {
+			case <-ctx.Done():
+				a.emitLog(fmt.Sprintf("⏹️ Отправка файла прервана: %d из %d байт", sent, len(data)))
+				return fmt.Errorf("file transfer cancelled")
+			case <-m.stop:
+				return fmt.Errorf("monitor stopped during file transfer")
+			case <-time.After(delay):
+			}
+		}
+	}
+
+	m.captureMark(fmt.Sprintf("Отправлен файл %s: %d байт", filepath.Base(path), len(data)))
+	a.emitLog("✅ Файл отправлен")
+	return nil
+}
+
+// CancelMonitorSend прерывает отправку файла
+func (a *App) CancelMonitorSend() {
+	m, err := a.currentMonitor()
+	if err != nil {
+		return
+	}
+
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+	if m.sendCancel != nil {
+		m.sendCancel()
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseHexInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "spaces", input: "01 A0 ff", want: []byte{0x01, 0xa0, 0xff}},
+		{name: "prefixes and commas", input: "0x01,0XA0, 0xff", want: []byte{0x01, 0xa0, 0xff}},
+		{name: "continuous", input: "deadBEEF", want: []byte{0xde, 0xad, 0xbe, 0xef}},
+		{name: "colons and dashes", input: "aa:bb-cc", want: []byte{0xaa, 0xbb, 0xcc}},
+		{name: "single digits", input: "1 2 0xf", want: []byte{0x01, 0x02, 0x0f}},
+		{name: "tabs and extra separators", input: "\t01,, 02 ", want: []byte{0x01, 0x02}},
+		{name: "empty", input: "  ", want: []byte{}},
+		{name: "odd digits", input: "abc", wantErr: true},
+		{name: "not hex", input: "0g", wantErr: true},
+		{name: "text", input: "hello", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHexInput(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseHexInput(%q) = % x, want error", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("ParseHexInput(%q) = % x, want % x", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineEnding(t *testing.T) {
+	tests := map[string]string{
+		LINE_ENDING_NONE: "",
+		LINE_ENDING_CR:   "\r",
+		LINE_ENDING_LF:   "\n",
+		LINE_ENDING_CRLF: "\r\n",
+	}
+	for ending, want := range tests {
+		if got, err := lineEnding(ending); err != nil || string(got) != want {
+			t.Errorf("lineEnding(%q) = %q, %v; want %q", ending, got, err, want)
+		}
+	}
+
+	if _, err := lineEnding("lfcr"); err == nil {
+		t.Error("unknown line ending accepted")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Действия триггеров монитора. Совпадения считаются для всех действий
+const (
+	TRIGGER_HIGHLIGHT = "highlight" // выделить строку
+	TRIGGER_NOTIFY    = "notify"    // уведомление на рабочем столе
+	TRIGGER_STOP      = "stop"      // остановить мониторинг и закрыть журнал
+	TRIGGER_COUNT     = "count"     // только считать
+	TRIGGER_RESET     = "reset"     // аппаратный сброс платы через RTS
+
+	// Уведомление и сброс повторяются не чаще этого интервала: после сброса
+	// прошивка может снова вывести ту же строку при загрузке
+	TRIGGER_COOLDOWN = 5 * time.Second
+)
+
+// MonitorTrigger - правило монитора: регулярное выражение и действие TRIGGER_*
+type MonitorTrigger struct {
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"`
+}
+
+// TriggerHit - срабатывание правила, событие "monitor-trigger"
+type TriggerHit struct {
+	Index     int    `json:"index"` // номер правила в списке SetMonitorTriggers
+	Pattern   string `json:"pattern"`
+	Action    string `json:"action"`
+	Count     int    `json:"count"` // совпадений с момента задания правил или сброса счетчиков
+	Text      string `json:"text"`
+	Time      int64  `json:"time"`      // мс Unix
+	Throttled bool   `json:"throttled"` // действие пропущено из-за TRIGGER_COOLDOWN
+}
+
+type triggerRule struct {
+	MonitorTrigger
+	re    *regexp.Regexp
+	count int
+	fired time.Time // последнее выполненное уведомление или сброс
+}
+
+// triggerSet - правила монитора. Проверяются в горутине чтения порта,
+// а задаются из frontend, поэтому защищены мьютексом
+type triggerSet struct {
+	mu    sync.Mutex
+	rules []*triggerRule
+}
+
+// set заменяет правила и обнуляет счетчики
+func (t *triggerSet) set(triggers []MonitorTrigger) error {
+	rules := make([]*triggerRule, 0, len(triggers))
+	for i, trigger := range triggers {
+		switch trigger.Action {
+		case TRIGGER_HIGHLIGHT, TRIGGER_NOTIFY, TRIGGER_STOP, TRIGGER_COUNT, TRIGGER_RESET:
+		default:
+			return fmt.Errorf("trigger %d: unknown action %q", i+1, trigger.Action)
+		}
+
+		re, err := regexp.Compile(trigger.Pattern)
+		if err != nil {
+			return fmt.Errorf("trigger %d: invalid pattern: %w", i+1, err)
+		}
+		rules = append(rules, &triggerRule{MonitorTrigger: trigger, re: re})
+	}
+
+	t.mu.Lock()
+	t.rules = rules
+	t.mu.Unlock()
+	return nil
+}
+
+// resetCounts обнуляет счетчики совпадений
+func (t *triggerSet) resetCounts() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, rule := range t.rules {
+		rule.count = 0
+	}
+}
+
+// match проверяет строку всеми правилами и возвращает срабатывания
+func (t *triggerSet) match(text string, now time.Time) []TriggerHit {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var hits []TriggerHit
+	for i, rule := range t.rules {
+		if !rule.re.MatchString(text) {
+			continue
+		}
+		rule.count++
+
+		hit := TriggerHit{
+			Index:   i,
+			Pattern: rule.Pattern,
+			Action:  rule.Action,
+			Count:   rule.count,
+			Text:    text,
+			Time:    now.UnixMilli(),
+		}
+		if rule.Action == TRIGGER_NOTIFY || rule.Action == TRIGGER_RESET {
+			if now.Sub(rule.fired) < TRIGGER_COOLDOWN {
+				hit.Throttled = true
+			} else {
+				rule.fired = now
+			}
+		}
+		hits = append(hits, hit)
+	}
+	return hits
+}
+
+// SetMonitorTriggers задает правила монитора. Счетчики совпадений обнуляются
+func (a *App) SetMonitorTriggers(triggers []MonitorTrigger) error {
+	return a.triggers.set(triggers)
+}
+
+// ResetTriggerCounts обнуляет счетчики совпадений правил
+func (a *App) ResetTriggerCounts() {
+	a.triggers.resetCounts()
+}
+
+// applyTriggers проверяет строку монитора правилами и выполняет их действия.
+// Возвращает true, если мониторинг нужно остановить
+func (a *App) applyTriggers(m *serialMonitor, line *MonitorLine) bool {
+	hits := a.triggers.match(line.Text, time.Now())
+
+	stop := false
+	for _, hit := range hits {
+		runtime.EventsEmit(a.ctx, "monitor-trigger", hit)
+
+		switch hit.Action {
+		case TRIGGER_HIGHLIGHT:
+			line.Highlight = true
+		case TRIGGER_STOP:
+			stop = true
+		case TRIGGER_RESET:
+			if hit.Throttled {
+				continue
+			}
+			m.captureMark(fmt.Sprintf("Сброс по триггеру %q", hit.Pattern))
+			a.emitLog(fmt.Sprintf("🔁 Сброс платы по триггеру %q", hit.Pattern))
+			if err := m.hardReset(); err != nil {
+				a.emitLog("❌ " + err.Error())
+			}
+		}
+	}
+	return stop
+}
+
+// hardReset перезапускает плату через RTS (EN), не переводя ее в режим загрузки
+func (m *serialMonitor) hardReset() error {
+	port := m.lease.Port()
+	if err := port.SetDTR(false); err != nil { // GPIO0 = HIGH
+		return fmt.Errorf("hard reset failed: %w", err)
+	}
+	if err := port.SetRTS(true); err != nil { // EN = LOW
+		return fmt.Errorf("hard reset failed: %w", err)
+	}
+	time.Sleep(HARD_RESET_HOLD_TIME)
+	if err := port.SetRTS(false); err != nil { // EN = HIGH
+		return fmt.Errorf("hard reset failed: %w", err)
+	}
+	return nil
+}
+
+// stopByTrigger завершает мониторинг из горутины чтения по правилу TRIGGER_STOP.
+// Журнал сеанса закрывается, чтобы его можно было сразу открыть
+func (a *App) stopByTrigger(m *serialMonitor, text string) {
+	m.captureMark("Остановлено триггером: " + text)
+
+	var paths []string
+	if capture := m.currentCapture(); capture != nil {
+		paths = capture.Paths()
+	}
+
+	if !a.releaseMonitor(m) {
+		return
+	}
+
+	a.emitLog("🛑 Мониторинг остановлен триггером: " + text)
+	if len(paths) > 0 {
+		a.emitLog("📝 Журнал сохранен: " + strings.Join(paths, ", "))
+	}
+	runtime.EventsEmit(a.ctx, "monitor-stop", "")
+}
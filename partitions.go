@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Таблица разделов ESP-IDF
+const (
+	PARTITION_TABLE_OFFSET   = 0x8000 // CONFIG_PARTITION_TABLE_OFFSET по умолчанию
+	PARTITION_TABLE_MAX_SIZE = 0xc00
+	PARTITION_ENTRY_SIZE     = 32
+	PARTITION_MAGIC          = 0x50aa // байты AA 50 в начале записи
+	PARTITION_MD5_MAGIC      = 0xebeb // запись с MD5 таблицы
+
+	PARTITION_TYPE_APP  = 0x00
+	PARTITION_TYPE_DATA = 0x01
+
+	PARTITION_SUBTYPE_COREDUMP = 0x03
+
+	// Размер блока READ_FLASH_SLOW в ROM загрузчике
+	READ_FLASH_BLOCK_SIZE = 64
+)
+
+// ErrNoPartitionTable - по смещению нет таблицы разделов
+var ErrNoPartitionTable = errors.New("partition table not found")
+
+// Partition - запись таблицы разделов
+type Partition struct {
+	Label   string `json:"label"`
+	Type    byte   `json:"type"`
+	SubType byte   `json:"subType"`
+	Offset  uint32 `json:"offset"`
+	Size    uint32 `json:"size"`
+	Flags   uint32 `json:"flags"`
+}
+
+// ParsePartitionTable разбирает двоичную таблицу разделов
+func ParsePartitionTable(data []byte) ([]Partition, error) {
+	var partitions []Partition
+
+	for pos := 0; pos+PARTITION_ENTRY_SIZE <= len(data); pos += PARTITION_ENTRY_SIZE {
+		entry := data[pos : pos+PARTITION_ENTRY_SIZE]
+		magic := binary.LittleEndian.Uint16(entry[0:2])
+
+		if magic == PARTITION_MD5_MAGIC || magic == 0xffff {
+			break
+		}
+		if magic != PARTITION_MAGIC {
+			if pos == 0 {
+				return nil, ErrNoPartitionTable
+			}
+			return nil, fmt.Errorf("invalid partition table entry %d (magic 0x%04x)", pos/PARTITION_ENTRY_SIZE, magic)
+		}
+
+		label := entry[12:28]
+		if i := bytes.IndexByte(label, 0); i >= 0 {
+			label = label[:i]
+		}
+
+		partitions = append(partitions, Partition{
+			Label:   string(label),
+			Type:    entry[2],
+			SubType: entry[3],
+			Offset:  binary.LittleEndian.Uint32(entry[4:8]),
+			Size:    binary.LittleEndian.Uint32(entry[8:12]),
+			Flags:   binary.LittleEndian.Uint32(entry[28:32]),
+		})
+	}
+
+	if len(partitions) == 0 {
+		return nil, ErrNoPartitionTable
+	}
+	return partitions, nil
+}
+
+// FindPartition ищет первый раздел заданного типа и подтипа
+func FindPartition(partitions []Partition, partType, subType byte) (Partition, bool) {
+	for _, partition := range partitions {
+		if partition.Type == partType && partition.SubType == subType {
+			return partition, true
+		}
+	}
+	return Partition{}, false
+}
+
+// ReadPartitionTable читает таблицу разделов из flash
+func (f *ESP32Flasher) ReadPartitionTable(ctx context.Context) ([]Partition, error) {
+	data, err := f.readFlash(ctx, PARTITION_TABLE_OFFSET, PARTITION_TABLE_MAX_SIZE, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePartitionTable(data)
+}
+
+// readFlash читает flash командой READ_FLASH_SLOW ROM загрузчика блоками по 64 байта.
+// progress, если задан, вызывается с числом прочитанных байт
+func (f *ESP32Flasher) readFlash(ctx context.Context, offset, length uint32, progress func(done uint32)) ([]byte, error) {
+	result := make([]byte, 0, length)
+
+	for uint32(len(result)) < length {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		block := length - uint32(len(result))
+		if block > READ_FLASH_BLOCK_SIZE {
+			block = READ_FLASH_BLOCK_SIZE
+		}
+
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint32(data[0:4], offset+uint32(len(result)))
+		binary.LittleEndian.PutUint32(data[4:8], block)
+
+		if err := f.sendCommand(ESP_READ_FLASH, data, 0); err != nil {
+			return nil, fmt.Errorf("failed to send READ_FLASH command: %w", err)
+		}
+
+		response, err := f.readResponse(ctx, ESP_READ_FLASH, 3*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("timeout waiting for READ_FLASH response: %w", err)
+		}
+		if err := checkResponse(ESP_READ_FLASH, response); err != nil {
+			return nil, err
+		}
+
+		// Данные идут после заголовка, в конце 4 байта статуса
+		if len(response) < 8+int(block)+4 {
+			return nil, fmt.Errorf("READ_FLASH response too short: %d bytes", len(response))
+		}
+		result = append(result, response[8:8+block]...)
+
+		if progress != nil {
+			progress(uint32(len(result)))
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// partitionEntry собирает запись таблицы разделов
+func partitionEntry(label string, partType, subType byte, offset, size uint32) []byte {
+	entry := make([]byte, PARTITION_ENTRY_SIZE)
+	binary.LittleEndian.PutUint16(entry[0:2], PARTITION_MAGIC)
+	entry[2] = partType
+	entry[3] = subType
+	binary.LittleEndian.PutUint32(entry[4:8], offset)
+	binary.LittleEndian.PutUint32(entry[8:12], size)
+	copy(entry[12:28], label)
+	return entry
+}
+
+func TestParsePartitionTable(t *testing.T) {
+	md5 := make([]byte, PARTITION_ENTRY_SIZE)
+	binary.LittleEndian.PutUint16(md5[0:2], PARTITION_MD5_MAGIC)
+	erased := bytes.Repeat([]byte{0xff}, PARTITION_ENTRY_SIZE)
+
+	nvs := partitionEntry("nvs", PARTITION_TYPE_DATA, 0x02, 0x9000, 0x6000)
+	factory := partitionEntry("factory", PARTITION_TYPE_APP, 0x00, 0x10000, 0x100000)
+	coredump := partitionEntry("coredump", PARTITION_TYPE_DATA, PARTITION_SUBTYPE_COREDUMP, 0x110000, 0x10000)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []string
+		wantErr error
+		errText string
+	}{
+		{
+			name: "terminated by MD5 entry",
+			data: concat(nvs, factory, coredump, md5, nvs),
+			want: []string{"nvs", "factory", "coredump"},
+		},
+		{
+			name: "terminated by erased flash",
+			data: concat(nvs, factory, erased),
+			want: []string{"nvs", "factory"},
+		},
+		{
+			name: "incomplete trailing entry is ignored",
+			data: concat(nvs, factory[:10]),
+			want: []string{"nvs"},
+		},
+		{
+			name: "label fills the field",
+			data: partitionEntry("sixteen_chars_lb", PARTITION_TYPE_APP, 0x10, 0x10000, 0x1000),
+			want: []string{"sixteen_chars_lb"},
+		},
+		{
+			name:    "erased flash",
+			data:    bytes.Repeat([]byte{0xff}, PARTITION_TABLE_MAX_SIZE),
+			wantErr: ErrNoPartitionTable,
+		},
+		{
+			name:    "application code at offset",
+			data:    bytes.Repeat([]byte{0xe9, 0x03}, PARTITION_ENTRY_SIZE),
+			wantErr: ErrNoPartitionTable,
+		},
+		{
+			name:    "corrupted entry",
+			data:    concat(nvs, make([]byte, PARTITION_ENTRY_SIZE)),
+			errText: "invalid partition table entry 1 (magic 0x0000)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partitions, err := ParsePartitionTable(tt.data)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case tt.errText != "":
+				if err == nil || err.Error() != tt.errText {
+					t.Fatalf("error = %v, want %q", err, tt.errText)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			labels := make([]string, len(partitions))
+			for i, partition := range partitions {
+				labels[i] = partition.Label
+			}
+			if !reflect.DeepEqual(labels, tt.want) {
+				t.Errorf("labels = %q, want %q", labels, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindPartition(t *testing.T) {
+	partitions, err := ParsePartitionTable(concat(
+		partitionEntry("nvs", PARTITION_TYPE_DATA, 0x02, 0x9000, 0x6000),
+		partitionEntry("coredump", PARTITION_TYPE_DATA, PARTITION_SUBTYPE_COREDUMP, 0x110000, 0x10000),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Partition{Label: "coredump", Type: PARTITION_TYPE_DATA, SubType: PARTITION_SUBTYPE_COREDUMP, Offset: 0x110000, Size: 0x10000}
+	if got, ok := FindPartition(partitions, PARTITION_TYPE_DATA, PARTITION_SUBTYPE_COREDUMP); !ok || got != want {
+		t.Errorf("FindPartition = %+v, %v; want %+v", got, ok, want)
+	}
+	if _, ok := FindPartition(partitions, PARTITION_TYPE_APP, 0x00); ok {
+		t.Error("found app partition that does not exist")
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Владельцы порта
+const (
+	OWNER_FLASHER = "flasher"
+	OWNER_MONITOR = "monitor"
+)
+
+// PortManager владеет всеми открытыми портами приложения и выдает их в аренду.
+// Одним портом одновременно пользуется только один владелец. Владельца, который
+// разрешил вытеснение (монитор), новый арендатор приостанавливает, а после
+// освобождения порта работа вытесненного владельца возобновляется
+type PortManager struct {
+	mu     sync.Mutex
+	leases map[string]*PortLease // по имени порта
+	open   func(name string) (Transport, error)
+}
+
+// PortLease - право исключительного пользования открытым портом
+type PortLease struct {
+	manager *PortManager
+	owner   string
+	name    string
+	port    Transport
+
+	// preempt останавливает владельца при вытеснении и возвращает функцию возобновления.
+	// nil - владельца вытеснить нельзя
+	preempt func() func()
+	// resume возобновляет вытесненного этой арендой владельца после Release
+	resume func()
+	// released - порт закрыт или передан другой аренде
+	released bool
+}
+
+// NewPortManager создает пустой менеджер портов
+func NewPortManager() *PortManager {
+	return &PortManager{leases: map[string]*PortLease{}, open: OpenTransport}
+}
+
+// Acquire открывает порт name для владельца owner. Если порт занят владельцем,
+// которого можно вытеснить, тот приостанавливается до освобождения аренды
+func (m *PortManager) Acquire(name, owner string) (*PortLease, error) {
+	lease := &PortLease{manager: m, owner: owner, name: name}
+
+	m.mu.Lock()
+	current := m.leases[name]
+	if current != nil && current.preempt == nil {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("port %s is busy (%s)", name, current.owner)
+	}
+	// Место занимаем сразу, чтобы параллельный Acquire получил отказ
+	m.leases[name] = lease
+	if current != nil {
+		current.released = true
+	}
+	m.mu.Unlock()
+
+	// Вытесненный владелец останавливается до закрытия его канала
+	if current != nil {
+		lease.resume = current.preempt()
+		current.port.Close()
+	}
+
+	port, err := m.open(name)
+	if err != nil {
+		m.mu.Lock()
+		delete(m.leases, name)
+		m.mu.Unlock()
+
+		if lease.resume != nil {
+			lease.resume()
+		}
+		return nil, err
+	}
+	lease.port = port
+
+	return lease, nil
+}
+
+// Port возвращает открытый канал аренды
+func (l *PortLease) Port() Transport {
+	return l.port
+}
+
+// Name возвращает текущее имя порта, оно меняется после Reopen
+func (l *PortLease) Name() string {
+	return l.name
+}
+
+// SetPreempt разрешает вытеснять владельца аренды. preempt должен остановить
+// работу с каналом и вернуть функцию возобновления; канал закрывает менеджер
+func (l *PortLease) SetPreempt(preempt func() func()) {
+	l.manager.mu.Lock()
+	defer l.manager.mu.Unlock()
+	l.preempt = preempt
+}
+
+// Reopen открывает порт заново, возможно под новым именем (после переподключения USB).
+// Старый канал должен быть уже закрыт
+func (l *PortLease) Reopen(name string) (Transport, error) {
+	m := l.manager
+
+	m.mu.Lock()
+	if l.released {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("port %s lease is released", l.name)
+	}
+	if other := m.leases[name]; other != nil && other != l {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("port %s is busy (%s)", name, other.owner)
+	}
+	m.mu.Unlock()
+
+	port, err := m.open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	if m.leases[l.name] == l {
+		delete(m.leases, l.name)
+	}
+	m.leases[name] = l
+	l.name = name
+	l.port = port
+	m.mu.Unlock()
+
+	return port, nil
+}
+
+// Handover передает открытый канал новому владельцу без закрытия. Прежняя аренда
+// становится недействительной; вытесненный ею владелец не возобновляется, так как
+// порт уже занят новым
+func (l *PortLease) Handover(owner string) *PortLease {
+	m := l.manager
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	next := &PortLease{manager: m, owner: owner, name: l.name, port: l.port}
+	l.released = true
+	l.resume = nil
+	m.leases[l.name] = next
+
+	return next
+}
+
+// Release закрывает канал и освобождает порт. Повторный вызов ничего не делает
+func (l *PortLease) Release() error {
+	m := l.manager
+
+	m.mu.Lock()
+	if l.released {
+		m.mu.Unlock()
+		return nil
+	}
+	l.released = true
+	if m.leases[l.name] == l {
+		delete(m.leases, l.name)
+	}
+	resume := l.resume
+	l.resume = nil
+	m.mu.Unlock()
+
+	err := l.port.Close()
+
+	if resume != nil {
+		resume()
+	}
+	return err
+}
+
+// CloseAll освобождает все порты при завершении приложения без возобновления владельцев
+func (m *PortManager) CloseAll() {
+	m.mu.Lock()
+	leases := m.leases
+	m.leases = map[string]*PortLease{}
+	for _, lease := range leases {
+		lease.released = true
+		lease.resume = nil
+	}
+	m.mu.Unlock()
+
+	for _, lease := range leases {
+		if lease.port != nil {
+			lease.port.Close()
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import "testing"
+
+// testPortManager - менеджер, который вместо портов открывает пары PipeTransport.
+// devices - стороны устройства в порядке открытия
+func testPortManager() (*PortManager, *[]*PipeTransport) {
+	devices := &[]*PipeTransport{}
+	m := NewPortManager()
+	m.open = func(string) (Transport, error) {
+		host, device := NewPipeTransport()
+		*devices = append(*devices, device)
+		return host, nil
+	}
+	return m, devices
+}
+
+// pipeClosed сообщает, что сторона хоста закрыла канал
+func pipeClosed(device *PipeTransport) bool {
+	_, err := device.Write([]byte{0})
+	return err != nil
+}
+
+// preemptibleMonitor арендует порт как монитор и считает остановки и возобновления
+func preemptibleMonitor(t *testing.T, m *PortManager) (lease *PortLease, paused, resumed *int) {
+	t.Helper()
+
+	lease, err := m.Acquire("COM1", OWNER_MONITOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paused, resumed = new(int), new(int)
+	lease.SetPreempt(func() func() {
+		*paused++
+		return func() { *resumed++ }
+	})
+	return lease, paused, resumed
+}
+
+func TestPortManagerFlasherPreemptsMonitor(t *testing.T) {
+	m, devices := testPortManager()
+	monitor, paused, resumed := preemptibleMonitor(t, m)
+
+	flasher, err := m.Acquire("COM1", OWNER_FLASHER)
+	if err != nil {
+		t.Fatalf("flasher did not preempt the monitor: %v", err)
+	}
+	if *paused != 1 || *resumed != 0 {
+		t.Fatalf("paused %d, resumed %d; want 1, 0", *paused, *resumed)
+	}
+	if !pipeClosed((*devices)[0]) {
+		t.Error("monitor port left open after preemption")
+	}
+	if flasher.Port() == monitor.Port() {
+		t.Error("flasher reuses the monitor port")
+	}
+
+	// Прошивку вытеснить нельзя
+	if _, err := m.Acquire("COM1", OWNER_MONITOR); err == nil {
+		t.Error("port acquired while the flasher holds it")
+	}
+
+	if err := flasher.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if *resumed != 1 {
+		t.Errorf("monitor resumed %d times after Release, want 1", *resumed)
+	}
+	if !pipeClosed((*devices)[1]) {
+		t.Error("flasher port left open after Release")
+	}
+
+	// Вытесненная аренда уже недействительна, ее Release ничего не делает
+	if err := monitor.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if *resumed != 1 {
+		t.Errorf("stale monitor lease resumed %d times, want 1", *resumed)
+	}
+	if _, err := m.Acquire("COM1", OWNER_FLASHER); err != nil {
+		t.Errorf("port is not free after Release: %v", err)
+	}
+}
+
+func TestPortManagerHandover(t *testing.T) {
+	m, devices := testPortManager()
+	_, paused, resumed := preemptibleMonitor(t, m)
+
+	flasher, err := m.Acquire("COM1", OWNER_FLASHER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *paused != 1 {
+		t.Fatalf("paused %d, want 1", *paused)
+	}
+
+	// Монитор после прошивки получает тот же открытый канал
+	next := flasher.Handover(OWNER_MONITOR)
+	device := (*devices)[1]
+	if next.Port() != flasher.Port() {
+		t.Error("handover opened a new port")
+	}
+	if pipeClosed(device) {
+		t.Fatal("handover closed the port")
+	}
+
+	// Прежняя аренда недействительна: Release не закрывает канал и не
+	// возобновляет вытесненный монитор, порт уже занят новым
+	if err := flasher.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if pipeClosed(device) {
+		t.Error("release of the handed over lease closed the port")
+	}
+	if *resumed != 0 {
+		t.Errorf("preempted monitor resumed %d times, want 0", *resumed)
+	}
+	if _, err := m.Acquire("COM1", OWNER_FLASHER); err == nil {
+		t.Error("port acquired while the new lease holds it")
+	}
+
+	if err := next.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if !pipeClosed(device) {
+		t.Error("port left open after Release of the new lease")
+	}
+	if *resumed != 0 {
+		t.Errorf("preempted monitor resumed %d times, want 0", *resumed)
+	}
+}
+
+func TestPortManagerBusy(t *testing.T) {
+	m, _ := testPortManager()
+
+	// Без SetPreempt владельца вытеснить нельзя
+	if _, err := m.Acquire("COM1", OWNER_MONITOR); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Acquire("COM1", OWNER_FLASHER); err == nil {
+		t.Error("non-preemptible lease was preempted")
+	}
+	if _, err := m.Acquire("COM2", OWNER_FLASHER); err != nil {
+		t.Errorf("other port is busy: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Период опроса списка портов
+const PORT_WATCH_INTERVAL = time.Second
+
+// PortsChange - изменение списка портов
+type PortsChange struct {
+	Ports    []PortInfo `json:"ports"`
+	Added    []PortInfo `json:"added"`
+	Removed  []PortInfo `json:"removed"`
+	Selected string     `json:"selected"` // новая плата, которую нужно выбрать; пусто - не менять выбор
+}
+
+// PortWatcher опрашивает список портов и сообщает о подключении и отключении устройств.
+// Опрос работает одинаково на всех платформах и не требует прав на udev
+type PortWatcher struct {
+	mu         sync.Mutex
+	autoSelect bool
+	known      map[string]PortInfo
+
+	onChange func(PortsChange)
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewPortWatcher запускает опрос портов. Текущие порты считаются уже известными
+func NewPortWatcher(onChange func(PortsChange)) *PortWatcher {
+	w := &PortWatcher{
+		known:    map[string]PortInfo{},
+		onChange: onChange,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if ports, err := DetectPorts(); err == nil {
+		for _, port := range ports {
+			w.known[port.Name] = port
+		}
+	}
+
+	go w.run()
+	return w
+}
+
+// SetAutoSelect включает автоматический выбор только что подключенной платы ESP
+func (w *PortWatcher) SetAutoSelect(enabled bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.autoSelect = enabled
+}
+
+// Stop останавливает опрос
+func (w *PortWatcher) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *PortWatcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(PORT_WATCH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if change, ok := w.poll(); ok {
+				w.onChange(change)
+			}
+		}
+	}
+}
+
+// poll сравнивает текущий список портов с известным
+func (w *PortWatcher) poll() (PortsChange, bool) {
+	ports, err := DetectPorts()
+	if err != nil {
+		return PortsChange{}, false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	change := PortsChange{Ports: ports}
+	current := make(map[string]PortInfo, len(ports))
+	for _, port := range ports {
+		current[port.Name] = port
+		// Порт с тем же именем, но другим устройством считается новым
+		if old, ok := w.known[port.Name]; !ok || old.SerialNumber != port.SerialNumber {
+			change.Added = append(change.Added, port)
+		}
+	}
+	for name, port := range w.known {
+		if replaced, ok := current[name]; !ok || replaced.SerialNumber != port.SerialNumber {
+			change.Removed = append(change.Removed, port)
+		}
+	}
+	w.known = current
+
+	if len(change.Added) == 0 && len(change.Removed) == 0 {
+		return PortsChange{}, false
+	}
+
+	if w.autoSelect {
+		for _, port := range change.Added {
+			if isESPBoardBridge(port.Bridge) {
+				change.Selected = port.Name
+				break
+			}
+		}
+	}
+
+	return change, true
+}
+
+// isESPBoardBridge сообщает, что через такой мост обычно подключены платы ESP
+func isESPBoardBridge(bridge string) bool {
+	switch bridge {
+	case BRIDGE_CP210X, BRIDGE_CH34X, BRIDGE_FTDI, BRIDGE_ESPRESSIF:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"go.bug.st/serial/enumerator"
+)
+
+// Типы USB-UART мостов, по которым оператор отличает платы
+const (
+	BRIDGE_UNKNOWN   = ""
+	BRIDGE_CP210X    = "cp210x"
+	BRIDGE_CH34X     = "ch34x"
+	BRIDGE_FTDI      = "ftdi"
+	BRIDGE_ESPRESSIF = "espressif" // встроенный USB чипа (USB-Serial/JTAG или USB-OTG CDC)
+	BRIDGE_EMULATOR  = "emulator"
+)
+
+// PortInfo - сведения о последовательном порте для выбора в UI
+type PortInfo struct {
+	Name         string `json:"name"`
+	IsUSB        bool   `json:"isUsb"`
+	VID          string `json:"vid"`
+	PID          string `json:"pid"`
+	SerialNumber string `json:"serialNumber"`
+	Product      string `json:"product"`
+	Bridge       string `json:"bridge"`     // BRIDGE_*
+	BridgeName   string `json:"bridgeName"` // модель моста, например "CP2102N" или "CH340"
+	Label        string `json:"label"`      // подпись для списка портов
+}
+
+// usbBridge - известный USB-UART мост
+type usbBridge struct {
+	bridge string
+	name   string
+}
+
+// Мосты по VID:PID. Если PID неизвестен, используется запись с пустым PID
+var usbBridges = map[string]usbBridge{
+	"10C4:EA60": {BRIDGE_CP210X, "CP210x"},
+	"10C4:EA70": {BRIDGE_CP210X, "CP2105"},
+	"10C4:EA71": {BRIDGE_CP210X, "CP2108"},
+	"10C4:":     {BRIDGE_CP210X, "Silicon Labs"},
+	"1A86:7523": {BRIDGE_CH34X, "CH340"},
+	"1A86:5523": {BRIDGE_CH34X, "CH341"},
+	"1A86:55D3": {BRIDGE_CH34X, "CH343"},
+	"1A86:55D4": {BRIDGE_CH34X, "CH9102"},
+	"1A86:":     {BRIDGE_CH34X, "WCH"},
+	"0403:6001": {BRIDGE_FTDI, "FT232R"},
+	"0403:6010": {BRIDGE_FTDI, "FT2232"},
+	"0403:6014": {BRIDGE_FTDI, "FT232H"},
+	"0403:6015": {BRIDGE_FTDI, "FT231X"},
+	"0403:":     {BRIDGE_FTDI, "FTDI"},
+	"303A:1001": {BRIDGE_ESPRESSIF, "USB-Serial/JTAG"},
+	"303A:":     {BRIDGE_ESPRESSIF, "Espressif USB"},
+}
+
+// detectBridge определяет мост по VID/PID
+func detectBridge(vid, pid string) usbBridge {
+	vid, pid = strings.ToUpper(vid), strings.ToUpper(pid)
+	if bridge, ok := usbBridges[vid+":"+pid]; ok {
+		return bridge
+	}
+	return usbBridges[vid+":"]
+}
+
+// newPortInfo собирает PortInfo из сведений перечислителя
+func newPortInfo(details *enumerator.PortDetails) PortInfo {
+	info := PortInfo{Name: details.Name, IsUSB: details.IsUSB}
+	if details.IsUSB {
+		info.VID = strings.ToUpper(details.VID)
+		info.PID = strings.ToUpper(details.PID)
+		info.SerialNumber = details.SerialNumber
+		info.Product = details.Product
+
+		bridge := detectBridge(info.VID, info.PID)
+		info.Bridge = bridge.bridge
+		info.BridgeName = bridge.name
+	}
+	info.Label = portLabel(info)
+	return info
+}
+
+// portLabel формирует подпись вида "/dev/ttyUSB0 — CP210x · SN 0001"
+func portLabel(info PortInfo) string {
+	var parts []string
+	switch {
+	case info.BridgeName != "":
+		parts = append(parts, info.BridgeName)
+	case info.IsUSB:
+		parts = append(parts, fmt.Sprintf("USB %s:%s", info.VID, info.PID))
+	}
+	if info.Product != "" && (info.BridgeName == "" || !strings.Contains(info.Product, info.BridgeName)) {
+		parts = append(parts, info.Product)
+	}
+	if info.SerialNumber != "" {
+		parts = append(parts, "SN "+info.SerialNumber)
+	}
+
+	if len(parts) == 0 {
+		return info.Name
+	}
+	return info.Name + " — " + strings.Join(parts, " · ")
+}
+
+// DetectPorts возвращает подробный список портов, отсортированный по имени. Если задана
+// переменная окружения ESPFLASHER_EMULATOR, в список добавляется встроенный эмулятор ESP32
+func DetectPorts() ([]PortInfo, error) {
+	details, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make([]PortInfo, 0, len(details)+1)
+	for _, port := range details {
+		ports = append(ports, newPortInfo(port))
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+
+	if os.Getenv("ESPFLASHER_EMULATOR") != "" {
+		emulator := PortInfo{Name: EMULATOR_PORT_NAME, Bridge: BRIDGE_EMULATOR, BridgeName: "Эмулятор ESP32"}
+		emulator.Label = portLabel(emulator)
+		ports = append(ports, emulator)
+	}
+
+	return ports, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Именованные стратегии перевода в режим загрузки. Пустая строка - автоматический
+// выбор: эталонная последовательность, затем инвертированная
+const (
+	RESET_AUTO     = ""
+	RESET_CLASSIC  = "classic"  // эталонная последовательность esp-serial-flasher (DTR -> GPIO0, RTS -> EN)
+	RESET_INVERTED = "inverted" // то же с инвертированными DTR/RTS
+	RESET_USB_JTAG = "usb-jtag" // встроенный USB-Serial/JTAG (ESP32-S3, C3)
+	RESET_NONE     = "no-reset" // чип уже в режиме загрузки
+	RESET_MANUAL   = "manual"   // пользователь сам нажимает BOOT и RESET
+
+	// Максимальная пауза в шаге W, мс
+	RESET_MAX_WAIT_MS = 10000
+
+	// Сколько ждать ручного перевода в режим загрузки
+	MANUAL_RESET_TIMEOUT = 60 * time.Second
+)
+
+// Последовательность USBJTAGSerialReset из esptool: сброс проходит через состояние
+// (1,1), а не (0,0), потому что встроенный USB трактует линии иначе, чем мост
+const USB_JTAG_RESET_SEQUENCE = "R0|D0|W100|D1|R0|W100|R1|D0|R1|W100|D0|R0"
+
+// ResetStep - один шаг последовательности сброса
+type ResetStep struct {
+	Line  byte // 'D' - DTR, 'R' - RTS, 'W' - пауза
+	Value int  // уровень линии (0/1) или пауза в мс
+}
+
+func (s ResetStep) String() string {
+	return fmt.Sprintf("%c%d", s.Line, s.Value)
+}
+
+// ParseResetSequence разбирает последовательность вида "D0|R1|W100|D1|R0|W50|D0":
+// Dn задает DTR, Rn - RTS, Wn - пауза n миллисекунд
+func ParseResetSequence(sequence string) ([]ResetStep, error) {
+	var steps []ResetStep
+
+	for i, token := range strings.Split(sequence, "|") {
+		token = strings.ToUpper(strings.TrimSpace(token))
+		if len(token) < 2 {
+			return nil, fmt.Errorf("reset step %d: %q is too short", i+1, token)
+		}
+
+		value, err := strconv.Atoi(token[1:])
+		if err != nil {
+			return nil, fmt.Errorf("reset step %d: invalid value in %q", i+1, token)
+		}
+
+		switch token[0] {
+		case 'D', 'R':
+			if value != 0 && value != 1 {
+				return nil, fmt.Errorf("reset step %d: line level must be 0 or 1, got %q", i+1, token)
+			}
+		case 'W':
+			if value < 0 || value > RESET_MAX_WAIT_MS {
+				return nil, fmt.Errorf("reset step %d: wait must be 0..%d ms, got %q", i+1, RESET_MAX_WAIT_MS, token)
+			}
+		default:
+			return nil, fmt.Errorf("reset step %d: unknown step %q (expected D, R or W)", i+1, token)
+		}
+
+		steps = append(steps, ResetStep{Line: token[0], Value: value})
+	}
+
+	return steps, nil
+}
+
+// ValidateResetStrategy проверяет имя стратегии или пользовательскую последовательность
+func ValidateResetStrategy(strategy string) error {
+	switch strategy {
+	case RESET_AUTO, RESET_CLASSIC, RESET_INVERTED, RESET_USB_JTAG, RESET_NONE, RESET_MANUAL:
+		return nil
+	}
+	if _, err := ParseResetSequence(strategy); err != nil {
+		return fmt.Errorf("unknown reset strategy %q: %w", strategy, err)
+	}
+	return nil
+}
+
+// runResetSequence выполняет шаги сброса на линиях порта
+func (f *ESP32Flasher) runResetSequence(ctx context.Context, steps []ResetStep) error {
+	for _, step := range steps {
+		var err error
+		switch step.Line {
+		case 'D':
+			err = f.port.SetDTR(step.Value == 1)
+		case 'R':
+			err = f.port.SetRTS(step.Value == 1)
+		case 'W':
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(step.Value) * time.Millisecond):
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("reset step %s failed: %w", step, err)
+		}
+	}
+	return nil
+}
+
+// resetWithStrategy выполняет выбранную стратегию сброса. Проверка режима
+// загрузки выполняется вызывающим кодом
+func (f *ESP32Flasher) resetWithStrategy(ctx context.Context, strategy string) error {
+	switch strategy {
+	case RESET_CLASSIC:
+		f.espressifReferenceReset()
+		return nil
+
+	case RESET_INVERTED:
+		f.espressifReferenceResetInverted()
+		return nil
+
+	case RESET_USB_JTAG:
+		// Серийный номер запоминаем до сброса: после переподключения имя порта может измениться
+		serialNumber := f.usbSerialNumber()
+		steps, _ := ParseResetSequence(USB_JTAG_RESET_SEQUENCE)
+		if err := f.runSequenceLogged(ctx, "🔄 Сброс USB-Serial/JTAG...", steps); err != nil {
+			return err
+		}
+		return f.reopenAfterReenumeration(ctx, serialNumber)
+
+	case RESET_NONE, RESET_MANUAL:
+		return nil
+
+	default:
+		steps, err := ParseResetSequence(strategy)
+		if err != nil {
+			return err
+		}
+		return f.runSequenceLogged(ctx, fmt.Sprintf("🔄 Пользовательская последовательность сброса: %s", strategy), steps)
+	}
+}
+
+// runSequenceLogged выполняет последовательность с очисткой буферов до и после
+func (f *ESP32Flasher) runSequenceLogged(ctx context.Context, title string, steps []ResetStep) error {
+	if f.callback != nil {
+		f.callback.emitLog(title)
+	}
+
+	f.resetBuffers()
+	if err := f.runResetSequence(ctx, steps); err != nil {
+		return err
+	}
+	f.resetBuffers()
+
+	// Задержка для стабилизации ESP32 после сброса, как в эталонной последовательности
+	time.Sleep(200 * time.Millisecond)
+	return nil
+}
+
+// enterBootloaderWithStrategy переводит ESP32 в режим загрузки заданной стратегией
+func (f *ESP32Flasher) enterBootloaderWithStrategy(ctx context.Context, strategy string) error {
+	if strategy == RESET_MANUAL {
+		return f.waitManualBootloader(ctx)
+	}
+
+	if f.callback != nil {
+		f.callback.emitLog(fmt.Sprintf("🔄 Перевод ESP32 в режим загрузки (стратегия %s)...", strategy))
+	}
+
+	if err := f.resetWithStrategy(ctx, strategy); err != nil {
+		return err
+	}
+
+	if f.testBootloaderMode(ctx) {
+		if f.callback != nil {
+			f.callback.emitLog("✅ ESP32 успешно переведен в режим bootloader")
+		}
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if f.callback != nil {
+		f.callback.emitLog(fmt.Sprintf("❌ Стратегия сброса %s не перевела ESP32 в режим bootloader", strategy))
+		f.callback.emitLog("💡 Выберите другую стратегию сброса или ручной режим")
+	}
+
+	return fmt.Errorf("failed to enter bootloader mode with %s reset", strategy)
+}
+
+// waitManualBootloader просит пользователя перевести чип в режим загрузки кнопками
+// и ждет, пока загрузчик ответит на SYNC
+func (f *ESP32Flasher) waitManualBootloader(ctx context.Context) error {
+	if f.callback != nil {
+		f.callback.emitLog("✋ Ручной перевод в режим загрузки:")
+		f.callback.emitLog("   1. Удерживайте кнопку BOOT (GPIO0)")
+		f.callback.emitLog("   2. Нажмите и отпустите кнопку RESET (EN)")
+		f.callback.emitLog("   3. Отпустите кнопку BOOT")
+		f.callback.emitProgress(20, "Ожидание ручного перевода в режим загрузки...")
+	}
+
+	deadline := time.Now().Add(MANUAL_RESET_TIMEOUT)
+	for time.Now().Before(deadline) {
+		if f.testBootloaderMode(ctx) {
+			if f.callback != nil {
+				f.callback.emitLog("✅ ESP32 в режиме bootloader")
+			}
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("bootloader mode was not entered within %v", MANUAL_RESET_TIMEOUT)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseResetSequence(t *testing.T) {
+	tests := []struct {
+		name     string
+		sequence string
+		want     string // шаги через "|" в каноническом виде
+		wantErr  string
+	}{
+		{name: "classic", sequence: "D0|R1|W100|D1|R0|W50|D0", want: "D0|R1|W100|D1|R0|W50|D0"},
+		{name: "lower case and spaces", sequence: " d1 | r0 |w0", want: "D1|R0|W0"},
+		{name: "usb jtag", sequence: USB_JTAG_RESET_SEQUENCE, want: USB_JTAG_RESET_SEQUENCE},
+		{name: "max wait", sequence: "W10000", want: "W10000"},
+		{name: "empty", sequence: "", wantErr: `reset step 1: "" is too short`},
+		{name: "empty step", sequence: "D0||R1", wantErr: `reset step 2: "" is too short`},
+		{name: "missing value", sequence: "D", wantErr: `reset step 1: "D" is too short`},
+		{name: "invalid value", sequence: "D0|Rx", wantErr: `reset step 2: invalid value in "RX"`},
+		{name: "line level", sequence: "D2", wantErr: `reset step 1: line level must be 0 or 1, got "D2"`},
+		{name: "wait too long", sequence: "W10001", wantErr: `reset step 1: wait must be 0..10000 ms, got "W10001"`},
+		{name: "negative wait", sequence: "W-1", wantErr: `reset step 1: wait must be 0..10000 ms, got "W-1"`},
+		{name: "unknown line", sequence: "D0|X1", wantErr: `reset step 2: unknown step "X1" (expected D, R or W)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := ParseResetSequence(tt.sequence)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			parts := make([]string, len(steps))
+			for i, step := range steps {
+				parts[i] = step.String()
+			}
+			if got := strings.Join(parts, "|"); got != tt.want {
+				t.Errorf("steps = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateResetStrategy(t *testing.T) {
+	for _, strategy := range []string{RESET_AUTO, RESET_CLASSIC, RESET_INVERTED, RESET_USB_JTAG, RESET_NONE, RESET_MANUAL, "D0|W10|D1"} {
+		if err := ValidateResetStrategy(strategy); err != nil {
+			t.Errorf("ValidateResetStrategy(%q) = %v", strategy, err)
+		}
+	}
+
+	err := ValidateResetStrategy("fast")
+	if err == nil || !strings.HasPrefix(err.Error(), `unknown reset strategy "fast": `) {
+		t.Errorf("ValidateResetStrategy(\"fast\") = %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Коды ошибок ROM загрузчика (esptool: ROM_INVALID_RECV_MSG и далее)
+const (
+	ROM_ERR_INVALID_MESSAGE   = 0x05
+	ROM_ERR_FAILED_TO_ACT     = 0x06
+	ROM_ERR_INVALID_CRC       = 0x07
+	ROM_ERR_FLASH_WRITE       = 0x08
+	ROM_ERR_FLASH_READ        = 0x09
+	ROM_ERR_FLASH_READ_LENGTH = 0x0a
+	ROM_ERR_DEFLATE           = 0x0b
+)
+
+// Коды ошибок stub загрузчика (esp_loader_error.h из esptool)
+const (
+	STUB_ERR_BAD_DATA_LEN      = 0xc0
+	STUB_ERR_BAD_DATA_CHECKSUM = 0xc1
+	STUB_ERR_BAD_BLOCKSIZE     = 0xc2
+	STUB_ERR_INVALID_COMMAND   = 0xc3
+	STUB_ERR_FAILED_SPI_OP     = 0xc4
+	STUB_ERR_FAILED_SPI_UNLOCK = 0xc5
+	STUB_ERR_NOT_IN_FLASH_MODE = 0xc6
+	STUB_ERR_INFLATE           = 0xc7
+	STUB_ERR_NOT_ENOUGH_DATA   = 0xc8
+	STUB_ERR_TOO_MUCH_DATA     = 0xc9
+	STUB_ERR_CMD_NOT_IMPL      = 0xff
+)
+
+// Ошибки загрузчика для сравнения через errors.Is
+var (
+	ErrInvalidMessage    = errors.New("invalid message")
+	ErrFailedToAct       = errors.New("failed to act on message")
+	ErrInvalidCRC        = errors.New("invalid CRC")
+	ErrFlashWrite        = errors.New("flash write error")
+	ErrFlashRead         = errors.New("flash read error")
+	ErrFlashReadLength   = errors.New("flash read length error")
+	ErrDeflate           = errors.New("deflate error")
+	ErrBadDataLen        = errors.New("bad data length")
+	ErrBadDataChecksum   = errors.New("bad data checksum")
+	ErrBadBlocksize      = errors.New("bad block size")
+	ErrInvalidCommand    = errors.New("invalid command")
+	ErrFailedSPIOp       = errors.New("SPI operation failed")
+	ErrFailedSPIUnlock   = errors.New("SPI unlock failed")
+	ErrNotInFlashMode    = errors.New("not in flash mode")
+	ErrInflate           = errors.New("inflate error")
+	ErrNotEnoughData     = errors.New("not enough data")
+	ErrTooMuchData       = errors.New("too much data")
+	ErrCmdNotImplemented = errors.New("command not implemented")
+	ErrUnknownLoader     = errors.New("unknown loader error")
+)
+
+// romErrorInfo - описание кода ошибки для пользователя
+type romErrorInfo struct {
+	err         error
+	explanation string
+	hint        string
+}
+
+var romErrorCodes = map[byte]romErrorInfo{
+	ROM_ERR_INVALID_MESSAGE: {ErrInvalidMessage,
+		"Загрузчик не смог разобрать команду: неверный размер или формат пакета",
+		"Проверьте, что выбран правильный чип и скорость порта; при помехах на линии используйте более короткий кабель"},
+	ROM_ERR_FAILED_TO_ACT: {ErrFailedToAct,
+		"Загрузчик принял команду, но не смог ее выполнить (например, адрес вне flash или нарушен порядок пакетов)",
+		"Проверьте адрес записи и размер образа; переподключите плату и повторите прошивку с начала"},
+	ROM_ERR_INVALID_CRC: {ErrInvalidCRC,
+		"Контрольная сумма блока данных не совпала: данные повреждены при передаче",
+		"Снизьте скорость порта или замените USB-кабель; блок будет отправлен повторно"},
+	ROM_ERR_FLASH_WRITE: {ErrFlashWrite,
+		"Ошибка записи во flash: микросхема не подтвердила запись",
+		"Проверьте питание платы (просадки при записи), защиту от записи и исправность flash"},
+	ROM_ERR_FLASH_READ: {ErrFlashRead,
+		"Ошибка чтения flash",
+		"Проверьте подключение SPI flash и режим (QIO/DIO); попробуйте другую плату"},
+	ROM_ERR_FLASH_READ_LENGTH: {ErrFlashReadLength,
+		"Запрошена неверная длина чтения flash",
+		"Уменьшите размер читаемого блока; ROM загрузчик читает не более 64 байт за раз"},
+	ROM_ERR_DEFLATE: {ErrDeflate,
+		"Ошибка распаковки сжатых данных",
+		"Отключите сжатие или проверьте целостность файла прошивки"},
+	STUB_ERR_BAD_DATA_LEN: {ErrBadDataLen,
+		"Длина данных в пакете не совпадает с заявленной",
+		"Повторите прошивку; при повторении снизьте скорость порта"},
+	STUB_ERR_BAD_DATA_CHECKSUM: {ErrBadDataChecksum,
+		"Контрольная сумма данных не совпала",
+		"Снизьте скорость порта или замените USB-кабель; блок будет отправлен повторно"},
+	STUB_ERR_BAD_BLOCKSIZE: {ErrBadBlocksize,
+		"Размер блока не поддерживается загрузчиком",
+		"Используйте стандартный размер блока 4 КБ"},
+	STUB_ERR_INVALID_COMMAND: {ErrInvalidCommand,
+		"Загрузчик не знает эту команду",
+		"Команда не поддерживается этим чипом или версией загрузчика"},
+	STUB_ERR_FAILED_SPI_OP: {ErrFailedSPIOp,
+		"Не удалось выполнить операцию SPI flash",
+		"Проверьте подключение и питание SPI flash"},
+	STUB_ERR_FAILED_SPI_UNLOCK: {ErrFailedSPIUnlock,
+		"Не удалось снять защиту записи SPI flash",
+		"Flash может быть защищена битами статуса; снимите защиту или замените микросхему"},
+	STUB_ERR_NOT_IN_FLASH_MODE: {ErrNotInFlashMode,
+		"Пакет данных получен без начала сессии записи",
+		"Повторите прошивку с начала: сессия FLASH_BEGIN была потеряна"},
+	STUB_ERR_INFLATE: {ErrInflate,
+		"Ошибка распаковки сжатых данных",
+		"Проверьте целостность файла прошивки"},
+	STUB_ERR_NOT_ENOUGH_DATA: {ErrNotEnoughData,
+		"Получено меньше данных, чем было объявлено",
+		"Повторите прошивку; проверьте, что передача не прерывалась"},
+	STUB_ERR_TOO_MUCH_DATA: {ErrTooMuchData,
+		"Получено больше данных, чем было объявлено",
+		"Проверьте размер образа, переданный в FLASH_BEGIN"},
+	STUB_ERR_CMD_NOT_IMPL: {ErrCmdNotImplemented,
+		"Команда не реализована в загрузчике",
+		"Эта функция недоступна для данного чипа или загрузчика"},
+}
+
+// commandNames - имена команд для сообщений об ошибках
+var commandNames = map[byte]string{
+	ESP_FLASH_BEGIN: "FLASH_BEGIN",
+	ESP_FLASH_DATA:  "FLASH_DATA",
+	ESP_FLASH_END:   "FLASH_END",
+	ESP_MEM_BEGIN:   "MEM_BEGIN",
+	ESP_MEM_END:     "MEM_END",
+	ESP_MEM_DATA:    "MEM_DATA",
+	ESP_SYNC:        "SYNC",
+	ESP_WRITE_REG:   "WRITE_REG",
+	ESP_READ_REG:    "READ_REG",
+	ESP_SPI_PARAMS:  "SPI_SET_PARAMS",
+	ESP_SPI_ATTACH:  "SPI_ATTACH",
+	ESP_READ_FLASH:  "READ_FLASH",
+	ESP_SPI_MD5:     "SPI_FLASH_MD5",
+}
+
+// commandName возвращает имя команды загрузчика
+func commandName(cmd byte) string {
+	if name, ok := commandNames[cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("command 0x%02x", cmd)
+}
+
+// ROMError - ошибка, которую вернул загрузчик в статусе ответа
+type ROMError struct {
+	Command byte
+	Status  byte
+	Code    byte
+}
+
+func (e *ROMError) Error() string {
+	return fmt.Sprintf("%s failed: %v (status=%d, error=0x%02x)", commandName(e.Command), e.Unwrap(), e.Status, e.Code)
+}
+
+// Unwrap возвращает ошибку-признак, поэтому работает errors.Is(err, ErrInvalidCRC)
+func (e *ROMError) Unwrap() error {
+	if info, ok := romErrorCodes[e.Code]; ok {
+		return info.err
+	}
+	return ErrUnknownLoader
+}
+
+// Explanation объясняет, что означает ошибка
+func (e *ROMError) Explanation() string {
+	if info, ok := romErrorCodes[e.Code]; ok {
+		return info.explanation
+	}
+	return fmt.Sprintf("Загрузчик вернул неизвестный код ошибки 0x%02x", e.Code)
+}
+
+// Hint предлагает, как исправить ошибку
+func (e *ROMError) Hint() string {
+	if info, ok := romErrorCodes[e.Code]; ok {
+		return info.hint
+	}
+	return "Сохраните трассу протокола и сообщите о проблеме"
+}
+
+// Retryable сообщает, имеет ли смысл повторить команду: данные повреждены при передаче
+func (e *ROMError) Retryable() bool {
+	switch e.Code {
+	case ROM_ERR_INVALID_CRC, STUB_ERR_BAD_DATA_CHECKSUM, STUB_ERR_BAD_DATA_LEN:
+		return true
+	}
+	return false
+}
+
+// checkResponse проверяет заголовок ответа на команду cmd и статус в его конце.
+// ESP32 ROM загрузчик передает 4 байта статуса (stub - 2), первый - статус, второй - код ошибки
+func checkResponse(cmd byte, response []byte) error {
+	if len(response) < 8 || response[0] != 0x01 || response[1] != cmd {
+		if len(response) < 2 {
+			return fmt.Errorf("invalid %s response (len=%d)", commandName(cmd), len(response))
+		}
+		return fmt.Errorf("invalid %s response (len=%d, dir=0x%02x, cmd=0x%02x)", commandName(cmd), len(response), response[0], response[1])
+	}
+
+	if len(response) >= 12 {
+		status := response[len(response)-4]
+		if status != 0x00 {
+			return &ROMError{Command: cmd, Status: status, Code: response[len(response)-3]}
+		}
+	}
+
+	return nil
+}
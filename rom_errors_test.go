@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// romResponse собирает ответ загрузчика на cmd с 4 байтами статуса ESP32 ROM
+func romResponse(cmd byte, status, code byte) []byte {
+	return []byte{0x01, cmd, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, status, code, 0x00, 0x00}
+}
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		cmd       byte
+		response  []byte
+		wantErr   string
+		wantIs    error
+		wantRetry bool
+	}{
+		{
+			name:     "success",
+			cmd:      ESP_FLASH_DATA,
+			response: romResponse(ESP_FLASH_DATA, 0, 0),
+		},
+		{
+			name:     "short response without status",
+			cmd:      ESP_SYNC,
+			response: []byte{0x01, ESP_SYNC, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			name:      "invalid CRC",
+			cmd:       ESP_FLASH_DATA,
+			response:  romResponse(ESP_FLASH_DATA, 1, ROM_ERR_INVALID_CRC),
+			wantErr:   "FLASH_DATA failed: invalid CRC (status=1, error=0x07)",
+			wantIs:    ErrInvalidCRC,
+			wantRetry: true,
+		},
+		{
+			name:     "failed to act",
+			cmd:      ESP_FLASH_BEGIN,
+			response: romResponse(ESP_FLASH_BEGIN, 1, ROM_ERR_FAILED_TO_ACT),
+			wantErr:  "FLASH_BEGIN failed: failed to act on message (status=1, error=0x06)",
+			wantIs:   ErrFailedToAct,
+		},
+		{
+			name:      "stub bad checksum",
+			cmd:       ESP_FLASH_DATA,
+			response:  romResponse(ESP_FLASH_DATA, 1, STUB_ERR_BAD_DATA_CHECKSUM),
+			wantErr:   "FLASH_DATA failed: bad data checksum (status=1, error=0xc1)",
+			wantIs:    ErrBadDataChecksum,
+			wantRetry: true,
+		},
+		{
+			name:     "unknown code",
+			cmd:      0x42,
+			response: romResponse(0x42, 1, 0x77),
+			wantErr:  "command 0x42 failed: unknown loader error (status=1, error=0x77)",
+			wantIs:   ErrUnknownLoader,
+		},
+		{
+			name:     "wrong command",
+			cmd:      ESP_READ_REG,
+			response: romResponse(ESP_SYNC, 0, 0),
+			wantErr:  "invalid READ_REG response (len=12, dir=0x01, cmd=0x08)",
+		},
+		{
+			name:     "request echo",
+			cmd:      ESP_SYNC,
+			response: []byte{0x00, ESP_SYNC, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			wantErr:  "invalid SYNC response (len=8, dir=0x00, cmd=0x08)",
+		},
+		{
+			name:     "truncated",
+			cmd:      ESP_SYNC,
+			response: []byte{0x01},
+			wantErr:  "invalid SYNC response (len=1)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkResponse(tt.cmd, tt.response)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+
+			var romErr *ROMError
+			isROM := errors.As(err, &romErr)
+			if isROM != (tt.wantIs != nil) {
+				t.Fatalf("errors.As(*ROMError) = %v, want %v", isROM, tt.wantIs != nil)
+			}
+			if !isROM {
+				return
+			}
+			if !errors.Is(err, tt.wantIs) {
+				t.Errorf("errors.Is(err, %v) = false", tt.wantIs)
+			}
+			if romErr.Command != tt.cmd {
+				t.Errorf("Command = 0x%02x, want 0x%02x", romErr.Command, tt.cmd)
+			}
+			if romErr.Retryable() != tt.wantRetry {
+				t.Errorf("Retryable() = %v, want %v", romErr.Retryable(), tt.wantRetry)
+			}
+			if romErr.Explanation() == "" || romErr.Hint() == "" {
+				t.Error("empty explanation or hint")
+			}
+		})
+	}
+}
+
+func TestROMErrorWrapped(t *testing.T) {
+	// Ошибка остается узнаваемой после оборачивания на верхних уровнях
+	err := checkResponse(ESP_FLASH_DATA, romResponse(ESP_FLASH_DATA, 1, ROM_ERR_FLASH_WRITE))
+	wrapped := fmt.Errorf("block 3: %w", err)
+
+	if !errors.Is(wrapped, ErrFlashWrite) {
+		t.Error("errors.Is(wrapped, ErrFlashWrite) = false")
+	}
+	if errors.Is(wrapped, ErrInvalidCRC) {
+		t.Error("errors.Is(wrapped, ErrInvalidCRC) = true")
+	}
+
+	var romErr *ROMError
+	if !errors.As(wrapped, &romErr) || romErr.Code != ROM_ERR_FLASH_WRITE {
+		t.Fatalf("errors.As(wrapped) = %v", romErr)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+)
+
+// Предел размера одного кадра: пакет FLASH_DATA с блоком 4 КБ и запасом
+const SLIP_MAX_FRAME = 16 * 1024
+
+// slipStream - потоковый декодер SLIP. Байты из порта подаются по мере поступления,
+// полные кадры складываются в очередь, мусор между кадрами (вывод ROM при загрузке)
+// отбрасывается. Нулевое значение готово к использованию
+type slipStream struct {
+	frames  [][]byte     // очередь декодированных кадров
+	frame   bytes.Buffer // кадр, который сейчас собирается
+	inFrame bool
+	escaped bool
+
+	noise   int // количество байт вне кадров
+	dropped int // количество отброшенных поврежденных кадров
+}
+
+// feed разбирает очередную порцию байт из порта
+func (s *slipStream) feed(data []byte) {
+	for _, b := range data {
+		if !s.inFrame {
+			if b == SLIP_END {
+				s.inFrame = true
+				s.escaped = false
+				s.frame.Reset()
+			} else {
+				s.noise++
+			}
+			continue
+		}
+
+		switch {
+		case s.escaped:
+			s.escaped = false
+			switch b {
+			case SLIP_ESC_END:
+				s.frame.WriteByte(SLIP_END)
+			case SLIP_ESC_ESC:
+				s.frame.WriteByte(SLIP_ESC)
+			default:
+				// Неверная escape-последовательность: кадр поврежден
+				s.dropped++
+				s.inFrame = false
+			}
+		case b == SLIP_ESC:
+			s.escaped = true
+		case b == SLIP_END:
+			// Пустой кадр означает, что END был концом предыдущего кадра,
+			// который мы начали читать с середины, - считаем его началом нового
+			if s.frame.Len() > 0 {
+				s.frames = append(s.frames, append([]byte(nil), s.frame.Bytes()...))
+				s.frame.Reset()
+				s.inFrame = false
+			}
+		default:
+			if s.frame.Len() >= SLIP_MAX_FRAME {
+				s.dropped++
+				s.inFrame = false
+				continue
+			}
+			s.frame.WriteByte(b)
+		}
+	}
+}
+
+// next извлекает из очереди ответ на команду cmd. Кадры, стоящие в очереди перед ним
+// (ответы на более ранние команды, эхо запросов), считаются устаревшими и возвращаются
+// в stale, чтобы их можно было записать в лог
+func (s *slipStream) next(cmd byte) (frame []byte, stale [][]byte) {
+	for i, candidate := range s.frames {
+		if len(candidate) >= 8 && candidate[0] == 0x01 && candidate[1] == cmd {
+			stale = s.frames[:i]
+			s.frames = s.frames[i+1:]
+			return candidate, stale
+		}
+	}
+	return nil, nil
+}
+
+// pop извлекает из очереди первый кадр независимо от его содержимого
+func (s *slipStream) pop() []byte {
+	if len(s.frames) == 0 {
+		return nil
+	}
+	frame := s.frames[0]
+	s.frames = s.frames[1:]
+	return frame
+}
+
+// reset сбрасывает состояние декодера вместе с очередью, например после очистки буферов порта
+func (s *slipStream) reset() {
+	s.frames = nil
+	s.frame.Reset()
+	s.inFrame = false
+	s.escaped = false
+	s.noise = 0
+	s.dropped = 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSlipStreamFeed(t *testing.T) {
+	tests := []struct {
+		name        string
+		chunks      [][]byte
+		want        [][]byte
+		wantNoise   int
+		wantDropped int
+	}{
+		{
+			name:   "single frame",
+			chunks: [][]byte{{SLIP_END, 0x01, 0x02, SLIP_END}},
+			want:   [][]byte{{0x01, 0x02}},
+		},
+		{
+			name:   "frame split across reads",
+			chunks: [][]byte{{SLIP_END, 0x01}, {0x02, SLIP_ESC}, {SLIP_ESC_END, SLIP_END}},
+			want:   [][]byte{{0x01, 0x02, SLIP_END}},
+		},
+		{
+			name:   "escapes",
+			chunks: [][]byte{{SLIP_END, SLIP_ESC, SLIP_ESC_END, SLIP_ESC, SLIP_ESC_ESC, SLIP_END}},
+			want:   [][]byte{{SLIP_END, SLIP_ESC}},
+		},
+		{
+			name:      "boot noise between frames",
+			chunks:    [][]byte{[]byte("ets Jun  8 2016\r\n"), {SLIP_END, 0x01, SLIP_END}, []byte("ok"), {SLIP_END, 0x02, SLIP_END}},
+			want:      [][]byte{{0x01}, {0x02}},
+			wantNoise: 19,
+		},
+		{
+			name:   "back to back frames share no END",
+			chunks: [][]byte{{SLIP_END, 0x01, SLIP_END, SLIP_END, 0x02, SLIP_END}},
+			want:   [][]byte{{0x01}, {0x02}},
+		},
+		{
+			name:   "read from the middle of a frame",
+			chunks: [][]byte{{SLIP_END, SLIP_END, 0x03, SLIP_END}},
+			want:   [][]byte{{0x03}},
+		},
+		{
+			name:        "invalid escape drops the frame",
+			chunks:      [][]byte{{SLIP_END, 0x01, SLIP_ESC, 0x00, 0x02, SLIP_END, 0x04, SLIP_END}},
+			want:        [][]byte{{0x04}},
+			wantDropped: 1,
+			wantNoise:   1,
+		},
+		{
+			name:        "oversized frame is dropped",
+			chunks:      [][]byte{{SLIP_END}, make([]byte, SLIP_MAX_FRAME+1)},
+			wantDropped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s slipStream
+			for _, chunk := range tt.chunks {
+				s.feed(chunk)
+			}
+
+			var got [][]byte
+			for frame := s.pop(); frame != nil; frame = s.pop() {
+				got = append(got, frame)
+			}
+			if !equalFrames(got, tt.want) {
+				t.Errorf("frames = % x, want % x", got, tt.want)
+			}
+			if s.noise != tt.wantNoise {
+				t.Errorf("noise = %d, want %d", s.noise, tt.wantNoise)
+			}
+			if s.dropped != tt.wantDropped {
+				t.Errorf("dropped = %d, want %d", s.dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestSlipStreamNext(t *testing.T) {
+	response := func(cmd byte) []byte {
+		return []byte{0x01, cmd, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	}
+	encode := func(frames ...[]byte) []byte {
+		var out []byte
+		for _, frame := range frames {
+			out = append(out, SLIP_END)
+			out = append(out, frame...)
+			out = append(out, SLIP_END)
+		}
+		return out
+	}
+
+	echo := []byte{0x00, ESP_SYNC, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	var s slipStream
+	s.feed(encode(echo, response(ESP_SYNC), response(ESP_READ_REG)))
+
+	frame, stale := s.next(ESP_READ_REG)
+	if !bytes.Equal(frame, response(ESP_READ_REG)) {
+		t.Fatalf("frame = % x, want READ_REG response", frame)
+	}
+	if !equalFrames(stale, [][]byte{echo, response(ESP_SYNC)}) {
+		t.Errorf("stale = % x, want echo and SYNC response", stale)
+	}
+
+	if frame, _ := s.next(ESP_READ_REG); frame != nil {
+		t.Errorf("queue should be empty, got % x", frame)
+	}
+
+	// Короткий кадр не считается ответом
+	s.feed(encode([]byte{0x01, ESP_SYNC}))
+	if frame, _ := s.next(ESP_SYNC); frame != nil {
+		t.Errorf("short frame accepted as response: % x", frame)
+	}
+
+	s.reset()
+	if s.pop() != nil || s.noise != 0 || s.dropped != 0 {
+		t.Error("reset did not clear the stream")
+	}
+}
+
+func equalFrames(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
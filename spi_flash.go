@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Регистры контроллера SPI1 ESP32, через которые ROM загрузчик обращается к flash
+// (те же адреса использует esptool в run_spiflash_command)
+const (
+	SPI_REG_BASE   = 0x3ff42000
+	SPI_CMD_REG    = SPI_REG_BASE + 0x00
+	SPI_USR_REG    = SPI_REG_BASE + 0x1c
+	SPI_USR2_REG   = SPI_REG_BASE + 0x24
+	SPI_MISO_DLEN  = SPI_REG_BASE + 0x2c
+	SPI_W0_REG     = SPI_REG_BASE + 0x80
+	SPI_CMD_USR    = 1 << 18
+	SPI_USR_CMD    = 1 << 31
+	SPI_USR_MISO   = 1 << 28
+	SPI_USR2_SHIFT = 28 // смещение длины команды в SPI_USR2_REG
+
+	SPI_FLASH_RDID = 0x9f // JEDEC Read Identification
+
+	// Магическое значение CHIP_DETECT_MAGIC_REG у ESP32
+	ESP32_CHIP_MAGIC = 0x00f01d83
+)
+
+// ErrImageTooLarge - образ не помещается во flash, установленную на плате
+var ErrImageTooLarge = errors.New("image does not fit into flash")
+
+// ErrWrongChip - прошивка собрана для другого семейства чипов
+var ErrWrongChip = errors.New("firmware is built for a different chip")
+
+// Производители SPI flash по JEDEC ID (первый байт ответа на RDID)
+var flashManufacturers = map[byte]string{
+	0x01: "Spansion/Cypress",
+	0x0b: "XTX",
+	0x1c: "EON",
+	0x20: "XMC/Micron",
+	0x5e: "Zbit",
+	0x68: "Boya",
+	0x85: "Puya",
+	0x9d: "ISSI",
+	0xa1: "Fudan",
+	0xbf: "SST",
+	0xc2: "Macronix",
+	0xc8: "GigaDevice",
+	0xef: "Winbond",
+}
+
+// Объем flash по третьему байту JEDEC ID (таблица DETECTED_FLASH_SIZES из esptool)
+var flashSizes = map[byte]uint32{
+	0x12: 256 * 1024,
+	0x13: 512 * 1024,
+	0x14: 1 * 1024 * 1024,
+	0x15: 2 * 1024 * 1024,
+	0x16: 4 * 1024 * 1024,
+	0x17: 8 * 1024 * 1024,
+	0x18: 16 * 1024 * 1024,
+	0x19: 32 * 1024 * 1024,
+	0x1a: 64 * 1024 * 1024,
+	0x1b: 128 * 1024 * 1024,
+	0x1c: 256 * 1024 * 1024,
+	0x20: 64 * 1024 * 1024,
+	0x21: 128 * 1024 * 1024,
+	0x22: 256 * 1024 * 1024,
+	0x32: 256 * 1024,
+	0x33: 512 * 1024,
+	0x34: 1 * 1024 * 1024,
+	0x35: 2 * 1024 * 1024,
+	0x36: 4 * 1024 * 1024,
+	0x37: 8 * 1024 * 1024,
+	0x38: 16 * 1024 * 1024,
+	0x39: 32 * 1024 * 1024,
+	0x3a: 64 * 1024 * 1024,
+}
+
+// Регистр, по значению которого esptool определяет модель чипа
+const CHIP_DETECT_MAGIC_REG = 0x40001000
+
+// Названия чипов по значению CHIP_DETECT_MAGIC_REG
+var chipMagics = map[uint32]string{
+	ESP32_CHIP_MAGIC: "ESP32",
+	0xfff0c101:       "ESP8266",
+	0x000007c6:       "ESP32-S2",
+	0x00000009:       "ESP32-S3",
+	0x6921506f:       "ESP32-C3",
+	0x1b31506f:       "ESP32-C3",
+	0x2ce0806f:       "ESP32-C6",
+}
+
+// FlashChipInfo - результат опознания микросхемы SPI flash
+type FlashChipInfo struct {
+	JEDECID        uint32 `json:"jedecId"`
+	ManufacturerID byte   `json:"manufacturerId"`
+	DeviceID       uint16 `json:"deviceId"`
+	Manufacturer   string `json:"manufacturer"` // пусто, если производитель неизвестен
+	Size           uint32 `json:"size"`         // 0, если объем не удалось определить
+}
+
+// String возвращает описание вида "Winbond 4 МБ (ef4016)"
+func (info FlashChipInfo) String() string {
+	manufacturer := info.Manufacturer
+	if manufacturer == "" {
+		manufacturer = fmt.Sprintf("производитель 0x%02x", info.ManufacturerID)
+	}
+	size := "объем неизвестен"
+	if info.Size != 0 {
+		size = formatFlashSize(info.Size)
+	}
+	return fmt.Sprintf("%s %s (%02x%04x)", manufacturer, size, info.ManufacturerID, info.DeviceID)
+}
+
+// formatFlashSize форматирует объем flash в КБ или МБ
+func formatFlashSize(size uint32) string {
+	if size >= 1024*1024 {
+		return fmt.Sprintf("%d МБ", size/(1024*1024))
+	}
+	return fmt.Sprintf("%d КБ", size/1024)
+}
+
+// parseJEDECID раскладывает ответ RDID на производителя, тип и объем
+func parseJEDECID(id uint32) FlashChipInfo {
+	manufacturer := byte(id)
+	memoryType := byte(id >> 8)
+	capacity := byte(id >> 16)
+
+	return FlashChipInfo{
+		JEDECID:        id & 0xffffff,
+		ManufacturerID: manufacturer,
+		DeviceID:       uint16(memoryType)<<8 | uint16(capacity),
+		Manufacturer:   flashManufacturers[manufacturer],
+		Size:           flashSizes[capacity],
+	}
+}
+
+// readReg читает 32-битный регистр чипа
+func (f *ESP32Flasher) readReg(ctx context.Context, addr uint32) (uint32, error) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, addr)
+
+	if err := f.sendCommand(ESP_READ_REG, data, 0); err != nil {
+		return 0, fmt.Errorf("failed to send READ_REG command: %w", err)
+	}
+
+	response, err := f.readResponse(ctx, ESP_READ_REG, 3*time.Second)
+	if err != nil {
+		return 0, fmt.Errorf("timeout waiting for READ_REG response: %w", err)
+	}
+	if err := checkResponse(ESP_READ_REG, response); err != nil {
+		return 0, err
+	}
+
+	// Значение регистра передается в поле value заголовка ответа
+	return binary.LittleEndian.Uint32(response[4:8]), nil
+}
+
+// writeReg записывает 32-битный регистр чипа
+func (f *ESP32Flasher) writeReg(ctx context.Context, addr, value uint32) error {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint32(data[0:4], addr)
+	binary.LittleEndian.PutUint32(data[4:8], value)
+	binary.LittleEndian.PutUint32(data[8:12], 0xffffffff) // Маска
+	binary.LittleEndian.PutUint32(data[12:16], 0)         // Задержка, мкс
+
+	if err := f.sendCommand(ESP_WRITE_REG, data, 0); err != nil {
+		return fmt.Errorf("failed to send WRITE_REG command: %w", err)
+	}
+
+	response, err := f.readResponse(ctx, ESP_WRITE_REG, 3*time.Second)
+	if err != nil {
+		return fmt.Errorf("timeout waiting for WRITE_REG response: %w", err)
+	}
+	return checkResponse(ESP_WRITE_REG, response)
+}
+
+// runSPIFlashCommand выполняет команду SPI flash без передачи данных и читает
+// readBits бит ответа. Регистры USR и USR2 восстанавливаются после выполнения
+func (f *ESP32Flasher) runSPIFlashCommand(ctx context.Context, command byte, readBits uint32) (uint32, error) {
+	oldUsr, err := f.readReg(ctx, SPI_USR_REG)
+	if err != nil {
+		return 0, err
+	}
+	oldUsr2, err := f.readReg(ctx, SPI_USR2_REG)
+	if err != nil {
+		return 0, err
+	}
+
+	flags := uint32(SPI_USR_CMD)
+	if readBits > 0 {
+		flags |= SPI_USR_MISO
+		if err := f.writeReg(ctx, SPI_MISO_DLEN, readBits-1); err != nil {
+			return 0, err
+		}
+	}
+
+	steps := []struct{ addr, value uint32 }{
+		{SPI_USR_REG, flags},
+		{SPI_USR2_REG, 7<<SPI_USR2_SHIFT | uint32(command)}, // 8-битная команда
+		{SPI_W0_REG, 0},
+		{SPI_CMD_REG, SPI_CMD_USR}, // Запуск транзакции
+	}
+	for _, step := range steps {
+		if err := f.writeReg(ctx, step.addr, step.value); err != nil {
+			return 0, err
+		}
+	}
+
+	// Контроллер сбрасывает бит USR по окончании транзакции
+	deadline := time.Now().Add(time.Second)
+	for {
+		cmd, err := f.readReg(ctx, SPI_CMD_REG)
+		if err != nil {
+			return 0, err
+		}
+		if cmd&SPI_CMD_USR == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("SPI flash command 0x%02x did not complete", command)
+		}
+	}
+
+	result, err := f.readReg(ctx, SPI_W0_REG)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := f.writeReg(ctx, SPI_USR_REG, oldUsr); err != nil {
+		return 0, err
+	}
+	if err := f.writeReg(ctx, SPI_USR2_REG, oldUsr2); err != nil {
+		return 0, err
+	}
+
+	return result, nil
+}
+
+// chipName определяет модель чипа по CHIP_DETECT_MAGIC_REG
+func (f *ESP32Flasher) chipName(ctx context.Context) (string, uint32, error) {
+	magic, err := f.readReg(ctx, CHIP_DETECT_MAGIC_REG)
+	if err != nil {
+		return "", 0, err
+	}
+	if name, ok := chipMagics[magic]; ok {
+		return name, magic, nil
+	}
+	return fmt.Sprintf("Неизвестный чип (0x%08x)", magic), magic, nil
+}
+
+// detectFlash читает JEDEC ID микросхемы flash. Вызывается после spiAttach
+func (f *ESP32Flasher) detectFlash(ctx context.Context) (FlashChipInfo, error) {
+	id, err := f.runSPIFlashCommand(ctx, SPI_FLASH_RDID, 24)
+	if err != nil {
+		return FlashChipInfo{}, fmt.Errorf("failed to read flash ID: %w", err)
+	}
+
+	// Без подключенной flash линия MISO читается как все нули или все единицы
+	if id&0xffffff == 0 || id&0xffffff == 0xffffff {
+		return FlashChipInfo{}, fmt.Errorf("flash chip does not respond (JEDEC ID 0x%06x)", id&0xffffff)
+	}
+
+	return parseJEDECID(id), nil
+}
+
+// spiSetParams сообщает загрузчику геометрию flash. ROM по умолчанию считает
+// flash 4 МБ и отказывается писать за эту границу
+func (f *ESP32Flasher) spiSetParams(ctx context.Context, size uint32) error {
+	data := make([]byte, 24)
+	binary.LittleEndian.PutUint32(data[0:4], 0)                  // fl_id
+	binary.LittleEndian.PutUint32(data[4:8], size)               // Общий объем
+	binary.LittleEndian.PutUint32(data[8:12], ESP_FLASH_BLOCK)   // Блок
+	binary.LittleEndian.PutUint32(data[12:16], ESP_FLASH_SECTOR) // Сектор
+	binary.LittleEndian.PutUint32(data[16:20], 256)              // Страница
+	binary.LittleEndian.PutUint32(data[20:24], 0xffff)           // Маска статуса
+
+	if err := f.sendCommand(ESP_SPI_PARAMS, data, 0); err != nil {
+		return fmt.Errorf("failed to send SPI_SET_PARAMS command: %w", err)
+	}
+
+	response, err := f.readResponse(ctx, ESP_SPI_PARAMS, 3*time.Second)
+	if err != nil {
+		return fmt.Errorf("timeout waiting for SPI_SET_PARAMS response: %w", err)
+	}
+	return checkResponse(ESP_SPI_PARAMS, response)
+}
+
+// setupFlash опознает flash, передает ее объем загрузчику и проверяет, что образ
+// помещается. Если flash опознать не удалось, проверка пропускается
+func (f *ESP32Flasher) setupFlash(ctx context.Context, segments []FirmwareSegment) error {
+	// Регистры SPI1 по SPI_REG_BASE есть только у ESP32, у S2/S3/C3 они по другим адресам
+	chip, magic, err := f.chipName(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		if f.callback != nil {
+			f.callback.emitLog(fmt.Sprintf("⚠️ Не удалось определить чип: %v", err))
+		}
+		return nil
+	}
+	if magic != ESP32_CHIP_MAGIC {
+		if f.callback != nil {
+			f.callback.emitLog(fmt.Sprintf("ℹ️ %s: опознание flash поддерживается только для ESP32, проверка размера пропущена", chip))
+		}
+		return nil
+	}
+
+	info, err := f.detectFlash(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		if f.callback != nil {
+			f.callback.emitLog(fmt.Sprintf("⚠️ Не удалось опознать flash: %v", err))
+		}
+		return nil
+	}
+
+	f.flashInfo = &info
+	if f.callback != nil {
+		f.callback.emitLog(fmt.Sprintf("💾 Flash: %s", info))
+	}
+
+	if info.Size == 0 {
+		return nil
+	}
+
+	if err := f.spiSetParams(ctx, info.Size); err != nil {
+		return fmt.Errorf("SPI set params failed: %w", err)
+	}
+
+	return checkImageFits(segments, info.Size)
+}
+
+// checkImageFits проверяет, что все сегменты лежат в пределах flash
+func checkImageFits(segments []FirmwareSegment, flashSize uint32) error {
+	for _, segment := range segments {
+		end := uint64(segment.Offset) + uint64(len(segment.Data))
+		if end > uint64(flashSize) {
+			return fmt.Errorf("%w: segment 0x%x-0x%x exceeds %s flash", ErrImageTooLarge, segment.Offset, end, formatFlashSize(flashSize))
+		}
+	}
+	return nil
+}
+
+// ExpectChip задает семейство чипа, для которого собрана прошивка (из UF2).
+// Пустая строка отключает проверку
+func (f *ESP32Flasher) ExpectChip(family string) {
+	f.expectedChip = family
+}
+
+// checkChip сверяет подключенный чип с семейством прошивки. Неизвестный
+// загрузчику чип не считается ошибкой, только предупреждением
+func (f *ESP32Flasher) checkChip(ctx context.Context) error {
+	if f.expectedChip == "" {
+		return nil
+	}
+
+	chip, magic, err := f.chipName(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to detect chip: %w", err)
+	}
+	if _, known := chipMagics[magic]; !known {
+		if f.callback != nil {
+			f.callback.emitLog(fmt.Sprintf("⚠️ %s: не удалось сверить с семейством прошивки %s", chip, f.expectedChip))
+		}
+		return nil
+	}
+	if chip != f.expectedChip {
+		return fmt.Errorf("%w: firmware is for %s, connected chip is %s", ErrWrongChip, f.expectedChip, chip)
+	}
+
+	if f.callback != nil {
+		f.callback.emitLog(fmt.Sprintf("✅ Чип %s совпадает с семейством прошивки", chip))
+	}
+	return nil
+}
+
+// FlashInfo возвращает опознанную flash или nil, если опознание не выполнялось
+func (f *ESP32Flasher) FlashInfo() *FlashChipInfo {
+	return f.flashInfo
+}
+
+// ChipInfo - сведения о подключенном чипе для отображения в UI
+type ChipInfo struct {
+	Chip      string         `json:"chip"`
+	Magic     uint32         `json:"magic"`
+	Flash     *FlashChipInfo `json:"flash"` // nil, если flash не отвечает
+	FlashSize string         `json:"flashSize"`
+}
+
+// ReadChipInfo синхронизируется с загрузчиком и опознает чип и flash
+func (f *ESP32Flasher) ReadChipInfo(ctx context.Context) (ChipInfo, error) {
+	if err := f.sync(ctx); err != nil {
+		return ChipInfo{}, fmt.Errorf("sync failed: %w", err)
+	}
+
+	chip, magic, err := f.chipName(ctx)
+	if err != nil {
+		return ChipInfo{}, fmt.Errorf("failed to detect chip: %w", err)
+	}
+	info := ChipInfo{Chip: chip, Magic: magic}
+
+	// Регистры SPI известны только для ESP32
+	if magic != ESP32_CHIP_MAGIC {
+		return info, nil
+	}
+
+	if err := f.spiAttach(ctx); err != nil {
+		return info, fmt.Errorf("SPI attach failed: %w", err)
+	}
+
+	flash, err := f.detectFlash(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return info, err
+		}
+		if f.callback != nil {
+			f.callback.emitLog(fmt.Sprintf("⚠️ Не удалось опознать flash: %v", err))
+		}
+		return info, nil
+	}
+
+	info.Flash = &flash
+	if flash.Size != 0 {
+		info.FlashSize = formatFlashSize(flash.Size)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestParseJEDECID(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         uint32
+		want       FlashChipInfo
+		wantString string
+	}{
+		{
+			name:       "Winbond W25Q32",
+			id:         0x1640ef,
+			want:       FlashChipInfo{JEDECID: 0x1640ef, ManufacturerID: 0xef, DeviceID: 0x4016, Manufacturer: "Winbond", Size: 4 * 1024 * 1024},
+			wantString: "Winbond 4 МБ (ef4016)",
+		},
+		{
+			name:       "GigaDevice GD25Q128",
+			id:         0x1840c8,
+			want:       FlashChipInfo{JEDECID: 0x1840c8, ManufacturerID: 0xc8, DeviceID: 0x4018, Manufacturer: "GigaDevice", Size: 16 * 1024 * 1024},
+			wantString: "GigaDevice 16 МБ (c84018)",
+		},
+		{
+			name:       "small flash",
+			id:         0x13605e,
+			want:       FlashChipInfo{JEDECID: 0x13605e, ManufacturerID: 0x5e, DeviceID: 0x6013, Manufacturer: "Zbit", Size: 512 * 1024},
+			wantString: "Zbit 512 КБ (5e6013)",
+		},
+		{
+			name:       "upper byte is ignored",
+			id:         0xff1740ef,
+			want:       FlashChipInfo{JEDECID: 0x1740ef, ManufacturerID: 0xef, DeviceID: 0x4017, Manufacturer: "Winbond", Size: 8 * 1024 * 1024},
+			wantString: "Winbond 8 МБ (ef4017)",
+		},
+		{
+			name:       "unknown manufacturer and capacity",
+			id:         0x554433,
+			want:       FlashChipInfo{JEDECID: 0x554433, ManufacturerID: 0x33, DeviceID: 0x4455},
+			wantString: "производитель 0x33 объем неизвестен (334455)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseJEDECID(tt.id)
+			if got != tt.want {
+				t.Errorf("parseJEDECID(0x%x) = %+v, want %+v", tt.id, got, tt.want)
+			}
+			if got.String() != tt.wantString {
+				t.Errorf("String() = %q, want %q", got.String(), tt.wantString)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Виды событий трассы. Действия хоста (tx, dtr, rts, baud, flush-*) при воспроизведении
+// сверяются с трассой, rx отдаются флешеру, frame-* записываются только для чтения человеком
+const (
+	TRACE_HEADER    = "header"
+	TRACE_TX        = "tx"
+	TRACE_RX        = "rx"
+	TRACE_FRAME_TX  = "frame-tx"
+	TRACE_FRAME_RX  = "frame-rx"
+	TRACE_DTR       = "dtr"
+	TRACE_RTS       = "rts"
+	TRACE_BAUD      = "baud"
+	TRACE_FLUSH_IN  = "flush-in"
+	TRACE_FLUSH_OUT = "flush-out"
+)
+
+// TraceEvent - одна строка файла трассы (JSON Lines)
+type TraceEvent struct {
+	Time  int64             `json:"t"` // микросекунды от начала записи
+	Kind  string            `json:"kind"`
+	Data  string            `json:"data,omitempty"`  // байты в hex
+	Level *bool             `json:"level,omitempty"` // уровень DTR/RTS
+	Baud  int               `json:"baud,omitempty"`
+	Error string            `json:"err,omitempty"`  // ошибка чтения или записи порта
+	Meta  map[string]string `json:"meta,omitempty"` // только в заголовке
+}
+
+// isHostAction сообщает, является ли событие действием флешера над портом
+func (e TraceEvent) isHostAction() bool {
+	switch e.Kind {
+	case TRACE_TX, TRACE_DTR, TRACE_RTS, TRACE_BAUD, TRACE_FLUSH_IN, TRACE_FLUSH_OUT:
+		return true
+	}
+	return false
+}
+
+// TraceRecorder записывает обмен с устройством в файл
+type TraceRecorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	start   time.Time
+	rx      slipStream // для записи декодированных входящих кадров
+	path    string
+}
+
+// NewTraceRecorder создает файл трассы в каталоге dir. meta сохраняется в заголовке
+// (порт, файл прошивки, параметры), чтобы трассу можно было воспроизвести
+func NewTraceRecorder(dir string, meta map[string]string) (*TraceRecorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create trace directory: %w", err)
+	}
+
+	path := sessionBase(dir, "trace", ".jsonl", time.Now()) + ".jsonl"
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace file: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	r := &TraceRecorder{
+		file:    file,
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+		start:   time.Now(),
+		path:    path,
+	}
+
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	meta["started"] = r.start.Format(time.RFC3339Nano)
+	r.record(TraceEvent{Kind: TRACE_HEADER, Meta: meta})
+
+	return r, nil
+}
+
+// Path возвращает путь к файлу трассы
+func (r *TraceRecorder) Path() string {
+	return r.path
+}
+
+// Wrap возвращает канал, все операции которого записываются в трассу
+func (r *TraceRecorder) Wrap(t Transport) Transport {
+	return &tracedTransport{inner: t, rec: r}
+}
+
+// Close дописывает буфер и закрывает файл
+func (r *TraceRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+func (r *TraceRecorder) record(event TraceEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	event.Time = time.Since(r.start).Microseconds()
+	r.encoder.Encode(event)
+
+	// Входящие данные дополнительно раскладываем на кадры SLIP
+	if event.Kind == TRACE_RX {
+		data, _ := hex.DecodeString(event.Data)
+		r.rx.feed(data)
+		for frame := r.rx.pop(); frame != nil; frame = r.rx.pop() {
+			r.encoder.Encode(TraceEvent{Time: event.Time, Kind: TRACE_FRAME_RX, Data: hex.EncodeToString(frame)})
+		}
+	}
+}
+
+func (r *TraceRecorder) recordBytes(kind string, data []byte, err error) {
+	event := TraceEvent{Kind: kind, Data: hex.EncodeToString(data)}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	r.record(event)
+}
+
+func (r *TraceRecorder) recordLevel(kind string, level bool) {
+	r.record(TraceEvent{Kind: kind, Level: &level})
+}
+
+// tracedTransport - канал, пишущий все операции в TraceRecorder
+type tracedTransport struct {
+	inner Transport
+	rec   *TraceRecorder
+}
+
+func (t *tracedTransport) Read(p []byte) (int, error) {
+	n, err := t.inner.Read(p)
+	// Ошибка записывается, чтобы при воспроизведении повторить потерю порта
+	if n > 0 || err != nil {
+		t.rec.recordBytes(TRACE_RX, p[:n], err)
+	}
+	return n, err
+}
+
+func (t *tracedTransport) Write(p []byte) (int, error) {
+	n, err := t.inner.Write(p)
+	t.rec.recordBytes(TRACE_TX, p, err)
+
+	// Исходящие записи флешера - это целые кадры SLIP
+	var frames slipStream
+	frames.feed(p)
+	for frame := frames.pop(); frame != nil; frame = frames.pop() {
+		t.rec.recordBytes(TRACE_FRAME_TX, frame, nil)
+	}
+
+	return n, err
+}
+
+func (t *tracedTransport) SetDTR(dtr bool) error {
+	t.rec.recordLevel(TRACE_DTR, dtr)
+	return t.inner.SetDTR(dtr)
+}
+
+func (t *tracedTransport) SetRTS(rts bool) error {
+	t.rec.recordLevel(TRACE_RTS, rts)
+	return t.inner.SetRTS(rts)
+}
+
+func (t *tracedTransport) SetBaudRate(baudRate int) error {
+	t.rec.record(TraceEvent{Kind: TRACE_BAUD, Baud: baudRate})
+	return t.inner.SetBaudRate(baudRate)
+}
+
+func (t *tracedTransport) ResetInputBuffer() error {
+	t.rec.record(TraceEvent{Kind: TRACE_FLUSH_IN})
+	return t.inner.ResetInputBuffer()
+}
+
+func (t *tracedTransport) ResetOutputBuffer() error {
+	t.rec.record(TraceEvent{Kind: TRACE_FLUSH_OUT})
+	return t.inner.ResetOutputBuffer()
+}
+
+func (t *tracedTransport) SetReadTimeout(timeout time.Duration) error {
+	return t.inner.SetReadTimeout(timeout)
+}
+
+func (t *tracedTransport) Close() error {
+	return t.inner.Close()
+}
+
+// TraceReplay - канал, воспроизводящий записанную трассу. После каждого действия
+// флешера становятся доступны входящие данные, полученные в записи до следующего
+// действия, поэтому поведение флешера повторяется детерминированно
+type TraceReplay struct {
+	mu         sync.Mutex
+	header     TraceEvent
+	events     []TraceEvent
+	cursor     int
+	pending    []byte // входящие данные, доступные для чтения
+	readErr    error  // записанная ошибка чтения, возвращается после pending
+	timeout    time.Duration
+	mismatches []string
+}
+
+// OpenTraceReplay загружает файл трассы
+func OpenTraceReplay(path string) (*TraceReplay, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trace: %w", err)
+	}
+
+	replay := &TraceReplay{timeout: READ_POLL_INTERVAL}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var event TraceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("trace line %d: %w", line, err)
+		}
+
+		switch {
+		case event.Kind == TRACE_HEADER:
+			replay.header = event
+		case event.Kind == TRACE_RX || event.isHostAction():
+			replay.events = append(replay.events, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse trace: %w", err)
+	}
+	if replay.header.Kind != TRACE_HEADER {
+		return nil, fmt.Errorf("trace has no header")
+	}
+
+	// Данные, пришедшие до первого действия флешера, доступны сразу
+	replay.release()
+
+	return replay, nil
+}
+
+// Meta возвращает метаданные из заголовка трассы
+func (r *TraceReplay) Meta() map[string]string {
+	return r.header.Meta
+}
+
+// Mismatches возвращает расхождения между действиями флешера и трассой
+func (r *TraceReplay) Mismatches() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.mismatches...)
+}
+
+// release делает доступными входящие данные до следующего действия флешера.
+// На записанной ошибке чтения останавливается: данные после нее станут
+// доступны после следующего действия (например, переоткрытия порта)
+func (r *TraceReplay) release() {
+	for r.cursor < len(r.events) && r.events[r.cursor].Kind == TRACE_RX {
+		event := r.events[r.cursor]
+		data, _ := hex.DecodeString(event.Data)
+		r.pending = append(r.pending, data...)
+		r.cursor++
+
+		if event.Error != "" {
+			r.readErr = errors.New(event.Error)
+			return
+		}
+	}
+}
+
+// hostAction сверяет действие флешера со следующим действием в трассе и
+// возвращает ошибку, с которой это действие завершилось в записи
+func (r *TraceReplay) hostAction(actual TraceEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cursor >= len(r.events) {
+		r.mismatches = append(r.mismatches, fmt.Sprintf("unexpected %s after end of trace", actual.Kind))
+		return nil
+	}
+
+	expected := r.events[r.cursor]
+	if expected.Kind != actual.Kind || expected.Data != actual.Data ||
+		(expected.Level != nil && actual.Level != nil && *expected.Level != *actual.Level) ||
+		expected.Baud != actual.Baud {
+		r.mismatches = append(r.mismatches, fmt.Sprintf("event %d: expected %s %s, got %s %s",
+			r.cursor, expected.Kind, shortHex(expected.Data), actual.Kind, shortHex(actual.Data)))
+	}
+
+	// Идем дальше в любом случае, чтобы не потерять синхронизацию с трассой
+	r.cursor++
+	if actual.Kind == TRACE_FLUSH_IN {
+		r.pending = nil
+	}
+	r.release()
+
+	if expected.Error != "" {
+		return errors.New(expected.Error)
+	}
+	return nil
+}
+
+// shortHex сокращает длинные hex-строки для сообщений о расхождениях
+func shortHex(data string) string {
+	if len(data) > 32 {
+		return data[:32] + "..."
+	}
+	return data
+}
+
+func (r *TraceReplay) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	if len(r.pending) > 0 {
+		n := copy(p, r.pending)
+		r.pending = r.pending[n:]
+		r.mu.Unlock()
+		return n, nil
+	}
+	if err := r.readErr; err != nil {
+		r.readErr = nil
+		r.mu.Unlock()
+		return 0, err
+	}
+	timeout := r.timeout
+	r.mu.Unlock()
+
+	// Данных нет - как и в записи, чтение завершается по таймауту
+	time.Sleep(timeout)
+	return 0, nil
+}
+
+func (r *TraceReplay) Write(p []byte) (int, error) {
+	if err := r.hostAction(TraceEvent{Kind: TRACE_TX, Data: hex.EncodeToString(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *TraceReplay) SetDTR(dtr bool) error {
+	return r.hostAction(TraceEvent{Kind: TRACE_DTR, Level: &dtr})
+}
+
+func (r *TraceReplay) SetRTS(rts bool) error {
+	return r.hostAction(TraceEvent{Kind: TRACE_RTS, Level: &rts})
+}
+
+func (r *TraceReplay) SetBaudRate(baudRate int) error {
+	return r.hostAction(TraceEvent{Kind: TRACE_BAUD, Baud: baudRate})
+}
+
+func (r *TraceReplay) ResetInputBuffer() error {
+	return r.hostAction(TraceEvent{Kind: TRACE_FLUSH_IN})
+}
+
+func (r *TraceReplay) ResetOutputBuffer() error {
+	return r.hostAction(TraceEvent{Kind: TRACE_FLUSH_OUT})
+}
+
+func (r *TraceReplay) SetReadTimeout(timeout time.Duration) error {
+	r.mu.Lock()
+	r.timeout = timeout
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *TraceReplay) Close() error {
+	return nil
+}
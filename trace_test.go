@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// recordTrace прошивает образ в эмулятор с записью трассы и возвращает путь к ней
+func recordTrace(t *testing.T, config FlasherConfig, image []byte) string {
+	t.Helper()
+
+	emu, transport := NewROMEmulator(EMULATOR_FLASH_SIZE, EmulatorFaults{})
+	defer emu.Close()
+
+	trace, err := NewTraceRecorder(t.TempDir(), map[string]string{
+		"reset":  config.Reset,
+		"before": config.Before,
+		"after":  config.After,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Transport = transport
+	config.Trace = trace
+
+	ctx := context.Background()
+	flasher, err := NewESP32FlasherWithConfig(ctx, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flasher.FlashSegments(ctx, []FirmwareSegment{{Offset: DEFAULT_APP_OFFSET, Data: image}}); err != nil {
+		t.Fatalf("FlashSegments: %v", err)
+	}
+	if err := trace.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return trace.Path()
+}
+
+// replayTrace повторяет прошивку по трассе с параметрами из ее заголовка
+func replayTrace(t *testing.T, path string, image []byte) (*TraceReplay, error) {
+	t.Helper()
+
+	replay, err := OpenTraceReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := replay.Meta()
+	config := FlasherConfig{Transport: replay, Reset: meta["reset"], Before: meta["before"], After: meta["after"]}
+
+	ctx := context.Background()
+	flasher, err := NewESP32FlasherWithConfig(ctx, config, nil)
+	if err != nil {
+		return replay, err
+	}
+	return replay, flasher.FlashSegments(ctx, []FirmwareSegment{{Offset: DEFAULT_APP_OFFSET, Data: image}})
+}
+
+func TestTraceReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		config FlasherConfig
+	}{
+		{"auto reset", FlasherConfig{}},
+		{"classic reset and run", FlasherConfig{Reset: RESET_CLASSIC, After: AFTER_RUN}},
+		{"custom sequence", FlasherConfig{Reset: "D0|R1|W100|D1|R0|W50|D0", After: AFTER_NO_RESET}},
+	}
+
+	image := testImage(4096)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := recordTrace(t, tt.config, image)
+
+			replay, err := replayTrace(t, path, image)
+			if err != nil {
+				t.Fatalf("replay failed: %v", err)
+			}
+			if mismatches := replay.Mismatches(); len(mismatches) > 0 {
+				t.Errorf("replay diverged from the trace: %q", mismatches)
+			}
+		})
+	}
+}
+
+func TestTraceReplayPortLoss(t *testing.T) {
+	image := testImage(8 * 4096)
+
+	// Обрыв на середине записи: трасса содержит ошибку чтения и переподключение
+	flaky, transport := newFlakyEmulator(20)
+	defer flaky.emu.Close()
+
+	trace, err := NewTraceRecorder(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	flasher, err := NewESP32FlasherWithConfig(ctx, FlasherConfig{Transport: transport, Trace: trace, Reopen: flaky.reopen}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flasher.FlashSegments(ctx, []FirmwareSegment{{Offset: DEFAULT_APP_OFFSET, Data: image}}); err != nil {
+		t.Fatalf("FlashSegments: %v", err)
+	}
+	trace.Close()
+	if !bytes.Equal(flaky.flash[DEFAULT_APP_OFFSET:DEFAULT_APP_OFFSET+len(image)], image) {
+		t.Fatal("flash content does not match the image after resume")
+	}
+
+	replay, err := OpenTraceReplay(trace.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lost := false
+	for _, event := range replay.events {
+		lost = lost || (event.Kind == TRACE_RX && event.Error != "")
+	}
+	if !lost {
+		t.Fatal("trace has no recorded read error")
+	}
+
+	flasher, err = NewESP32FlasherWithConfig(ctx, FlasherConfig{
+		Transport: replay,
+		Reopen:    func(string) (Transport, error) { return replay, nil },
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flasher.FlashSegments(ctx, []FirmwareSegment{{Offset: DEFAULT_APP_OFFSET, Data: image}}); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if mismatches := replay.Mismatches(); len(mismatches) > 0 {
+		t.Errorf("replay diverged from the trace: %q", mismatches)
+	}
+}
+
+func TestTraceRecorderUniqueNames(t *testing.T) {
+	dir := t.TempDir()
+
+	paths := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		trace, err := NewTraceRecorder(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trace.Close()
+		paths[trace.Path()] = true
+	}
+	if len(paths) != 3 {
+		t.Errorf("traces started together share files: %v", paths)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Скорость, на которой загрузчик ESP32 принимает SYNC после сброса
+const ROM_BAUD_RATE = 115200
+
+// Transport - канал связи с загрузчиком ESP32. Read при истечении таймаута
+// возвращает (0, nil), как это делает go.bug.st/serial
+type Transport interface {
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	SetReadTimeout(timeout time.Duration) error
+
+	// Линии модема, через которые выполняется сброс (DTR -> GPIO0, RTS -> EN)
+	SetDTR(dtr bool) error
+	SetRTS(rts bool) error
+
+	SetBaudRate(baudRate int) error
+	ResetInputBuffer() error
+	ResetOutputBuffer() error
+	Close() error
+}
+
+// OpenTransport открывает канал по имени: "tcp://host:port" для сетевых мостов
+// (ser2net, ESP-Link), EMULATOR_PORT_NAME для встроенного эмулятора,
+// иначе имя считается именем последовательного порта
+func OpenTransport(name string) (Transport, error) {
+	if name == EMULATOR_PORT_NAME {
+		return openDemoEmulator(), nil
+	}
+	if address, ok := strings.CutPrefix(name, "tcp://"); ok {
+		return DialTCPTransport(address)
+	}
+	return OpenSerialTransport(name, ROM_BAUD_RATE)
+}
+
+// serialTransport - локальный последовательный порт
+type serialTransport struct {
+	port serial.Port
+	mode serial.Mode
+}
+
+// OpenSerialTransport открывает последовательный порт 8N1 на заданной скорости
+func OpenSerialTransport(portName string, baudRate int) (Transport, error) {
+	mode := serial.Mode{
+		BaudRate: baudRate,
+		Parity:   serial.NoParity,
+		DataBits: 8,
+		StopBits: serial.OneStopBit,
+	}
+
+	port, err := serial.Open(portName, &mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serialTransport{port: port, mode: mode}, nil
+}
+
+func (t *serialTransport) Read(p []byte) (int, error)  { return t.port.Read(p) }
+func (t *serialTransport) Write(p []byte) (int, error) { return t.port.Write(p) }
+func (t *serialTransport) SetDTR(dtr bool) error       { return t.port.SetDTR(dtr) }
+func (t *serialTransport) SetRTS(rts bool) error       { return t.port.SetRTS(rts) }
+func (t *serialTransport) ResetInputBuffer() error     { return t.port.ResetInputBuffer() }
+func (t *serialTransport) ResetOutputBuffer() error    { return t.port.ResetOutputBuffer() }
+func (t *serialTransport) Close() error                { return t.port.Close() }
+
+func (t *serialTransport) SetReadTimeout(timeout time.Duration) error {
+	return t.port.SetReadTimeout(timeout)
+}
+
+func (t *serialTransport) SetBaudRate(baudRate int) error {
+	t.mode.BaudRate = baudRate
+	return t.port.SetMode(&t.mode)
+}
+
+// tcpTransport - сырой TCP сокет к сетевому мосту UART. Линий модема у сокета нет,
+// поэтому SetDTR/SetRTS ничего не делают: сброс в таких схемах выполняет сам мост
+type tcpTransport struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// DialTCPTransport подключается к мосту по адресу host:port
+func DialTCPTransport(address string) (Transport, error) {
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tcpTransport{conn: conn, timeout: READ_POLL_INTERVAL}, nil
+}
+
+func (t *tcpTransport) Read(p []byte) (int, error) {
+	t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+	n, err := t.conn.Read(p)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return n, nil
+	}
+	return n, err
+}
+
+func (t *tcpTransport) Write(p []byte) (int, error) { return t.conn.Write(p) }
+func (t *tcpTransport) SetDTR(dtr bool) error       { return nil }
+func (t *tcpTransport) SetRTS(rts bool) error       { return nil }
+func (t *tcpTransport) SetBaudRate(baudRate int) error {
+	return fmt.Errorf("baud rate change is not supported over raw TCP")
+}
+func (t *tcpTransport) ResetOutputBuffer() error { return nil }
+func (t *tcpTransport) Close() error             { return t.conn.Close() }
+
+func (t *tcpTransport) SetReadTimeout(timeout time.Duration) error {
+	t.timeout = timeout
+	return nil
+}
+
+// ResetInputBuffer вычитывает все, что уже пришло в сокет
+func (t *tcpTransport) ResetInputBuffer() error {
+	buffer := make([]byte, 1024)
+	for {
+		t.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+		n, err := t.conn.Read(buffer)
+		if n == 0 || err != nil {
+			return nil
+		}
+	}
+}
+
+// pipeBuffer - однонаправленный буфер между двумя концами PipeTransport
+type pipeBuffer struct {
+	mu     sync.Mutex
+	data   []byte
+	notify chan struct{}
+}
+
+func newPipeBuffer() *pipeBuffer {
+	return &pipeBuffer{notify: make(chan struct{}, 1)}
+}
+
+func (b *pipeBuffer) write(p []byte) {
+	b.mu.Lock()
+	b.data = append(b.data, p...)
+	b.mu.Unlock()
+
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (b *pipeBuffer) reset() {
+	b.mu.Lock()
+	b.data = nil
+	b.mu.Unlock()
+}
+
+// pipeState - общее состояние пары: линии модема и признак закрытия
+type pipeState struct {
+	mu       sync.Mutex
+	dtr, rts bool
+	baudRate int
+	onLines  func(dtr, rts bool)
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// PipeTransport - один конец канала в памяти. Используется для эмуляторов и тестов
+type PipeTransport struct {
+	in      *pipeBuffer
+	out     *pipeBuffer
+	state   *pipeState
+	timeout time.Duration
+}
+
+// NewPipeTransport создает связанную пару: host - сторона флешера, device - сторона
+// устройства. Линии модема и скорость, заданные на host, видны на device
+func NewPipeTransport() (host, device *PipeTransport) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	state := &pipeState{baudRate: ROM_BAUD_RATE, closed: make(chan struct{})}
+
+	host = &PipeTransport{in: a, out: b, state: state, timeout: READ_POLL_INTERVAL}
+	device = &PipeTransport{in: b, out: a, state: state, timeout: READ_POLL_INTERVAL}
+	return host, device
+}
+
+func (t *PipeTransport) Read(p []byte) (int, error) {
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+
+	for {
+		t.in.mu.Lock()
+		if len(t.in.data) > 0 {
+			n := copy(p, t.in.data)
+			t.in.data = t.in.data[n:]
+			t.in.mu.Unlock()
+			return n, nil
+		}
+		t.in.mu.Unlock()
+
+		select {
+		case <-t.state.closed:
+			return 0, io.ErrClosedPipe
+		case <-t.in.notify:
+		case <-timer.C:
+			return 0, nil
+		}
+	}
+}
+
+func (t *PipeTransport) Write(p []byte) (int, error) {
+	select {
+	case <-t.state.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	t.out.write(p)
+	return len(p), nil
+}
+
+func (t *PipeTransport) SetReadTimeout(timeout time.Duration) error {
+	t.timeout = timeout
+	return nil
+}
+
+func (t *PipeTransport) SetDTR(dtr bool) error {
+	t.state.mu.Lock()
+	t.state.dtr = dtr
+	rts, onLines := t.state.rts, t.state.onLines
+	t.state.mu.Unlock()
+
+	if onLines != nil {
+		onLines(dtr, rts)
+	}
+	return nil
+}
+
+func (t *PipeTransport) SetRTS(rts bool) error {
+	t.state.mu.Lock()
+	t.state.rts = rts
+	dtr, onLines := t.state.dtr, t.state.onLines
+	t.state.mu.Unlock()
+
+	if onLines != nil {
+		onLines(dtr, rts)
+	}
+	return nil
+}
+
+func (t *PipeTransport) SetBaudRate(baudRate int) error {
+	t.state.mu.Lock()
+	t.state.baudRate = baudRate
+	t.state.mu.Unlock()
+	return nil
+}
+
+// ModemLines возвращает текущее состояние DTR/RTS и скорость канала
+func (t *PipeTransport) ModemLines() (dtr, rts bool, baudRate int) {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	return t.state.dtr, t.state.rts, t.state.baudRate
+}
+
+// OnModemLines задает обработчик изменения DTR/RTS (например, для эмуляции сброса)
+func (t *PipeTransport) OnModemLines(handler func(dtr, rts bool)) {
+	t.state.mu.Lock()
+	t.state.onLines = handler
+	t.state.mu.Unlock()
+}
+
+func (t *PipeTransport) ResetInputBuffer() error {
+	t.in.reset()
+	return nil
+}
+
+func (t *PipeTransport) ResetOutputBuffer() error {
+	return nil
+}
+
+// Close закрывает оба конца канала
+func (t *PipeTransport) Close() error {
+	t.state.once.Do(func() { close(t.state.closed) })
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.bug.st/serial/enumerator"
+)
+
+// Встроенный USB-Serial/JTAG контроллер ESP32-S3, ESP32-C3 и новее
+const (
+	ESPRESSIF_USB_VID   = "303A"
+	USB_JTAG_SERIAL_PID = "1001"
+
+	// Сколько ждать исчезновения порта после сброса: при сбросе чипа
+	// встроенный USB отключается от хоста и появляется заново
+	USB_REENUMERATE_WINDOW = 1 * time.Second
+	USB_REENUMERATE_POLL   = 100 * time.Millisecond
+)
+
+// findPortDetails возвращает сведения USB о порте или nil, если порт не найден
+// или не является USB-устройством
+func findPortDetails(portName string) *enumerator.PortDetails {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return nil
+	}
+	for _, port := range ports {
+		if port.Name == portName && port.IsUSB {
+			return port
+		}
+	}
+	return nil
+}
+
+// IsUSBJTAGSerial сообщает, что порт - встроенный USB-Serial/JTAG Espressif
+func IsUSBJTAGSerial(portName string) bool {
+	details := findPortDetails(portName)
+	return details != nil &&
+		strings.EqualFold(details.VID, ESPRESSIF_USB_VID) &&
+		strings.EqualFold(details.PID, USB_JTAG_SERIAL_PID)
+}
+
+// isLocalPort сообщает, что канал - локальный порт, который может пропасть из системы
+func isLocalPort(portName string) bool {
+	return portName != "" && !strings.Contains(portName, "://")
+}
+
+// reopenAfterReenumeration проверяет, не переподключился ли USB после сброса.
+// Если порт исчез, флешер ждет его появления (возможно, под другим именем
+// с тем же серийным номером) и открывает заново
+func (f *ESP32Flasher) reopenAfterReenumeration(ctx context.Context, serialNumber string) error {
+	if !isLocalPort(f.portName) {
+		return nil
+	}
+
+	disappeared := false
+	deadline := time.Now().Add(USB_REENUMERATE_WINDOW)
+	for time.Now().Before(deadline) {
+		if findPortDetails(f.portName) == nil {
+			disappeared = true
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(USB_REENUMERATE_POLL):
+		}
+	}
+	if !disappeared {
+		return nil
+	}
+
+	if f.callback != nil {
+		f.callback.emitLog(fmt.Sprintf("🔌 Порт %s переподключился при сбросе, ожидание...", f.portName))
+	}
+	f.port.Close()
+
+	name := f.portName
+	deadline = time.Now().Add(RECONNECT_TIMEOUT)
+	for {
+		if found := findPortBySerial(serialNumber); found != "" {
+			name = found
+			break
+		}
+		if serialNumber == "" && findPortDetails(name) != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("port %s did not come back after reset", f.portName)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(USB_REENUMERATE_POLL):
+		}
+	}
+
+	if name != f.portName && f.callback != nil {
+		f.callback.emitLog(fmt.Sprintf("🔗 Устройство появилось как %s", name))
+	}
+
+	return f.reopenPort(ctx, name, RECONNECT_TIMEOUT)
+}
+
+// findPortBySerial ищет USB порт по серийному номеру устройства
+func findPortBySerial(serialNumber string) string {
+	if serialNumber == "" {
+		return ""
+	}
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return ""
+	}
+	for _, port := range ports {
+		if port.IsUSB && port.SerialNumber == serialNumber {
+			return port.Name
+		}
+	}
+	return ""
+}
+
+// usbSerialNumber возвращает серийный номер USB устройства текущего порта
+func (f *ESP32Flasher) usbSerialNumber() string {
+	if !isLocalPort(f.portName) {
+		return ""
+	}
+	if details := findPortDetails(f.portName); details != nil {
+		return details.SerialNumber
+	}
+	return ""
+}